@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+
+	"github.com/logscore/roxy/internal/attach"
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// Attach connects this terminal to a detached service's terminal until
+// detachKeys are typed or the service exits.
+func Attach(target, detachKeys string) error {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+	store := config.NewStore(paths.RoutesFile)
+
+	route, err := store.ResolveRoute(target)
+	if err != nil {
+		return err
+	}
+	if route.AttachSocket == "" {
+		return fmt.Errorf("%s has no terminal to attach to; only services started with --detach do", route.Domain)
+	}
+	keys, err := attach.ParseKeys(detachKeys)
+	if err != nil {
+		return err
+	}
+
+	client, err := attach.Dial(route.AttachSocket)
+	if err != nil {
+		return fmt.Errorf("failed to attach to %s: %w", route.Domain, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "attached to %s; press %s to detach\n", route.Domain, detachKeys)
+
+	// A real terminal goes raw so keystrokes (including Ctrl+C) reach the
+	// service, and its size follows this window.
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to set terminal mode: %w", err)
+		}
+		defer func() { _ = term.Restore(fd, state) }()
+
+		resize := func() {
+			if cols, rows, err := term.GetSize(fd); err == nil {
+				_ = client.Resize(uint16(rows), uint16(cols))
+			}
+		}
+		resize()
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				resize()
+			}
+		}()
+	}
+
+	err = client.Run(os.Stdin, os.Stdout, keys)
+	switch {
+	case errors.Is(err, attach.ErrDetached):
+		fmt.Fprintf(os.Stderr, "\r\ndetached from %s\r\n", route.Domain)
+		return nil
+	case err == nil:
+		fmt.Fprintf(os.Stderr, "\r\n%s exited\r\n", route.Domain)
+		return nil
+	default:
+		return err
+	}
+}
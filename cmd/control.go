@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/internal/port"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+const (
+	// stopWaitTimeout is how long RestartRoute waits for the old process to
+	// exit and free its port.
+	stopWaitTimeout = 10 * time.Second
+	// stopPollInterval is how often RestartRoute checks whether it exited.
+	stopPollInterval = 100 * time.Millisecond
+)
+
+// StopRoute sends SIGTERM to a route's process and removes the route.
+func StopRoute(route config.Route) error {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+	store := config.NewStore(paths.RoutesFile)
+
+	if route.PID > 0 {
+		if proc, err := os.FindProcess(route.PID); err == nil {
+			_ = proc.Signal(syscall.SIGTERM)
+		}
+	}
+	if err := store.RemoveRoute(route.Domain); err != nil {
+		return fmt.Errorf("failed to remove route %s: %w", route.Domain, err)
+	}
+	return nil
+}
+
+// StartRoute runs a stopped route's command again in the background, from
+// the directory it was first started in and with the same ID, domain, port
+// and proxy settings.
+func StartRoute(route config.Route) error {
+	if route.Command == "" {
+		return fmt.Errorf("%s has no command to start", route.Domain)
+	}
+
+	args, err := runArgs(runOptionsFromRoute(route))
+	if err != nil {
+		return err
+	}
+	args = append(args, "--detach", "--port", fmt.Sprintf("%d", route.Port), "--id", route.ID)
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	cmd := exec.Command(exePath, args...)
+	cmd.Dir = route.Dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("failed to start %s: %s", route.Domain, msg)
+	}
+	return nil
+}
+
+// RestartRoute restarts a route's process with the same command, port and
+// settings. The route stays registered throughout, so the proxy answers
+// with its 502 page rather than a 404 while the service comes back. The
+// new process runs in the background, as with `roxy run --detach`.
+func RestartRoute(route config.Route) error {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+	store := config.NewStore(paths.RoutesFile)
+
+	if route.Command == "" {
+		return fmt.Errorf("%s has no command to restart", route.Domain)
+	}
+
+	// Detach the route from the old process first, so the old `roxy run`
+	// leaves it registered when it exits (see Store.ReleaseRoute).
+	if err := store.UpdateRoute(route.Domain, func(r *config.Route) { r.PID = 0 }); err != nil {
+		return fmt.Errorf("failed to update route %s: %w", route.Domain, err)
+	}
+	if route.PID > 0 {
+		if proc, err := os.FindProcess(route.PID); err == nil {
+			_ = proc.Signal(syscall.SIGTERM)
+		}
+	}
+
+	deadline := time.Now().Add(stopWaitTimeout)
+	for route.Alive() || port.Available(route.Port) != nil {
+		if time.Now().After(deadline) {
+			// Hand the route back so the old process still cleans it up.
+			_ = store.UpdateRoute(route.Domain, func(r *config.Route) { r.PID = route.PID })
+			return fmt.Errorf("%s did not exit and free port %d within %s", route.Domain, route.Port, stopWaitTimeout)
+		}
+		time.Sleep(stopPollInterval)
+	}
+
+	route.Restarts++
+	if err := StartRoute(route); err != nil {
+		_ = store.RemoveRoute(route.Domain)
+		return err
+	}
+	return nil
+}
+
+// runOptionsFromRoute rebuilds the `roxy run` options a route was started
+// with. The domain and namespace are passed as they are rather than
+// generated again, so a route keeps its custom name and `roxy test`
+// namespace.
+func runOptionsFromRoute(route config.Route) RunOptions {
+	return RunOptions{
+		Command:    route.Command,
+		Domain:     route.Domain,
+		Namespace:  route.Namespace,
+		TLS:        route.TLS,
+		ListenPort: route.ListenPort,
+		Public:     route.Public,
+		Restarts:   route.Restarts,
+
+		Protocol:       route.Protocol,
+		TrustForwarded: route.TrustForwarded,
+		ChangeOrigin:   route.ChangeOrigin,
+		RewriteOrigin:  route.RewriteOrigin,
+		Streaming:      route.Streaming,
+		Headers:        route.Headers,
+		CORS:           route.CORS,
+		Shaping:        route.Shaping,
+		Faults:         route.Faults,
+	}
+}
+
+// routeController lets the proxy dashboard start, stop and restart
+// services through the functions above.
+type routeController struct{}
+
+func (routeController) Start(route config.Route) error   { return StartRoute(route) }
+func (routeController) Stop(route config.Route) error    { return StopRoute(route) }
+func (routeController) Restart(route config.Route) error { return RestartRoute(route) }
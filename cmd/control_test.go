@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// TestMain lets the test binary stand in for roxy when StartRoute runs
+// os.Executable: with ROXY_TEST_ARGS_FILE set, it records its arguments
+// there and exits instead of running the tests.
+func TestMain(m *testing.M) {
+	if path := os.Getenv("ROXY_TEST_ARGS_FILE"); path != "" {
+		_ = os.WriteFile(path, []byte(strings.Join(os.Args[1:], "\n")), 0644)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestRestartRouteKeepsNamespace(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	argsFile := filepath.Join(t.TempDir(), "args")
+	t.Setenv("ROXY_TEST_ARGS_FILE", argsFile)
+
+	paths := platform.GetPaths(platform.Detect())
+	if err := os.MkdirAll(paths.ConfigDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := config.NewStore(paths.RoutesFile)
+	route := config.Route{
+		ID:        "aaa111",
+		Domain:    "web.test-3f9a1c.my-app.test",
+		Port:      45123,
+		Command:   "npm run dev",
+		Dir:       t.TempDir(),
+		Namespace: "test-3f9a1c",
+	}
+	if err := store.AddRoute(route); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestartRoute(route); err != nil {
+		t.Fatalf("RestartRoute: %v", err)
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("roxy was not started again: %v", err)
+	}
+	args := strings.Split(string(data), "\n")
+	for _, want := range [][]string{
+		{"--domain", "web.test-3f9a1c.my-app.test"},
+		{"--namespace", "test-3f9a1c"},
+		{"--id", "aaa111"},
+		{"--restarts", "1"},
+	} {
+		i := slices.Index(args, want[0])
+		if i < 0 || i+1 >= len(args) || args[i+1] != want[1] {
+			t.Errorf("args %q: want %s %s", args, want[0], want[1])
+		}
+	}
+	if slices.Contains(args, "--name") {
+		t.Errorf("args %q: want no --name, the domain is passed as is", args)
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// FaultAdd appends a fault rule to a running route. The proxy picks up the
+// change from routes.json within a poll interval and logs every hit.
+func FaultAdd(target string, rule config.FaultRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	store, route, err := resolveRunningRoute(target)
+	if err != nil {
+		return err
+	}
+	if route.Type == "tcp" {
+		return fmt.Errorf("%s is a TCP route; faults apply to HTTP routes only", route.Domain)
+	}
+
+	if err := store.UpdateRoute(route.Domain, func(r *config.Route) {
+		r.Faults = append(r.Faults, rule)
+	}); err != nil {
+		return fmt.Errorf("failed to update route %s: %w", route.Domain, err)
+	}
+
+	fmt.Printf("%s  #%d %s\n", route.Domain, len(route.Faults)+1, rule)
+	return nil
+}
+
+// FaultClear removes all fault rules from a route.
+func FaultClear(target string) error {
+	store, route, err := resolveRunningRoute(target)
+	if err != nil {
+		return err
+	}
+
+	if err := store.UpdateRoute(route.Domain, func(r *config.Route) {
+		r.Faults = nil
+	}); err != nil {
+		return fmt.Errorf("failed to update route %s: %w", route.Domain, err)
+	}
+
+	fmt.Printf("%s  cleared %d fault rule(s)\n", route.Domain, len(route.Faults))
+	return nil
+}
+
+// FaultList prints a route's fault rules in the order they are checked.
+func FaultList(target string) error {
+	_, route, err := resolveRunningRoute(target)
+	if err != nil {
+		return err
+	}
+
+	if len(route.Faults) == 0 {
+		fmt.Printf("%s  no fault rules\n", route.Domain)
+		return nil
+	}
+	for i, rule := range route.Faults {
+		fmt.Printf("%s  #%d %s\n", route.Domain, i+1, rule)
+	}
+	return nil
+}
+
+// resolveRunningRoute opens the route store and finds target in it.
+func resolveRunningRoute(target string) (*config.Store, *config.Route, error) {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+	store := config.NewStore(paths.RoutesFile)
+
+	route, err := store.ResolveRoute(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, route, nil
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// FormatJSON selects JSON output; any other non-empty format is a Go
+// template. The JSON field names below are a stable interface for scripts:
+// fields may be added, but not renamed or removed.
+const FormatJSON = "json"
+
+// RouteInfo is one route as printed by `roxy list --json`.
+type RouteInfo struct {
+	ID         string    `json:"id"`
+	Domain     string    `json:"domain"`
+	Type       string    `json:"type"`
+	URL        string    `json:"url"`
+	Port       int       `json:"port"`
+	ListenPort int       `json:"listen_port,omitempty"`
+	PID        int       `json:"pid"`
+	Status     string    `json:"status"` // "running", "starting" or "exited"
+	Command    string    `json:"command"`
+	Dir        string    `json:"dir,omitempty"`
+	TLS        bool      `json:"tls"`
+	PublicURL  string    `json:"public_url,omitempty"`
+	LogFile    string    `json:"log_file,omitempty"`
+	Created    time.Time `json:"created"`
+	Restarts   int       `json:"restarts"`
+}
+
+func newRouteInfo(r config.Route) RouteInfo {
+	info := RouteInfo{
+		ID:         r.ID,
+		Domain:     r.Domain,
+		Type:       r.Type,
+		Port:       r.Port,
+		ListenPort: r.ListenPort,
+		PID:        r.PID,
+		Status:     "running",
+		Command:    r.Command,
+		Dir:        r.Dir,
+		TLS:        r.TLS,
+		PublicURL:  r.PublicURL,
+		LogFile:    r.LogFile,
+		Created:    r.Created,
+		Restarts:   r.Restarts,
+	}
+	if info.Type == "" {
+		info.Type = "http"
+	}
+	info.URL = routeURL(info.Type, r.Domain, r.TLS, r.ListenPort)
+	switch {
+	case r.PID == 0:
+		info.Status = "starting"
+	case !r.Alive():
+		info.Status = "exited"
+	}
+	return info
+}
+
+// RunInfo is what `roxy run --json` prints once the route is registered.
+type RunInfo struct {
+	ID         string `json:"id"`
+	Domain     string `json:"domain"`
+	URL        string `json:"url"`
+	Port       int    `json:"port"`
+	ListenPort int    `json:"listen_port,omitempty"`
+	PublicURL  string `json:"public_url,omitempty"`
+	Detached   bool   `json:"detached"`
+	LogFile    string `json:"log_file,omitempty"`
+}
+
+// ProxyStatusInfo is what `roxy proxy status --json` prints.
+type ProxyStatusInfo struct {
+	Running      bool   `json:"running"`
+	PID          int    `json:"pid,omitempty"`
+	HTTPPort     int    `json:"http_port,omitempty"`
+	HTTPSPort    int    `json:"https_port,omitempty"`
+	DNSPort      int    `json:"dns_port,omitempty"`
+	LogFile      string `json:"log_file,omitempty"`
+	AccessLog    string `json:"access_log,omitempty"`
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	MetricsURL   string `json:"metrics_url,omitempty"`
+	Resolver     string `json:"resolver,omitempty"` // resolver config path; empty when not configured
+	TLS          string `json:"tls"`                // "disabled", "enabled" (no CA yet), "generated" or "trusted"
+	Routes       int    `json:"routes"`
+}
+
+// TunnelStatusInfo is what `roxy tunnel status --json` prints.
+type TunnelStatusInfo struct {
+	Provider  string `json:"provider"` // empty when none is configured
+	Supported bool   `json:"supported"`
+	Binary    string `json:"binary,omitempty"`
+	Path      string `json:"path,omitempty"` // resolved binary path; empty when not in PATH
+}
+
+// routeURL returns the address clients use for a route: an http(s) URL, or
+// tcp://<domain>:<listen port> for TCP routes.
+func routeURL(routeType, domain string, tls bool, listenPort int) string {
+	if routeType == "tcp" {
+		return fmt.Sprintf("tcp://%s:%d", domain, listenPort)
+	}
+	if tls {
+		return "https://" + domain
+	}
+	return "http://" + domain
+}
+
+// ValidateFormat checks a --format value before any work is done.
+func ValidateFormat(format string) error {
+	if format == "" || format == FormatJSON {
+		return nil
+	}
+	_, err := parseFormat(format)
+	return err
+}
+
+func parseFormat(format string) (*template.Template, error) {
+	t, err := template.New("format").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return t, nil
+}
+
+// printFormatted writes v to stdout as indented JSON or through the
+// format template, followed by a newline.
+func printFormatted(format string, v any) error {
+	if format == FormatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	t, err := parseFormat(format)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, v); err != nil {
+		return fmt.Errorf("failed to execute --format template: %w", err)
+	}
+	fmt.Println(strings.TrimSuffix(b.String(), "\n"))
+	return nil
+}
+
+// printFormattedList writes items as one JSON array, or runs the format
+// template once per item.
+func printFormattedList[T any](format string, items []T) error {
+	if format == FormatJSON {
+		if items == nil {
+			items = []T{}
+		}
+		return printFormatted(format, items)
+	}
+	for _, item := range items {
+		if err := printFormatted(format, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,7 +9,9 @@ import (
 	"github.com/logscore/roxy/pkg/config"
 )
 
-func List() error {
+// List prints the active routes as a table, or in the given format (see
+// FormatJSON).
+func List(format string) error {
 	p := platform.Detect()
 	paths := platform.GetPaths(p)
 	store := config.NewStore(paths.RoutesFile)
@@ -19,6 +21,14 @@ func List() error {
 		return fmt.Errorf("failed to load routes: %w", err)
 	}
 
+	if format != "" {
+		infos := make([]RouteInfo, 0, len(routes))
+		for _, r := range routes {
+			infos = append(infos, newRouteInfo(r))
+		}
+		return printFormattedList(format, infos)
+	}
+
 	if len(routes) == 0 {
 		fmt.Println("DOMAIN\tPORT\tTYPE\tPID\tCOMMAND")
 		return nil
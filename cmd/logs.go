@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/internal/proxy"
 	"github.com/logscore/roxy/pkg/config"
 )
 
@@ -47,3 +52,175 @@ func Logs(target string) error {
 		}
 	}
 }
+
+// AccessLogOptions configures AccessLogs.
+type AccessLogOptions struct {
+	Target string // route ID or domain
+	Status string // e.g. "5xx", "404" or "4xx,500"; empty matches all
+	Path   string // path prefix, or glob when it contains *, ? or [
+	Raw    bool   // print lines as stored instead of a summary
+}
+
+// AccessLogs prints and then tails the proxy access log for a route.
+func AccessLogs(opts AccessLogOptions) error {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+	store := config.NewStore(paths.RoutesFile)
+
+	// Fall back to treating the target as a domain.
+	domain := opts.Target
+	if route, err := store.ResolveRoute(opts.Target); err == nil {
+		domain = route.Domain
+	}
+	logPath := proxy.AccessLogPath(LogsDir(paths.ConfigDir), domain)
+
+	statuses, err := parseStatusFilter(opts.Status)
+	if err != nil {
+		return err
+	}
+	if opts.Path != "" {
+		if _, err := path.Match(opts.Path, "/"); err != nil {
+			return fmt.Errorf("invalid --path %q: %w", opts.Path, err)
+		}
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no access log for %s yet (has it received any requests?)", domain)
+		}
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	reader := bufio.NewReader(f)
+	var partial string
+	rotated := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// Keep an incomplete last line until the rest is written.
+			partial += line
+			switch {
+			case rotated:
+				// The old file has been read to the end; follow the new one.
+				if next, err := os.Open(logPath); err == nil {
+					_ = f.Close()
+					f = next
+					reader.Reset(f)
+				}
+				rotated = false
+			case isRotated(f, logPath):
+				// Read what was written before the rotation first.
+				rotated = true
+			default:
+				time.Sleep(200 * time.Millisecond)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, partial = partial+line, ""
+
+		e, ok := proxy.ParseAccessEntry(line)
+		if !ok {
+			continue
+		}
+		if !matchStatus(statuses, e.Status) || !matchPath(opts.Path, e.Path) {
+			continue
+		}
+		if opts.Raw {
+			fmt.Print(line)
+		} else {
+			fmt.Println(formatAccessEntry(e))
+		}
+	}
+}
+
+// isRotated reports whether path now names a different file than f, as
+// after the proxy rotated the access log.
+func isRotated(f *os.File, path string) bool {
+	cur, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	next, err := os.Stat(path)
+	return err == nil && !os.SameFile(cur, next)
+}
+
+// parseStatusFilter parses a comma-separated list of status codes and
+// classes ("5xx"). Each class is returned as its hundreds digit, e.g. 5.
+func parseStatusFilter(s string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		if len(part) == 3 && strings.HasSuffix(part, "xx") && part[0] >= '1' && part[0] <= '5' {
+			out = append(out, int(part[0]-'0'))
+			continue
+		}
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid --status %q (want a code like 404 or a class like 5xx)", part)
+		}
+		out = append(out, code)
+	}
+	return out, nil
+}
+
+func matchStatus(filter []int, status int) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if f == status || (f < 10 && status/100 == f) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches the request path (without query) like fault rules do:
+// by prefix, or as a glob when the pattern contains *, ? or [.
+func matchPath(pattern, uri string) bool {
+	if pattern == "" {
+		return true
+	}
+	p, _, _ := strings.Cut(uri, "?")
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, p)
+		return ok
+	}
+	return strings.HasPrefix(p, pattern)
+}
+
+// formatAccessEntry renders an entry as one readable line, e.g.
+// "15:04:05 GET /api/users 200 1.2kB 12ms".
+func formatAccessEntry(e proxy.AccessEntry) string {
+	status := "---"
+	if e.Status != 0 {
+		status = strconv.Itoa(e.Status)
+	}
+	line := fmt.Sprintf("%s %-7s %s %s %s", e.Time.Local().Format("15:04:05"), e.Method, e.Path, status, formatBytes(e.Bytes))
+	if e.DurationMS > 0 {
+		line += fmt.Sprintf(" %s", time.Duration(e.DurationMS*float64(time.Millisecond)).Round(time.Millisecond/10))
+	}
+	if e.UpstreamMS > 0 {
+		line += fmt.Sprintf(" (upstream %s)", time.Duration(e.UpstreamMS*float64(time.Millisecond)).Round(time.Millisecond/10))
+	}
+	return line
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fkB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
@@ -16,11 +16,13 @@ import (
 )
 
 type ProxyOptions struct {
-	TLS       bool
-	HTTPPort  int
-	HTTPSPort int
-	DNSPort   int
-	Detach    bool
+	TLS         bool
+	HTTPPort    int
+	HTTPSPort   int
+	DNSPort     int
+	Detach      bool
+	AccessLog   string // access log format; see proxy.AccessLogJSON
+	MetricsAddr string // extra address serving Prometheus metrics
 }
 
 // ProxyStart launches the proxy as a background daemon.
@@ -70,6 +72,12 @@ func proxyStartDaemon(opts ProxyOptions, paths platform.Paths) error {
 	if opts.DNSPort != 0 {
 		args = append(args, "--dns-port", fmt.Sprintf("%d", opts.DNSPort))
 	}
+	if opts.AccessLog != "" {
+		args = append(args, "--access-log", opts.AccessLog)
+	}
+	if opts.MetricsAddr != "" {
+		args = append(args, "--metrics-addr", opts.MetricsAddr)
+	}
 
 	exePath, err := os.Executable()
 	if err != nil {
@@ -111,12 +119,16 @@ func ProxyRun(opts ProxyOptions) error {
 	}
 
 	srv := proxy.New(proxy.Options{
-		HTTPPort:   opts.HTTPPort,
-		HTTPSPort:  opts.HTTPSPort,
-		DNSPort:    opts.DNSPort,
-		TLS:        opts.TLS,
-		CertsDir:   paths.CertsDir,
-		RoutesFile: paths.RoutesFile,
+		HTTPPort:    opts.HTTPPort,
+		HTTPSPort:   opts.HTTPSPort,
+		DNSPort:     opts.DNSPort,
+		TLS:         opts.TLS,
+		CertsDir:    paths.CertsDir,
+		RoutesFile:  paths.RoutesFile,
+		LogsDir:     LogsDir(paths.ConfigDir),
+		AccessLog:   opts.AccessLog,
+		MetricsAddr: opts.MetricsAddr,
+		Controller:  routeController{},
 	})
 
 	if err := proxy.WritePidFile(paths.ConfigDir); err != nil {
@@ -125,11 +137,13 @@ func ProxyRun(opts ProxyOptions) error {
 	defer proxy.RemovePidFile(paths.ConfigDir)
 
 	if err := proxy.WriteState(paths.ConfigDir, proxy.ProxyState{
-		PID:       os.Getpid(),
-		HTTPPort:  opts.HTTPPort,
-		HTTPSPort: opts.HTTPSPort,
-		DNSPort:   opts.DNSPort,
-		TLS:       opts.TLS,
+		PID:         os.Getpid(),
+		HTTPPort:    opts.HTTPPort,
+		HTTPSPort:   opts.HTTPSPort,
+		DNSPort:     opts.DNSPort,
+		TLS:         opts.TLS,
+		AccessLog:   opts.AccessLog,
+		MetricsAddr: opts.MetricsAddr,
 	}); err != nil {
 		return fmt.Errorf("failed to write proxy state: %w", err)
 	}
@@ -207,61 +221,113 @@ func ProxyRestart(opts ProxyOptions) error {
 	return ProxyRun(opts)
 }
 
-// ProxyStatus prints info about the proxy, DNS server, and TLS.
-func ProxyStatus() error {
+// ProxyStatus prints info about the proxy, DNS server, and TLS, as text or
+// in the given format (see FormatJSON).
+func ProxyStatus(format string) error {
+	info, routesErr := proxyStatus()
+	if format != "" {
+		return printFormatted(format, info)
+	}
+
+	fmt.Println()
+	if info.Running {
+		fmt.Printf("  proxy       running (pid %d)\n", info.PID)
+		if info.HTTPPort != 0 {
+			fmt.Printf("  http port   %d\n", info.HTTPPort)
+			fmt.Printf("  https port  %d\n", info.HTTPSPort)
+			fmt.Printf("  dns port    %d\n", info.DNSPort)
+		}
+	} else {
+		fmt.Printf("  proxy       not running\n")
+	}
+
+	if info.LogFile != "" {
+		fmt.Printf("  logs        %s\n", info.LogFile)
+	}
+	if info.AccessLog != "" {
+		fmt.Printf("  access log  %s\n", info.AccessLog)
+		fmt.Printf("  dashboard   %s\n", info.DashboardURL)
+		fmt.Printf("  metrics     %s\n", info.MetricsURL)
+	}
+
+	if info.Resolver != "" {
+		fmt.Printf("  resolver    %s\n", info.Resolver)
+	} else {
+		fmt.Printf("  resolver    not configured\n")
+	}
+
+	switch info.TLS {
+	case "trusted":
+		fmt.Printf("  tls         CA trusted\n")
+	case "generated":
+		fmt.Printf("  tls         CA generated (not trusted)\n")
+	case "disabled":
+		fmt.Printf("  tls         disabled\n")
+	}
+
+	if routesErr == nil {
+		fmt.Printf("  routes      %d active\n", info.Routes)
+	}
+
+	fmt.Println()
+	return nil
+}
+
+// proxyStatus collects what ProxyStatus prints. The error is from loading
+// routes.json, in which case Routes is 0.
+func proxyStatus() (ProxyStatusInfo, error) {
 	p := platform.Detect()
 	paths := platform.GetPaths(p)
 
 	running := proxy.IsRunning(paths.ConfigDir)
 	state := proxy.ReadState(paths.ConfigDir)
 
-	fmt.Println()
+	info := ProxyStatusInfo{Running: running}
 	if running && state != nil {
-		fmt.Printf("  proxy       running (pid %d)\n", state.PID)
-		fmt.Printf("  http port   %d\n", state.HTTPPort)
-		fmt.Printf("  https port  %d\n", state.HTTPSPort)
-		fmt.Printf("  dns port    %d\n", state.DNSPort)
+		info.PID = state.PID
+		info.HTTPPort = state.HTTPPort
+		info.HTTPSPort = state.HTTPSPort
+		info.DNSPort = state.DNSPort
 	} else if running {
-		pid := proxy.ReadPid(paths.ConfigDir)
-		fmt.Printf("  proxy       running (pid %d)\n", pid)
-	} else {
-		fmt.Printf("  proxy       not running\n")
+		info.PID = proxy.ReadPid(paths.ConfigDir)
 	}
 
 	// Log file
 	logPath := filepath.Join(LogsDir(paths.ConfigDir), "proxy.log")
 	if _, err := os.Stat(logPath); err == nil {
-		fmt.Printf("  logs        %s\n", logPath)
+		info.LogFile = logPath
+	}
+	if state != nil {
+		info.AccessLog = state.AccessLog
+		if info.AccessLog == "" {
+			info.AccessLog = proxy.AccessLogJSON
+		}
+		info.DashboardURL = dashboardURL(state)
+		info.MetricsURL = metricsURL(state)
 	}
 
 	// DNS resolver
 	if _, err := os.ReadFile(paths.ResolverPath); err == nil {
-		fmt.Printf("  resolver    %s\n", paths.ResolverPath)
-	} else {
-		fmt.Printf("  resolver    not configured\n")
+		info.Resolver = paths.ResolverPath
 	}
 
 	// TLS
 	caCertPath := filepath.Join(paths.CertsDir, "ca-cert.pem")
+	info.TLS = "disabled"
 	if state != nil && state.TLS {
+		info.TLS = "enabled"
 		if platform.CATrusted(p, caCertPath) {
-			fmt.Printf("  tls         CA trusted\n")
+			info.TLS = "trusted"
 		} else if _, err := os.Stat(caCertPath); err == nil {
-			fmt.Printf("  tls         CA generated (not trusted)\n")
+			info.TLS = "generated"
 		}
-	} else {
-		fmt.Printf("  tls         disabled\n")
 	}
 
 	// Routes
 	store := config.NewStore(paths.RoutesFile)
 	routes, err := store.LoadRoutes()
-	if err == nil {
-		fmt.Printf("  routes      %d active\n", len(routes))
-	}
-
-	fmt.Println()
-	return nil
+	info.Routes = len(routes)
+	return info, err
 }
 
 // ProxyLogs prints the last 20 lines of the proxy log file, all lines with printAll,
@@ -321,6 +387,22 @@ func ProxyLogs(printAll bool, watch bool) error {
 	}
 }
 
+// dashboardURL returns the running proxy's dashboard address.
+func dashboardURL(state *proxy.ProxyState) string {
+	if state.HTTPPort != 0 && state.HTTPPort != 80 {
+		return fmt.Sprintf("http://roxy.test:%d", state.HTTPPort)
+	}
+	return "http://roxy.test"
+}
+
+// metricsURL returns where the running proxy serves Prometheus metrics.
+func metricsURL(state *proxy.ProxyState) string {
+	if state.MetricsAddr != "" {
+		return "http://" + state.MetricsAddr + "/metrics"
+	}
+	return dashboardURL(state) + "/metrics"
+}
+
 // printProxyStatus prints the proxy configuration on foreground start.
 func printProxyStatus(opts ProxyOptions) {
 	PrintNonStandardPortNotice(opts)
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// configPollInterval is how often a foreground RunAll checks the project
+// config for changes.
+const configPollInterval = 500 * time.Millisecond
+
+// reloader applies edits to the project config to a foreground RunAll: it
+// starts added services, stops removed ones and restarts the ones whose
+// definition changed. An invalid file is reported and the running
+// services are left alone.
+type reloader struct {
+	sup      *supervisor
+	store    *config.Store
+	paths    platform.Paths
+	dir      string
+	cfg      *config.RoxyConfig // the services running now
+	files    []string           // files the config was last read from
+	names    []string           // services named on the command line
+	profiles []string           // --profile
+	required string             // --exit-code-from, which must stay selected
+
+	width  int // name width of output prefixes
+	colors int // colors handed out so far
+
+	out io.Writer // where URLs of new services are printed
+	// notify reports what a reload did, or why it was rejected.
+	notify func(msg string)
+	// updated, if set, is called with every service before added ones
+	// start.
+	updated func(services []*serviceInfo)
+}
+
+// watch reloads the project config whenever it changes, until stop is
+// closed.
+func (r *reloader) watch(stop <-chan struct{}) {
+	last := r.modTime()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(configPollInterval):
+		}
+
+		// A missing file is not an edit: editors may save by replacing it.
+		t := r.modTime()
+		if t.IsZero() || t.Equal(last) {
+			continue
+		}
+		last = t
+		r.reload()
+	}
+}
+
+// modTime returns the newest modification time of the project config
+// files in r.dir, so that replacing roxy.json with roxy.yaml counts as a
+// change too, and of the files it extends.
+func (r *reloader) modTime() time.Time {
+	var t time.Time
+	paths := slices.Clone(r.files)
+	for _, name := range config.RoxyConfigFiles {
+		paths = append(paths, filepath.Join(r.dir, name))
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t
+}
+
+// reload reads the project config again and applies the difference.
+func (r *reloader) reload() {
+	cfg, err := r.load()
+	if err != nil {
+		r.notify(fmt.Sprintf("config not reloaded: %v", err))
+		return
+	}
+	if cfg == nil {
+		return
+	}
+	r.files = cfg.Files()
+	added, removed, changed := r.cfg.Diff(cfg)
+	if len(added)+len(removed)+len(changed) == 0 {
+		return
+	}
+	if !r.sup.hold() {
+		return
+	}
+	defer r.sup.release()
+
+	// applied becomes r.cfg: the services that run once this is done. A
+	// service that fails to start again is left out of it, so the next
+	// reload retries it.
+	applied := maps.Clone(r.cfg.Services)
+	if applied == nil {
+		applied = make(map[string]config.ServiceConfig)
+	}
+	for _, name := range removed {
+		delete(applied, name)
+	}
+
+	// Stop removed and changed services together, then drop their routes.
+	stopping := slices.Concat(removed, changed)
+	old := make(map[string]*serviceInfo, len(stopping))
+	var wg sync.WaitGroup
+	for _, name := range stopping {
+		si := r.sup.lookup(name)
+		if si == nil {
+			continue
+		}
+		old[name] = si
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.sup.stopWait(name)
+		}()
+	}
+	wg.Wait()
+	for _, si := range old {
+		r.sup.remove(si)
+	}
+
+	// Register changed services again, in their old color, and added ones.
+	var fresh []*serviceInfo
+	var failed []string
+	failedNames := make(map[string]bool)
+	for _, name := range slices.Concat(changed, added) {
+		color := colors[r.colors%len(colors)]
+		if si := old[name]; si != nil {
+			color = si.color
+		} else {
+			r.colors++
+		}
+		r.width = max(r.width, len(name))
+		si, err := registerService(name, cfg.Services[name], r.store, r.paths, "", r.dir, color, r.width, r.out)
+		if err != nil {
+			delete(applied, name)
+			failed = append(failed, err.Error())
+			failedNames[name] = true
+			continue
+		}
+		applied[name] = cfg.Services[name]
+		fresh = append(fresh, si)
+	}
+	r.cfg = &config.RoxyConfig{Schema: cfg.Schema, Services: applied}
+	added = slices.DeleteFunc(added, func(name string) bool { return failedNames[name] })
+	changed = slices.DeleteFunc(changed, func(name string) bool { return failedNames[name] })
+
+	if r.updated != nil {
+		services := append(r.sup.services(), fresh...)
+		slices.SortFunc(services, func(a, b *serviceInfo) int { return strings.Compare(a.name, b.name) })
+		r.updated(services)
+	}
+	for _, si := range fresh {
+		r.sup.start(si)
+	}
+
+	var parts []string
+	if len(added) > 0 {
+		parts = append(parts, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, "removed "+strings.Join(removed, ", "))
+	}
+	if len(changed) > 0 {
+		parts = append(parts, "restarted "+strings.Join(changed, ", "))
+	}
+	parts = append(parts, failed...)
+	r.notify("config reloaded: " + strings.Join(parts, "; "))
+}
+
+// load reads the project config and selects the services to run as the command line
+// did. It returns nil, nil if the file is gone.
+func (r *reloader) load() (*config.RoxyConfig, error) {
+	cfg, err := config.LoadRoxyConfig(r.dir)
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	if cfg, err = cfg.Select(r.names, r.profiles); err != nil {
+		return nil, err
+	}
+	if _, ok := cfg.Services[r.required]; r.required != "" && !ok {
+		return nil, fmt.Errorf("service %s is needed by --exit-code-from", r.required)
+	}
+	return cfg, nil
+}
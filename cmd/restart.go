@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/logscore/roxy/internal/domain"
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// Restart restarts the process behind each target, an ID prefix, a domain
+// or a service name from the project config, keeping its domain, port and
+// settings.
+func Restart(targets []string) error {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+	store := config.NewStore(paths.RoutesFile)
+
+	var failed bool
+	for _, target := range targets {
+		route, err := resolveRestartTarget(store, target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+			continue
+		}
+
+		if err := RestartRoute(*route); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = true
+			continue
+		}
+
+		fmt.Printf("%s (%s)\n", route.ID, route.Domain)
+		if route.LogFile == "" {
+			fmt.Printf("  now running in the background; see roxy logs %s\n", route.ID)
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("some routes could not be restarted")
+	}
+	return nil
+}
+
+// resolveRestartTarget finds a route by ID prefix or domain, or by the name
+// of a service in the project config.
+func resolveRestartTarget(store *config.Store, target string) (*config.Route, error) {
+	route, err := store.ResolveRoute(target)
+	if err == nil {
+		return route, nil
+	}
+
+	cfg, cfgErr := config.LoadRoxyConfig(".")
+	if cfgErr != nil || cfg == nil {
+		return nil, err
+	}
+	svc, ok := cfg.Services[target]
+	if !ok {
+		return nil, err
+	}
+	name := svc.Name
+	if name == "" {
+		name = target
+	}
+	dom, domErr := domain.Generate(name)
+	if domErr != nil {
+		return nil, fmt.Errorf("failed to generate domain: %w", domErr)
+	}
+	if route := store.FindRoute(dom); route != nil {
+		return route, nil
+	}
+	return nil, fmt.Errorf("service %s (%s) is not running", target, dom)
+}
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -27,29 +29,47 @@ type RunOptions struct {
 	ID         string // internal: passed from parent when re-execing in detach mode
 	ListenPort int    // TCP mode: proxy listens on this port and forwards to the service
 	Public     bool   // expose via tunnel (requires configured provider)
+	Restarts   int    // internal: restart count carried over by StartRoute
+	Domain     string // internal: domain carried over by StartRoute instead of generating one
+	Namespace  string // internal: `roxy test` namespace carried over by StartRoute
+	Format     string // print RunInfo as JSON or through a template (see FormatJSON)
+	TTY        bool   // run -a: run every service under a pseudo-terminal
+	NoTUI      bool   // run -a: print prefixed output instead of the terminal UI
+
+	AbortOnExit  bool   // run -a: stop every service once one exits
+	ExitCodeFrom string // run -a: exit with this service's exit code (implies AbortOnExit)
+
+	// run -a: the selection, applied again when the project config changes
+	// (see config.RoxyConfig.Select).
+	Services []string
+	Profiles []string
+
+	// Per-route proxy behaviour (see config.Route).
+	Protocol       string                  // upstream protocol: "http" (default) or "h2c"
+	TrustForwarded bool                    // keep incoming X-Forwarded-*/Forwarded chains
+	ChangeOrigin   bool                    // send Host: localhost:<port> upstream
+	RewriteOrigin  bool                    // also rewrite same-site Origin/Referer
+	Streaming      *config.StreamingConfig // flush interval and upstream timeouts
+	Headers        *config.HeaderRules     // request/response header edits (project config only)
+	CORS           *config.CORSConfig      // CORS policy (project config only)
+	Shaping        *config.ShapingConfig   // network simulation (project config or `roxy shape`)
+	Faults         []config.FaultRule      // fault injection (project config or `roxy fault`)
 }
 
-// LogsDir returns the path to the logs directory.
-func LogsDir(configDir string) string {
-	return filepath.Join(configDir, "logs")
-}
-
-func Run(opts RunOptions) error {
-	p := platform.Detect()
-	paths := platform.GetPaths(p)
-
-	// Ensure config directory exists
+// ensureProxy configures the DNS resolver and starts the proxy if needed,
+// reporting what it did to msgs.
+func ensureProxy(p platform.Platform, paths platform.Paths, msgs io.Writer) error {
 	if err := os.MkdirAll(paths.ConfigDir, 0755); err != nil {
 		return fmt.Errorf("failed to create config dir: %w", err)
 	}
 
-	// Auto-configure DNS resolver on first run
+	// DNS resolver setup
 	if !platform.ResolverConfigured(p, paths, 1299) {
 		if err := platform.ConfigureResolver(p, paths, 1299); err != nil {
 			return fmt.Errorf("failed to configure DNS resolver: %w", err)
 		}
-		fmt.Println("done - DNS configured")
-		fmt.Println()
+		_, _ = fmt.Fprintln(msgs, "done - DNS configured")
+		_, _ = fmt.Fprintln(msgs)
 	}
 
 	// Auto-start proxy if not running
@@ -67,6 +87,37 @@ func Run(opts RunOptions) error {
 			return fmt.Errorf("proxy failed to start -- check if port 80 is in use")
 		}
 	}
+	return nil
+}
+
+// LogsDir returns the path to the logs directory.
+func LogsDir(configDir string) string {
+	return filepath.Join(configDir, "logs")
+}
+
+// RunDir returns the directory holding runtime sockets.
+func RunDir(configDir string) string {
+	return filepath.Join(configDir, "run")
+}
+
+// AttachSocketPath returns the `roxy attach` socket for a detached route.
+func AttachSocketPath(configDir, id string) string {
+	return filepath.Join(RunDir(configDir), id+".sock")
+}
+
+func Run(opts RunOptions) error {
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+
+	// With --json/--format, stdout only carries the RunInfo.
+	msgs := os.Stdout
+	if opts.Format != "" {
+		msgs = os.Stderr
+	}
+
+	if err := ensureProxy(p, paths, msgs); err != nil {
+		return err
+	}
 
 	// Auto-trust CA cert on first --tls use
 	if opts.TLS {
@@ -84,8 +135,8 @@ func Run(opts RunOptions) error {
 					fmt.Fprintf(os.Stderr, "warning: failed to trust CA cert: %v\n", err)
 					fmt.Fprintf(os.Stderr, "HTTPS may show certificate warnings in browsers.\n\n")
 				} else {
-					fmt.Println("done - CA certificate trusted")
-					fmt.Println()
+					_, _ = fmt.Fprintln(msgs, "done - CA certificate trusted")
+					_, _ = fmt.Fprintln(msgs)
 				}
 			}
 		}
@@ -97,27 +148,44 @@ func Run(opts RunOptions) error {
 	if pruned, err := store.PruneStaleRoutes(); err != nil {
 		fmt.Fprintf(os.Stderr, "warning: failed to prune stale routes: %v\n", err)
 	} else if pruned > 0 {
-		fmt.Printf("cleaned up %d stale route(s)\n", pruned)
+		_, _ = fmt.Fprintf(msgs, "cleaned up %d stale route(s)\n", pruned)
 	}
 
-	// Find available port (checks both OS and routes.json)
-	assignedPort, err := port.Find(opts.StartPort, paths.RoutesFile)
-	if err != nil {
-		return fmt.Errorf("failed to find available port: %w", err)
+	// Generate domain, unless restarting a route that has one
+	var err error
+	dom := opts.Domain
+	if dom == "" {
+		dom, err = domain.Generate(opts.Name)
+		if err != nil {
+			return fmt.Errorf("failed to generate domain: %w", err)
+		}
 	}
 
-	// Generate domain
-	dom, err := domain.Generate(opts.Name)
-	if err != nil {
-		return fmt.Errorf("failed to generate domain: %w", err)
-	}
+	var assignedPort int
+	if existing := store.FindRoute(dom); existing != nil && opts.ID != "" && existing.ID == opts.ID {
+		// A restart (see RestartRoute) takes over its own route and port,
+		// which stay registered in the meantime.
+		if opts.StartPort != existing.Port {
+			return fmt.Errorf("restart of %s must keep port %d", dom, existing.Port)
+		}
+		if err := port.Available(existing.Port); err != nil {
+			return fmt.Errorf("port %d is not available: %w", existing.Port, err)
+		}
+		assignedPort = existing.Port
+	} else {
+		// Check for domain conflict with an already-running process
+		if existing != nil {
+			return fmt.Errorf(
+				"domain %s is already in use (pid %d, port %d); to run another service on this project, use --name: roxy run %q --name <service-name>",
+				dom, existing.PID, existing.Port, opts.Command,
+			)
+		}
 
-	// Check for domain conflict with an already-running process
-	if existing := store.FindRoute(dom); existing != nil {
-		return fmt.Errorf(
-			"domain %s is already in use (pid %d, port %d); to run another service on this project, use --name: roxy run %q --name <service-name>",
-			dom, existing.PID, existing.Port, opts.Command,
-		)
+		// Find available port (checks both OS and routes.json)
+		assignedPort, err = port.Find(opts.StartPort, paths.RoutesFile)
+		if err != nil {
+			return fmt.Errorf("failed to find available port: %w", err)
+		}
 	}
 
 	scheme := "http"
@@ -167,13 +235,66 @@ func Run(opts RunOptions) error {
 	}
 
 	// Print URL up front unless --public (spawn.go prints local + tunnel together after tunnel connects)
-	if !opts.Public {
+	if opts.Format != "" {
+		info := RunInfo{
+			ID:         id,
+			Domain:     dom,
+			URL:        routeURL(routeType(opts.ListenPort), dom, opts.TLS, opts.ListenPort),
+			Port:       assignedPort,
+			ListenPort: opts.ListenPort,
+		}
+		if err := printFormatted(opts.Format, info); err != nil {
+			return err
+		}
+	} else if !opts.Public {
 		fmt.Println()
 		fmt.Printf("  %s\n", localURL)
 		fmt.Println()
 	}
 
-	return process.Run(id, opts.Command, assignedPort, dom, opts.TLS, opts.ListenPort, tunnelProvider, localURL, store, paths.ConfigDir, opts.LogFile)
+	dir, _ := os.Getwd()
+
+	// Foreground output goes to the terminal; keep a copy of the recent part
+	// so the proxy can show it when the service is unreachable. Detached
+	// services (the re-exec'd child, which has a log file) get a terminal
+	// for `roxy attach` instead.
+	outputFile, attachSocket := "", ""
+	if opts.LogFile != "" {
+		runDir := RunDir(paths.ConfigDir)
+		if err := os.MkdirAll(runDir, 0700); err == nil {
+			attachSocket = AttachSocketPath(paths.ConfigDir, id)
+		}
+	} else {
+		logsDir := LogsDir(paths.ConfigDir)
+		if err := os.MkdirAll(logsDir, 0755); err == nil {
+			outputFile = filepath.Join(logsDir, dom+".out")
+		}
+	}
+
+	return process.Run(config.Route{
+		ID:           id,
+		Domain:       dom,
+		Port:         assignedPort,
+		ListenPort:   opts.ListenPort,
+		TLS:          opts.TLS,
+		Command:      opts.Command,
+		LogFile:      opts.LogFile,
+		OutputFile:   outputFile,
+		AttachSocket: attachSocket,
+		Dir:          dir,
+		Restarts:     opts.Restarts,
+		Namespace:    opts.Namespace,
+
+		Protocol:       opts.Protocol,
+		TrustForwarded: opts.TrustForwarded,
+		ChangeOrigin:   opts.ChangeOrigin,
+		RewriteOrigin:  opts.RewriteOrigin,
+		Streaming:      opts.Streaming,
+		Headers:        opts.Headers,
+		CORS:           opts.CORS,
+		Shaping:        opts.Shaping,
+		Faults:         opts.Faults,
+	}, tunnelProvider, localURL, store)
 }
 
 func runDetached(opts RunOptions, paths platform.Paths, dom string, id string, assignedPort int, scheme string) error {
@@ -195,21 +316,11 @@ func runDetached(opts RunOptions, paths platform.Paths, dom string, id string, a
 		return fmt.Errorf("failed to find executable: %w", err)
 	}
 
-	args := []string{"run", opts.Command}
-	args = append(args, "--port", fmt.Sprintf("%d", assignedPort))
-	if opts.Name != "" {
-		args = append(args, "--name", opts.Name)
-	}
-	if opts.TLS {
-		args = append(args, "--tls")
-	}
-	if opts.ListenPort > 0 {
-		args = append(args, "--listen-port", fmt.Sprintf("%d", opts.ListenPort))
-	}
-	if opts.Public {
-		args = append(args, "--public")
+	args, err := runArgs(opts)
+	if err != nil {
+		return err
 	}
-	// Pass internal flags so the child records them in the route
+	args = append(args, "--port", fmt.Sprintf("%d", assignedPort))
 	args = append(args, "--log-file", logPath)
 	args = append(args, "--id", id)
 
@@ -241,6 +352,19 @@ func runDetached(opts RunOptions, paths platform.Paths, dom string, id string, a
 		}
 	}
 
+	if opts.Format != "" {
+		return printFormatted(opts.Format, RunInfo{
+			ID:         id,
+			Domain:     dom,
+			URL:        routeURL(routeType(opts.ListenPort), dom, opts.TLS, opts.ListenPort),
+			Port:       assignedPort,
+			ListenPort: opts.ListenPort,
+			PublicURL:  publicURL,
+			Detached:   true,
+			LogFile:    logPath,
+		})
+	}
+
 	fmt.Println()
 	if opts.Public {
 		fmt.Printf("  \x1b[90mlocal:\x1b[0m   %s\n", localURL)
@@ -258,3 +382,91 @@ func runDetached(opts RunOptions, paths platform.Paths, dom string, id string, a
 
 	return nil
 }
+
+// routeType returns the route type for a service with the given listen port.
+func routeType(listenPort int) string {
+	if listenPort > 0 {
+		return "tcp"
+	}
+	return "http"
+}
+
+// runArgs returns the `roxy run` arguments that reproduce opts in a child
+// process, except --detach, --port, --log-file and --id.
+func runArgs(opts RunOptions) ([]string, error) {
+	args := []string{"run", opts.Command}
+	if opts.Name != "" {
+		args = append(args, "--name", opts.Name)
+	}
+	if opts.TLS {
+		args = append(args, "--tls")
+	}
+	if opts.ListenPort > 0 {
+		args = append(args, "--listen-port", fmt.Sprintf("%d", opts.ListenPort))
+	}
+	if opts.Public {
+		args = append(args, "--public")
+	}
+	if opts.Protocol != "" {
+		args = append(args, "--protocol", opts.Protocol)
+	}
+	if opts.TrustForwarded {
+		args = append(args, "--trust-forwarded")
+	}
+	if opts.ChangeOrigin {
+		args = append(args, "--change-origin")
+	}
+	if opts.RewriteOrigin {
+		args = append(args, "--rewrite-origin")
+	}
+	if st := opts.Streaming; st != nil {
+		if st.FlushInterval != 0 {
+			args = append(args, "--flush-interval", time.Duration(st.FlushInterval).String())
+		}
+		if st.ResponseHeaderTimeout != 0 {
+			args = append(args, "--response-header-timeout", time.Duration(st.ResponseHeaderTimeout).String())
+		}
+		if st.IdleTimeout != 0 {
+			args = append(args, "--idle-timeout", time.Duration(st.IdleTimeout).String())
+		}
+	}
+	// Internal flags, so the child records them in the route
+	if opts.Headers != nil {
+		headers, err := json.Marshal(opts.Headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode header rules: %w", err)
+		}
+		args = append(args, "--headers", string(headers))
+	}
+	if opts.CORS != nil {
+		cors, err := json.Marshal(opts.CORS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode cors policy: %w", err)
+		}
+		args = append(args, "--cors", string(cors))
+	}
+	if opts.Shaping != nil {
+		shaping, err := json.Marshal(opts.Shaping)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode shaping settings: %w", err)
+		}
+		args = append(args, "--shaping", string(shaping))
+	}
+	if len(opts.Faults) > 0 {
+		faults, err := json.Marshal(opts.Faults)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode fault rules: %w", err)
+		}
+		args = append(args, "--faults", string(faults))
+	}
+	if opts.Restarts > 0 {
+		args = append(args, "--restarts", fmt.Sprintf("%d", opts.Restarts))
+	}
+	if opts.Domain != "" {
+		args = append(args, "--domain", opts.Domain)
+	}
+	if opts.Namespace != "" {
+		args = append(args, "--namespace", opts.Namespace)
+	}
+	return args, nil
+}
@@ -1,20 +1,26 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
+	"golang.org/x/term"
+
 	"github.com/logscore/roxy/internal/domain"
 	"github.com/logscore/roxy/internal/platform"
 	"github.com/logscore/roxy/internal/port"
-	"github.com/logscore/roxy/internal/proxy"
+	"github.com/logscore/roxy/internal/process"
+	"github.com/logscore/roxy/internal/tui"
 	"github.com/logscore/roxy/pkg/config"
 )
 
@@ -30,11 +36,21 @@ var colors = []string{
 
 const colorReset = "\x1b[0m"
 
+// ExitCodeError makes roxy exit with Code. What failed has already been
+// reported, e.g. in RunAll's exit summary.
+type ExitCodeError struct {
+	Code int
+}
+
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
 // RunAll starts all services from a RoxyConfig concurrently with prefixed output.
 // callerOpts carries CLI flags (e.g. --detach, --public) that apply to every service.
 func RunAll(cfg *config.RoxyConfig, callerOpts RunOptions) error {
 	if len(cfg.Services) == 0 {
-		return fmt.Errorf("no services defined in roxy.json")
+		return fmt.Errorf("no services defined in %s", cfg.FileName())
 	}
 
 	// Sort service names for deterministic port assignment and color order.
@@ -71,211 +87,821 @@ func RunAll(cfg *config.RoxyConfig, callerOpts RunOptions) error {
 	p := platform.Detect()
 	paths := platform.GetPaths(p)
 
-	if err := os.MkdirAll(paths.ConfigDir, 0755); err != nil {
-		return fmt.Errorf("failed to create config dir: %w", err)
+	if err := ensureProxy(p, paths, os.Stdout); err != nil {
+		return err
+	}
+
+	store := config.NewStore(paths.RoutesFile)
+
+	// Prune stale routes
+	if pruned, err := store.PruneStaleRoutes(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to prune stale routes: %v\n", err)
+	} else if pruned > 0 {
+		fmt.Printf("cleaned up %d stale route(s)\n", pruned)
+	}
+
+	logsDir := LogsDir(paths.ConfigDir)
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create logs dir: %w", err)
+	}
+
+	dir, _ := os.Getwd()
+	services, err := registerServices(cfg, names, store, paths, "", os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	maxLen := nameWidth(names)
+
+	// Signal handling: first Ctrl+C -> SIGTERM all; second -> SIGKILL all.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	sup := &supervisor{
+		store:    store,
+		logsDir:  logsDir,
+		forceTTY: callerOpts.TTY,
+		procs:    make(map[string]*serviceProc),
+		pids:     make(map[string]int),
+		results:  make(map[string]*exitResult),
+		exits:    make(chan *serviceInfo, 1),
+	}
+
+	// Remove the routes on exit, except ones a restart has taken over.
+	defer func() { sup.cleanup(sup.services()) }()
+
+	// Edits to the project config are applied while the services run.
+	rl := &reloader{
+		sup:      sup,
+		store:    store,
+		paths:    paths,
+		dir:      dir,
+		cfg:      cfg,
+		files:    cfg.Files(),
+		names:    callerOpts.Services,
+		profiles: callerOpts.Profiles,
+		required: callerOpts.ExitCodeFrom,
+		width:    maxLen,
+		colors:   len(names),
+	}
+
+	// The terminal UI needs a terminal to draw on and to read keys from;
+	// otherwise each service's output is printed with a prefix.
+	var app *tui.App
+	if !callerOpts.NoTUI && term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stdin.Fd())) {
+		app, rl.updated = newRunAllApp(sup, services, p)
+		rl.out = io.Discard
+		rl.notify = app.Notify
+	} else {
+		// Services with tty set run under a pseudo-terminal sized to ours,
+		// less the width of the prefix.
+		lines := &termLines{tty: term.IsTerminal(int(os.Stdout.Fd()))}
+		prefixWidth := maxLen + 3 // "[name] "
+		sup.ptySize = func() (rows, cols uint16) {
+			w, h, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil || w <= prefixWidth {
+				return 0, 0
+			}
+			return uint16(h), uint16(w - prefixWidth)
+		}
+		sup.output = func(si *serviceInfo) (io.Writer, io.Writer) {
+			return newPrefixWriter(si.prefix, os.Stdout, lines), newPrefixWriter(si.prefix, os.Stderr, lines)
+		}
+		// Reload notices share the lock, so they never land inside a
+		// service's unfinished line.
+		notices := newPrefixWriter("", os.Stdout, lines)
+		rl.out = notices
+		rl.notify = func(msg string) { _, _ = fmt.Fprintln(notices, msg) }
 	}
 
-	// DNS resolver setup
-	if !platform.ResolverConfigured(p, paths, 1299) {
-		if err := platform.ConfigureResolver(p, paths, 1299); err != nil {
-			return fmt.Errorf("failed to configure DNS resolver: %w", err)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			sup.resize()
 		}
-		fmt.Println("done - DNS configured")
-		fmt.Println()
+	}()
+
+	for _, si := range services {
+		sup.start(si)
+	}
+
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go rl.watch(stopWatch)
+
+	// --abort-on-exit: stop everything once any service ends on its own.
+	var exits <-chan *serviceInfo
+	if callerOpts.AbortOnExit || callerOpts.ExitCodeFrom != "" {
+		exits = sup.exits
 	}
 
-	// Auto-start proxy if not running
-	if !proxy.IsRunning(paths.ConfigDir) {
-		if err := ProxyStart(ProxyOptions{HTTPPort: 80, TLS: true, HTTPSPort: 443, DNSPort: 1299}); err != nil {
-			return fmt.Errorf("failed to start proxy: %w", err)
+	stopAll := func(done <-chan struct{}) {
+		fmt.Println("Stopping all services...")
+		sup.shutdown()
+		sup.signalAll(syscall.SIGTERM)
+
+		// Second signal: force kill.
+		select {
+		case <-sigChan:
+			fmt.Println("\nForce killing all services...")
+			sup.signalAll(syscall.SIGKILL)
+			<-done
+		case <-done:
 		}
-		for range proxy.ProxyStartRetries {
-			time.Sleep(proxy.ProxyStartRetryInterval)
-			if proxy.IsRunning(paths.ConfigDir) {
-				break
+	}
+
+	if app != nil {
+		// The UI runs until the user quits, even if every service exits.
+		quit := make(chan struct{})
+		watched := make(chan struct{})
+		var aborted *serviceInfo
+		go func() {
+			defer close(watched)
+			select {
+			case <-sigChan:
+				app.Stop()
+			case aborted = <-exits:
+				app.Stop()
+			case <-quit:
 			}
+		}()
+		err := app.Run(os.Stdin, os.Stdout)
+		close(quit)
+		<-watched
+		sup.shutdown()
+		if aborted != nil {
+			fmt.Printf("%s exited (--abort-on-exit)\n", aborted.name)
 		}
-		if !proxy.IsRunning(paths.ConfigDir) {
-			return fmt.Errorf("proxy failed to start -- check if port 80 is in use")
+		stopAll(sup.done())
+		if err != nil {
+			return err
 		}
+		return sup.summary(sup.services(), callerOpts.ExitCodeFrom)
 	}
 
-	store := config.NewStore(paths.RoutesFile)
-
-	// Prune stale routes
-	if pruned, err := store.PruneStaleRoutes(); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: failed to prune stale routes: %v\n", err)
-	} else if pruned > 0 {
-		fmt.Printf("cleaned up %d stale route(s)\n", pruned)
+	// Wait for signal or all processes to exit.
+	done := sup.done()
+	select {
+	case <-sigChan:
+		fmt.Println()
+		stopAll(done)
+	case si := <-exits:
+		fmt.Printf("\n%s exited (--abort-on-exit)\n", si.name)
+		stopAll(done)
+	case <-done:
 	}
 
-	// Compute max name length for aligned prefixes.
+	return sup.summary(sup.services(), callerOpts.ExitCodeFrom)
+}
+
+// nameWidth returns the length of the longest name, for aligned prefixes.
+func nameWidth(names []string) int {
 	maxLen := 0
 	for _, name := range names {
-		if len(name) > maxLen {
-			maxLen = len(name)
+		maxLen = max(maxLen, len(name))
+	}
+	return maxLen
+}
+
+// registerServices assigns each named service a port and domain, registers
+// its route and prints its URL to out. With a namespace, domains get an
+// extra label (web.<namespace>.app.test) and TCP services a free listen
+// port, so they don't collide with routes that are already running. On
+// error, the routes registered so far are removed.
+func registerServices(cfg *config.RoxyConfig, names []string, store *config.Store, paths platform.Paths, namespace string, out io.Writer) ([]*serviceInfo, error) {
+	dir, _ := os.Getwd()
+	width := nameWidth(names)
+
+	services := make([]*serviceInfo, 0, len(names))
+	for i, name := range names {
+		si, err := registerService(name, cfg.Services[name], store, paths, namespace, dir, colors[i%len(colors)], width, out)
+		if err != nil {
+			for _, si := range services {
+				_ = store.RemoveRoute(si.domain)
+			}
+			return nil, err
 		}
+		services = append(services, si)
 	}
+	return services, nil
+}
 
-	// Pre-assign ports and domains, build child commands.
-	type serviceInfo struct {
-		name   string
-		svc    config.ServiceConfig
-		port   int
-		domain string
-		id     string
-		color  string
-		prefix string
+// registerService registers the route of one service for registerServices
+// or a reload. Its output prefix is padded to width.
+func registerService(name string, svc config.ServiceConfig, store *config.Store, paths platform.Paths, namespace, dir, color string, width int, out io.Writer) (*serviceInfo, error) {
+	assignedPort, err := port.Find(svc.Port, paths.RoutesFile)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: failed to find port: %w", name, err)
 	}
 
-	services := make([]serviceInfo, 0, len(names))
-	for i, name := range names {
-		svc := cfg.Services[name]
+	svcName := svc.Name
+	if svcName == "" {
+		svcName = name
+	}
+	dom, err := domain.Generate(svcName)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: failed to generate domain: %w", name, err)
+	}
 
-		assignedPort, err := port.Find(svc.Port, paths.RoutesFile)
-		if err != nil {
-			return fmt.Errorf("service %s: failed to find port: %w", name, err)
+	listenPort := svc.ListenPort
+	if namespace != "" {
+		dom = domain.WithNamespace(dom, namespace)
+		if listenPort > 0 {
+			if listenPort, err = port.Find(0, paths.RoutesFile); err != nil {
+				return nil, fmt.Errorf("service %s: failed to find listen port: %w", name, err)
+			}
+		}
+	}
+
+	if existing := store.FindRoute(dom); existing != nil {
+		return nil, fmt.Errorf("service %s: domain %s already in use (pid %d)", name, dom, existing.PID)
+	}
+
+	id := config.GenerateID(dom)
+	prefix := fmt.Sprintf("%s[%-*s]%s ", color, width, name, colorReset)
+
+	routeType := "http"
+	if listenPort > 0 {
+		routeType = "tcp"
+	}
+
+	// Register route so port.Find won't reassign it to the next service.
+	if err := store.AddRoute(config.Route{
+		ID:         id,
+		Domain:     dom,
+		Port:       assignedPort,
+		ListenPort: listenPort,
+		Type:       routeType,
+		TLS:        svc.TLS,
+		Command:    svc.Cmd,
+		Created:    time.Now(),
+		Dir:        dir,
+		OutputFile: filepath.Join(LogsDir(paths.ConfigDir), dom+".out"),
+		Namespace:  namespace,
+
+		Protocol:       svc.Protocol,
+		TrustForwarded: svc.TrustForwarded,
+		ChangeOrigin:   svc.ChangeOrigin,
+		RewriteOrigin:  svc.RewriteOrigin,
+		Streaming:      svc.Streaming,
+		Headers:        svc.Headers,
+		CORS:           svc.CORS,
+		Shaping:        svc.Shaping,
+		Faults:         svc.Faults,
+	}); err != nil {
+		return nil, fmt.Errorf("service %s: failed to register route: %w", name, err)
+	}
+
+	url := routeURL(routeType, dom, svc.TLS, listenPort)
+	_, _ = fmt.Fprintf(out, "  %s%s%s  %s\n", color, name, colorReset, url)
+
+	return &serviceInfo{
+		name:       name,
+		svc:        svc,
+		port:       assignedPort,
+		listenPort: listenPort,
+		domain:     dom,
+		id:         id,
+		color:      color,
+		prefix:     prefix,
+		url:        url,
+	}, nil
+}
+
+// newRunAllApp returns the terminal UI for services. Its key bindings
+// restart and stop them through sup. Call update with every service when
+// they change, before added ones start.
+func newRunAllApp(sup *supervisor, services []*serviceInfo, p platform.Platform) (app *tui.App, update func([]*serviceInfo)) {
+	var mu sync.Mutex
+	views := make(map[string]*tui.Service, len(services))
+	list := make([]*tui.Service, 0, len(services))
+	for _, si := range services {
+		v := tui.NewService(si.name, si.url, si.color)
+		views[si.name] = v
+		list = append(list, v)
+	}
+	view := func(si *serviceInfo) *tui.Service {
+		mu.Lock()
+		defer mu.Unlock()
+		return views[si.name]
+	}
+
+	app = tui.New(list, tui.Actions{
+		Restart: func(name string) { go sup.restart(name) },
+		Stop:    func(name string) { sup.stop(name) },
+		Open:    func(url string) error { return platform.OpenURL(p, url) },
+	})
+
+	update = func(services []*serviceInfo) {
+		mu.Lock()
+		old := views
+		views = make(map[string]*tui.Service, len(services))
+		list := make([]*tui.Service, 0, len(services))
+		for _, si := range services {
+			v := old[si.name]
+			if v == nil || v.URL != si.url {
+				// A changed service keeps its output.
+				nv := tui.NewService(si.name, si.url, si.color)
+				if v != nil {
+					nv.Log = v.Log
+				}
+				v = nv
+			}
+			views[si.name] = v
+			list = append(list, v)
 		}
+		mu.Unlock()
+		app.SetServices(list)
+	}
 
-		svcName := svc.Name
-		if svcName == "" {
-			svcName = name
+	sup.output = func(si *serviceInfo) (io.Writer, io.Writer) {
+		log := view(si).Log
+		return log, log
+	}
+	sup.changed = func(si *serviceInfo, status tui.Status, detail string) {
+		// A removed service is no longer shown.
+		if v := view(si); v != nil {
+			v.SetStatus(status, detail)
 		}
-		dom, err := domain.Generate(svcName)
+	}
+	sup.ptySize = func() (rows, cols uint16) {
+		w, h, err := term.GetSize(int(os.Stdout.Fd()))
 		if err != nil {
-			return fmt.Errorf("service %s: failed to generate domain: %w", name, err)
+			return 0, 0
 		}
+		r, c := app.PaneSize(w, h)
+		return uint16(r), uint16(c)
+	}
+	return app, update
+}
+
+// serviceInfo is a service of a foreground RunAll with its assigned port
+// and domain.
+type serviceInfo struct {
+	name       string
+	svc        config.ServiceConfig
+	port       int
+	listenPort int
+	domain     string
+	id         string
+	color      string
+	prefix     string
+	url        string
+}
+
+// supervisor runs the processes of a foreground RunAll and restarts or
+// stops them on request.
+type supervisor struct {
+	store    *config.Store
+	logsDir  string
+	forceTTY bool // --tty: every service runs under a pseudo-terminal
+
+	// output returns where a service's stdout and stderr go.
+	output func(si *serviceInfo) (stdout, stderr io.Writer)
+	// ptySize returns the size for services under a pseudo-terminal; zero
+	// means the default.
+	ptySize func() (rows, cols uint16)
+	// changed, if set, is told when a service starts, stops or exits.
+	changed func(si *serviceInfo, status tui.Status, detail string)
+
+	mu         sync.Mutex
+	infos      map[string]*serviceInfo // every service started, by name
+	procs      map[string]*serviceProc // running, by service name
+	pids       map[string]int          // last PID, by domain
+	restarting map[string]bool
+	closing    bool // shutting down; nothing more is started
+	waiting    bool // done has been called
+	wg         sync.WaitGroup
+
+	results map[string]*exitResult // how each service last ended, by name
+	ended   int                    // count of results recorded
+	exits   chan *serviceInfo      // services that ended on their own
+}
+
+// exitResult is how a service's process ended.
+type exitResult struct {
+	seq      int // order in which services ended
+	code     int // exit code, or 128+signal if killed
+	detail   string
+	startErr error
+	stopped  bool // stopped by roxy rather than exited on its own
+}
+
+// describe returns "exit code N" or the signal that killed the process.
+func (r *exitResult) describe() string {
+	if _, err := strconv.Atoi(r.detail); err == nil {
+		return "exit code " + r.detail
+	}
+	return r.detail
+}
 
-		if existing := store.FindRoute(dom); existing != nil {
-			return fmt.Errorf("service %s: domain %s already in use (pid %d)", name, dom, existing.PID)
+// failed reports whether the service failed on its own.
+func (r *exitResult) failed() bool {
+	return r.startErr != nil || !r.stopped && r.code != 0
+}
+
+// record stores how a service ended and, unless roxy stopped it, reports
+// it on exits. Caller must hold mu.
+func (s *supervisor) record(si *serviceInfo, r *exitResult) {
+	s.ended++
+	r.seq = s.ended
+	s.results[si.name] = r
+	if !r.stopped {
+		select {
+		case s.exits <- si:
+		default:
 		}
+	}
+}
+
+// serviceProc is a running service process.
+type serviceProc struct {
+	si      *serviceInfo
+	cmd     *exec.Cmd
+	tty     *process.PTY
+	done    chan struct{} // closed once it has exited
+	stopped bool          // stopped on request rather than exited
+}
 
-		id := config.GenerateID(dom)
-		color := colors[i%len(colors)]
-		prefix := fmt.Sprintf("%s[%-*s]%s ", color, maxLen, name, colorReset)
+// signal sends sig to the service's process group, so the shell's children
+// get it too.
+func (p *serviceProc) signal(sig syscall.Signal) {
+	_ = syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+func (s *supervisor) notify(si *serviceInfo, status tui.Status, detail string) {
+	if s.changed != nil {
+		s.changed(si, status, detail)
+	}
+}
 
-		routeType := "http"
-		if svc.ListenPort > 0 {
-			routeType = "tcp"
+// start starts a service's process and registers its PID on the route.
+func (s *supervisor) start(si *serviceInfo) {
+	stdout, stderr := s.output(si)
+
+	cmd := exec.Command("sh", "-c", si.svc.Cmd)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("PORT=%d", si.port),
+		"HOST=127.0.0.1",
+	)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	// Keep a copy of recent output for the proxy's error page
+	out, err := process.NewOutputBuffer(s.outputFile(si))
+	if err == nil {
+		cmd.Stdout = io.MultiWriter(stdout, out)
+		cmd.Stderr = io.MultiWriter(stderr, out)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.infos == nil {
+		s.infos = make(map[string]*serviceInfo)
+	}
+	s.infos[si.name] = si
+	if s.closing {
+		if out != nil {
+			out.Close()
 		}
+		return
+	}
+
+	var tty *process.PTY
+	if si.svc.TTY || s.forceTTY {
+		rows, cols := s.ptySize()
+		output := cmd.Stdout
+		tty, err = process.StartPTY(cmd, rows, cols)
+		if err == nil {
+			tty.CopyTo(output)
+		}
+	} else {
+		// A process group of its own lets signal reach the processes
+		// the shell starts; a pseudo-terminal gives it a session.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		err = cmd.Start()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to start: %v\n", err)
+		if out != nil {
+			out.Close()
+		}
+		s.record(si, &exitResult{code: 1, startErr: err})
+		s.notify(si, tui.Failed, err.Error())
+		return
+	}
+
+	_ = s.store.UpdateRoute(si.domain, func(r *config.Route) {
+		r.PID = cmd.Process.Pid
+	})
+
+	proc := &serviceProc{si: si, cmd: cmd, tty: tty, done: make(chan struct{})}
+	s.procs[si.name] = proc
+	s.pids[si.domain] = cmd.Process.Pid
+	s.wg.Add(1)
+	go s.wait(si, proc, out, stderr)
+	s.notify(si, tui.Running, "")
+}
+
+func (s *supervisor) wait(si *serviceInfo, proc *serviceProc, out *process.OutputBuffer, stderr io.Writer) {
+	defer s.wg.Done()
+	defer close(proc.done)
+
+	err := proc.cmd.Wait()
+	if proc.tty != nil {
+		proc.tty.Close()
+	}
+	if out != nil {
+		out.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "exited: %v\n", err)
+	}
+
+	s.mu.Lock()
+	stopped := proc.stopped
+	if s.procs[si.name] == proc {
+		delete(s.procs, si.name)
+	}
+	s.record(si, &exitResult{code: exitCode(err), detail: exitDetail(err), stopped: stopped})
+	s.mu.Unlock()
+
+	switch {
+	case stopped:
+		s.notify(si, tui.Stopped, "")
+	default:
+		s.notify(si, tui.Exited, exitDetail(err))
+	}
+}
 
-		// Register route so port.Find won't reassign it to the next service.
-		if err := store.AddRoute(config.Route{
-			ID:         id,
-			Domain:     dom,
-			Port:       assignedPort,
-			ListenPort: svc.ListenPort,
-			Type:       routeType,
-			TLS:        svc.TLS,
-			Command:    svc.Cmd,
-			Created:    time.Now(),
-		}); err != nil {
-			return fmt.Errorf("service %s: failed to register route: %w", name, err)
+// summary prints how each service ended and returns an ExitCodeError if
+// RunAll should fail: with the exit code of exitCodeFrom if set, otherwise
+// with that of the first service that failed to start or exited non-zero
+// on its own.
+func (s *supervisor) summary(services []*serviceInfo, exitCodeFrom string) error {
+	names := make([]string, len(services))
+	for i, si := range services {
+		names[i] = si.name
+	}
+	width := nameWidth(names)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Println("\nExit summary:")
+	var first *exitResult
+	for _, si := range services {
+		r := s.results[si.name]
+		var status string
+		switch {
+		case r == nil:
+			status = "not started"
+		case r.startErr != nil:
+			status = "failed to start: " + r.startErr.Error()
+		case r.stopped:
+			status = "stopped (" + r.describe() + ")"
+		default:
+			status = "exited (" + r.describe() + ")"
 		}
+		fmt.Printf("  %s%-*s%s  %s\n", si.color, width, si.name, colorReset, status)
 
-		scheme := "http"
-		if svc.TLS {
-			scheme = "https"
+		if r != nil && r.failed() && (first == nil || r.seq < first.seq) {
+			first = r
 		}
-		fmt.Printf("  %s%s%s  %s://%s\n", color, name, colorReset, scheme, dom)
-
-		services = append(services, serviceInfo{
-			name:   name,
-			svc:    svc,
-			port:   assignedPort,
-			domain: dom,
-			id:     id,
-			color:  color,
-			prefix: prefix,
-		})
 	}
-	fmt.Println()
 
-	// Signal handling: first Ctrl+C -> SIGTERM all; second -> SIGKILL all.
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	defer signal.Stop(sigChan)
+	code := 0
+	if exitCodeFrom != "" {
+		code = 1
+		if r := s.results[exitCodeFrom]; r != nil {
+			code = r.code
+		}
+	} else if first != nil {
+		code = first.code
+	}
+	if code != 0 {
+		return &ExitCodeError{Code: code}
+	}
+	return nil
+}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	cmds := make([]*exec.Cmd, 0, len(services))
+// exitCode returns a process's exit code, or 128 plus the signal that
+// killed it, as a shell reports it.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+	}
+	return 1
+}
 
-	// Cleanup function removes all routes on exit.
-	cleanup := func() {
-		for _, si := range services {
-			_ = store.RemoveRoute(si.domain)
+// exitDetail describes how a process exited: its exit code, or the signal
+// that killed it.
+func exitDetail(err error) string {
+	if err == nil {
+		return "0"
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return strconv.Itoa(code)
 		}
+		return exitErr.ProcessState.String()
 	}
-	defer cleanup()
+	return err.Error()
+}
 
-	// Start each service.
-	for _, si := range services {
-		cmd := exec.Command("sh", "-c", si.svc.Cmd)
-		cmd.Env = append(os.Environ(),
-			fmt.Sprintf("PORT=%d", si.port),
-			"HOST=127.0.0.1",
-		)
-		cmd.Stdout = newPrefixWriter(si.prefix, os.Stdout)
-		cmd.Stderr = newPrefixWriter(si.prefix, os.Stderr)
-
-		if err := cmd.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "%sfailed to start: %v\n", si.prefix, err)
-			continue
+// stop sends SIGTERM to a service's process. The returned channel is
+// closed once it has exited; it is nil if the service was not running.
+func (s *supervisor) stop(name string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	proc := s.procs[name]
+	if proc == nil {
+		return nil
+	}
+	proc.stopped = true
+	proc.signal(syscall.SIGTERM)
+	s.notify(proc.si, tui.Stopping, "")
+	return proc.done
+}
+
+// stopWait stops a service and waits for it to exit, killing it if it
+// takes longer than stopWaitTimeout.
+func (s *supervisor) stopWait(name string) {
+	done := s.stop(name)
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(stopWaitTimeout):
+		s.mu.Lock()
+		if proc := s.procs[name]; proc != nil {
+			proc.signal(syscall.SIGKILL)
 		}
+		s.mu.Unlock()
+		<-done
+	}
+}
 
-		_ = store.UpdateRoute(si.domain, func(r *config.Route) {
-			r.PID = cmd.Process.Pid
-		})
+// restart stops a service and starts it again on the same port.
+func (s *supervisor) restart(name string) {
+	s.mu.Lock()
+	si := s.infos[name]
+	if si == nil || s.restarting[name] {
+		s.mu.Unlock()
+		return
+	}
+	if s.restarting == nil {
+		s.restarting = make(map[string]bool)
+	}
+	s.restarting[name] = true
+	s.mu.Unlock()
 
-		mu.Lock()
-		cmds = append(cmds, cmd)
-		mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.restarting, name)
+		s.mu.Unlock()
+	}()
 
-		wg.Add(1)
-		go func(si serviceInfo, cmd *exec.Cmd) {
-			defer wg.Done()
-			if err := cmd.Wait(); err != nil {
-				fmt.Fprintf(os.Stderr, "%sexited: %v\n", si.prefix, err)
-			}
-		}(si, cmd)
+	s.stopWait(name)
+	s.notify(si, tui.Starting, "")
+	s.start(si)
+}
+
+// remove forgets a stopped service and removes its route and output.
+func (s *supervisor) remove(si *serviceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.infos[si.name] == si {
+		delete(s.infos, si.name)
 	}
+	_ = s.store.ReleaseRoute(si.domain, s.pids[si.domain])
+	_ = os.Remove(s.outputFile(si))
+}
 
-	// Wait for signal or all processes to exit.
-	allDone := make(chan struct{})
+// outputFile returns where a service's recent output is kept for the
+// proxy's error page. It outlives the process, so the page can show why a
+// service exited, and is removed with the route.
+func (s *supervisor) outputFile(si *serviceInfo) string {
+	return filepath.Join(s.logsDir, si.domain+".out")
+}
+
+// lookup returns the service started under name, or nil.
+func (s *supervisor) lookup(name string) *serviceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.infos[name]
+}
+
+// services returns every service started, sorted by name.
+func (s *supervisor) services() []*serviceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	services := make([]*serviceInfo, 0, len(s.infos))
+	for _, si := range s.infos {
+		services = append(services, si)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].name < services[j].name })
+	return services
+}
+
+// hold keeps done from firing while services are replaced, as a running
+// process would; call release after. It reports false if nothing more may
+// start: roxy is shutting down, or done is waiting and every process has
+// already exited.
+func (s *supervisor) hold() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing || s.waiting && len(s.procs) == 0 {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// release undoes hold.
+func (s *supervisor) release() {
+	s.wg.Done()
+}
+
+// signalAll sends sig to every running process.
+func (s *supervisor) signalAll(sig syscall.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, proc := range s.procs {
+		proc.stopped = true
+		proc.signal(sig)
+	}
+}
+
+// shutdown stops restarts from starting anything new.
+func (s *supervisor) shutdown() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+}
+
+// done returns a channel that is closed once every process has exited.
+// Call it after the first starts; later ones must be under hold.
+func (s *supervisor) done() <-chan struct{} {
+	s.mu.Lock()
+	s.waiting = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
 	go func() {
-		wg.Wait()
-		close(allDone)
+		s.wg.Wait()
+		close(done)
 	}()
+	return done
+}
 
-	select {
-	case <-sigChan:
-		fmt.Println("\nStopping all services...")
-		mu.Lock()
-		for _, c := range cmds {
-			if c.Process != nil {
-				_ = c.Process.Signal(syscall.SIGTERM)
-			}
+// resize resizes the services' pseudo-terminals after the window changed.
+func (s *supervisor) resize() {
+	rows, cols := s.ptySize()
+	if rows == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, proc := range s.procs {
+		if proc.tty != nil {
+			_ = proc.tty.Resize(rows, cols)
 		}
-		mu.Unlock()
+	}
+}
 
-		// Second signal: force kill.
-		select {
-		case <-sigChan:
-			fmt.Println("\nForce killing all services...")
-			mu.Lock()
-			for _, c := range cmds {
-				if c.Process != nil {
-					_ = c.Process.Kill()
-				}
-			}
-			mu.Unlock()
-			<-allDone
-		case <-allDone:
-		}
-	case <-allDone:
+// cleanup removes the services' routes, except ones a restart from
+// another roxy process has taken over, and their output files.
+func (s *supervisor) cleanup(services []*serviceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, si := range services {
+		_ = s.store.ReleaseRoute(si.domain, s.pids[si.domain])
+		_ = os.Remove(s.outputFile(si))
 	}
+}
 
-	return nil
+// termLines is shared by the prefixWriters of one RunAll. It remembers
+// whose unfinished line the cursor is on, so a progress line rewritten
+// with \r is redrawn in place, and output from another service first moves
+// to a new line instead of overwriting it.
+type termLines struct {
+	mu   sync.Mutex
+	tty  bool          // output is a terminal; otherwise progress updates are dropped
+	open *prefixWriter // writer whose partial line is on screen; nil at column 0
 }
 
 // prefixWriter wraps an io.Writer, prepending a prefix to each line of output.
@@ -283,37 +909,66 @@ func RunAll(cfg *config.RoxyConfig, callerOpts RunOptions) error {
 type prefixWriter struct {
 	prefix string
 	out    io.Writer
-	mu     sync.Mutex
-	buf    []byte
+	lines  *termLines
+
+	buf       []byte
+	progress  bool // buf follows a carriage return and is shown as it changes
+	pendingCR bool // the last byte was \r: a line break if \n follows, else a redraw
 }
 
-func newPrefixWriter(prefix string, out io.Writer) *prefixWriter {
-	return &prefixWriter{prefix: prefix, out: out}
+func newPrefixWriter(prefix string, out io.Writer, lines *termLines) *prefixWriter {
+	return &prefixWriter{prefix: prefix, out: out, lines: lines}
 }
 
 func (pw *prefixWriter) Write(p []byte) (int, error) {
-	pw.mu.Lock()
-	defer pw.mu.Unlock()
-
-	total := len(p)
-	pw.buf = append(pw.buf, p...)
+	pw.lines.mu.Lock()
+	defer pw.lines.mu.Unlock()
 
-	for {
-		idx := -1
-		for i, b := range pw.buf {
+	for _, b := range p {
+		if pw.pendingCR {
+			pw.pendingCR = false
 			if b == '\n' {
-				idx = i
-				break
+				pw.draw(true)
+				continue
 			}
+			// A lone \r: what follows replaces the line.
+			pw.buf = pw.buf[:0]
+			pw.progress = true
 		}
-		if idx < 0 {
-			break
+		switch b {
+		case '\n':
+			pw.draw(true)
+		case '\r':
+			pw.pendingCR = true
+		default:
+			pw.buf = append(pw.buf, b)
 		}
-
-		line := pw.buf[:idx+1]
-		_, _ = fmt.Fprintf(pw.out, "%s%s", pw.prefix, line)
-		pw.buf = pw.buf[idx+1:]
 	}
+	if pw.progress && len(pw.buf) > 0 && pw.lines.tty {
+		pw.draw(false)
+	}
+	return len(p), nil
+}
 
-	return total, nil
+// draw prints the buffered line, finished or not. Caller must hold
+// pw.lines.mu.
+func (pw *prefixWriter) draw(final bool) {
+	l := pw.lines
+	switch {
+	case l.open == pw:
+		// Redraw our own line and clear what is left of the old one.
+		_, _ = fmt.Fprintf(pw.out, "\r%s%s\x1b[K", pw.prefix, pw.buf)
+	case l.open != nil:
+		_, _ = fmt.Fprintf(pw.out, "\n%s%s", pw.prefix, pw.buf)
+	default:
+		_, _ = fmt.Fprintf(pw.out, "%s%s", pw.prefix, pw.buf)
+	}
+	if final {
+		_, _ = fmt.Fprint(pw.out, "\n")
+		l.open = nil
+		pw.buf = pw.buf[:0]
+		pw.progress = false
+	} else {
+		l.open = pw
+	}
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// TestSupervisorKeepsOutputOfExitedService verifies that a service's
+// recent output stays on disk after it exits, while its route is still
+// registered, and goes away with the route.
+func TestSupervisorKeepsOutputOfExitedService(t *testing.T) {
+	dir := t.TempDir()
+	store := config.NewStore(filepath.Join(dir, "routes.json"))
+	si := &serviceInfo{
+		name:   "web",
+		domain: "web.app.test",
+		port:   45123,
+		svc:    config.ServiceConfig{Cmd: "echo starting; echo 'fatal: cannot connect to database' >&2; exit 1"},
+	}
+	sup := &supervisor{
+		store:   store,
+		logsDir: dir,
+		output:  func(*serviceInfo) (io.Writer, io.Writer) { return io.Discard, io.Discard },
+		procs:   make(map[string]*serviceProc),
+		pids:    make(map[string]int),
+		results: make(map[string]*exitResult),
+		exits:   make(chan *serviceInfo, 1),
+	}
+	if err := store.AddRoute(config.Route{Domain: si.domain, Port: si.port, OutputFile: sup.outputFile(si)}); err != nil {
+		t.Fatal(err)
+	}
+
+	sup.start(si)
+	<-sup.done()
+
+	data, err := os.ReadFile(sup.outputFile(si))
+	if err != nil {
+		t.Fatalf("output of the exited service: %v", err)
+	}
+	if !strings.Contains(string(data), "starting\n") || !strings.Contains(string(data), "fatal: cannot connect to database\n") {
+		t.Errorf("output = %q", data)
+	}
+	if store.FindRoute(si.domain) == nil {
+		t.Fatal("route was removed when the service exited")
+	}
+
+	sup.remove(si)
+	if _, err := os.Stat(sup.outputFile(si)); !os.IsNotExist(err) {
+		t.Errorf("output file after remove: err = %v, want it gone", err)
+	}
+	if store.FindRoute(si.domain) != nil {
+		t.Error("route still registered after remove")
+	}
+}
+
+// TestPrefixWriterNoticeAfterProgressLine verifies that a message printed
+// while a service's progress line is on screen starts on a line of its own.
+func TestPrefixWriterNoticeAfterProgressLine(t *testing.T) {
+	var out strings.Builder
+	lines := &termLines{tty: true}
+	web := newPrefixWriter("[web] ", &out, lines)
+	notices := newPrefixWriter("", &out, lines)
+
+	_, _ = io.WriteString(web, "\rbuilding 50%")
+	_, _ = fmt.Fprintln(notices, "config reloaded: added api")
+	_, _ = io.WriteString(web, "\rbuilding 100%\n")
+
+	want := "[web] building 50%\nconfig reloaded: added api\n[web] building 100%\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
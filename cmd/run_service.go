@@ -12,12 +12,29 @@ func RunService(name string, svc config.ServiceConfig, callerOpts RunOptions) er
 		StartPort:  svc.Port,
 		TLS:        svc.TLS,
 		Detach:     callerOpts.Detach,
+		Format:     callerOpts.Format,
 		ListenPort: svc.ListenPort,
 		// CLI --public flag OR per-service public flag enables tunnelling.
 		Public: callerOpts.Public || svc.Public,
+
+		Protocol:       svc.Protocol,
+		TrustForwarded: callerOpts.TrustForwarded || svc.TrustForwarded,
+		ChangeOrigin:   callerOpts.ChangeOrigin || svc.ChangeOrigin,
+		RewriteOrigin:  callerOpts.RewriteOrigin || svc.RewriteOrigin,
+		Streaming:      svc.Streaming,
+		Headers:        svc.Headers,
+		CORS:           svc.CORS,
+		Shaping:        svc.Shaping,
+		Faults:         svc.Faults,
 	}
 	if opts.Name == "" {
 		opts.Name = name
 	}
+	if callerOpts.Protocol != "" {
+		opts.Protocol = callerOpts.Protocol
+	}
+	if callerOpts.Streaming != nil {
+		opts.Streaming = callerOpts.Streaming
+	}
 	return Run(opts)
 }
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+type ShapeOptions struct {
+	Target  string               // ID prefix or exact domain
+	Shaping config.ShapingConfig // new settings (ignored with Off)
+	Set     bool                 // any shaping flag was given
+	Off     bool                 // remove shaping from the route
+}
+
+// Shape shows or changes the network simulation for a running route. The
+// proxy picks up the change from routes.json within a poll interval.
+func Shape(opts ShapeOptions) error {
+	store, route, err := resolveRunningRoute(opts.Target)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Set && !opts.Off {
+		fmt.Printf("%s  %s\n", route.Domain, route.Shaping.Resolve())
+		return nil
+	}
+
+	var shaping *config.ShapingConfig
+	if !opts.Off {
+		if err := opts.Shaping.Validate(); err != nil {
+			return err
+		}
+		shaping = &opts.Shaping
+	}
+
+	if err := store.UpdateRoute(route.Domain, func(r *config.Route) {
+		r.Shaping = shaping
+	}); err != nil {
+		return fmt.Errorf("failed to update route %s: %w", route.Domain, err)
+	}
+
+	fmt.Printf("%s  %s\n", route.Domain, shaping.Resolve())
+	return nil
+}
@@ -38,14 +38,8 @@ func Stop(opts StopOptions) error {
 			continue
 		}
 
-		if route.PID > 0 {
-			if proc, err := os.FindProcess(route.PID); err == nil {
-				_ = proc.Signal(syscall.SIGTERM)
-			}
-		}
-
-		if err := store.RemoveRoute(route.Domain); err != nil {
-			fmt.Fprintf(os.Stderr, "error: failed to remove route %s: %v\n", route.Domain, err)
+		if err := StopRoute(*route); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			failed = true
 			continue
 		}
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/logscore/roxy/internal/platform"
+	"github.com/logscore/roxy/internal/proxy"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+const (
+	// DefaultTestTimeout is how long `roxy test` waits for services to be
+	// ready.
+	DefaultTestTimeout = 60 * time.Second
+	// readyPollInterval is how often readiness is checked.
+	readyPollInterval = 200 * time.Millisecond
+)
+
+// TestOptions configures Test.
+type TestOptions struct {
+	Services []string      // services to start; see config.RoxyConfig.Select
+	Profiles []string      // profiles to start when Services is empty
+	Timeout  time.Duration // how long to wait for them to be ready
+	Command  []string      // command to run once they are
+}
+
+// Test starts services from the project config under a temporary namespace, waits
+// until each is reachable through the proxy, runs opts.Command with their
+// URLs in its environment and stops them again. A failing command's exit
+// code is returned as an ExitCodeError.
+func Test(cfg *config.RoxyConfig, opts TestOptions) error {
+	cfg, err := cfg.Select(opts.Services, opts.Profiles)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cfg.Services))
+	for name := range cfg.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no services defined in %s", cfg.FileName())
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTestTimeout
+	}
+
+	p := platform.Detect()
+	paths := platform.GetPaths(p)
+
+	// Everything roxy prints goes to stderr; stdout is the command's.
+	if err := ensureProxy(p, paths, os.Stderr); err != nil {
+		return err
+	}
+
+	store := config.NewStore(paths.RoutesFile)
+	if _, err := store.PruneStaleRoutes(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to prune stale routes: %v\n", err)
+	}
+
+	logsDir := LogsDir(paths.ConfigDir)
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create logs dir: %w", err)
+	}
+
+	ns, err := newNamespace()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "starting services in namespace %s\n", ns)
+	services, err := registerServices(cfg, names, store, paths, ns, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	sup := &supervisor{
+		store:   store,
+		logsDir: logsDir,
+		procs:   make(map[string]*serviceProc),
+		pids:    make(map[string]int),
+		results: make(map[string]*exitResult),
+		exits:   make(chan *serviceInfo, 1),
+	}
+	defer sup.cleanup(services)
+
+	lines := &termLines{tty: term.IsTerminal(int(os.Stderr.Fd()))}
+	sup.output = func(si *serviceInfo) (io.Writer, io.Writer) {
+		return newPrefixWriter(si.prefix, os.Stderr, lines), newPrefixWriter(si.prefix, os.Stderr, lines)
+	}
+	sup.ptySize = func() (rows, cols uint16) { return 0, 0 }
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	for _, si := range services {
+		sup.start(si)
+	}
+
+	stopAll := func() {
+		sup.shutdown()
+		sup.signalAll(syscall.SIGTERM)
+		done := sup.done()
+		select {
+		case <-done:
+		case <-time.After(stopWaitTimeout):
+			sup.signalAll(syscall.SIGKILL)
+			<-done
+		}
+	}
+
+	httpPort := 80
+	if state := proxy.ReadState(paths.ConfigDir); state != nil && state.HTTPPort != 0 {
+		httpPort = state.HTTPPort
+	}
+	if err := waitReady(services, httpPort, opts.Timeout, sup.exits, sigChan); err != nil {
+		stopAll()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "services ready; running %s\n", strings.Join(opts.Command, " "))
+
+	cmd := exec.Command(opts.Command[0], opts.Command[1:]...)
+	cmd.Env = append(os.Environ(), testEnv(ns, services)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		stopAll()
+		return fmt.Errorf("failed to start %s: %w", opts.Command[0], err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	var waitErr error
+wait:
+	for {
+		select {
+		case sig := <-sigChan:
+			// Ctrl+C already reached the command through the terminal.
+			if sig == syscall.SIGTERM {
+				_ = cmd.Process.Signal(sig)
+			}
+		case waitErr = <-waited:
+			break wait
+		}
+	}
+
+	stopAll()
+	if code := exitCode(waitErr); code != 0 {
+		return &ExitCodeError{Code: code}
+	}
+	return nil
+}
+
+// newNamespace returns a random namespace such as "test-3f9a1c", so
+// concurrent runs don't share domains.
+func newNamespace() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate namespace: %w", err)
+	}
+	return "test-" + hex.EncodeToString(b), nil
+}
+
+// waitReady waits until every service is reachable through the proxy: it
+// accepts connections on its port and, for HTTP services, the proxy routes
+// its domain to it. It fails early if a service exits or roxy is
+// interrupted.
+func waitReady(services []*serviceInfo, httpPort int, timeout time.Duration, exits <-chan *serviceInfo, sigs <-chan os.Signal) error {
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	deadline := time.After(timeout)
+	pending := slices.Clone(services)
+	for {
+		pending = slices.DeleteFunc(pending, func(si *serviceInfo) bool {
+			return serviceReady(client, si, httpPort)
+		})
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case si := <-exits:
+			return fmt.Errorf("service %s exited before it was ready", si.name)
+		case <-sigs:
+			return fmt.Errorf("interrupted while waiting for services")
+		case <-deadline:
+			waiting := make([]string, len(pending))
+			for i, si := range pending {
+				waiting[i] = si.name
+			}
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, strings.Join(waiting, ", "))
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
+
+func serviceReady(client *http.Client, si *serviceInfo, httpPort int) bool {
+	if !dialable(si.port) {
+		return false
+	}
+	if si.listenPort > 0 {
+		return dialable(si.listenPort)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", httpPort), nil)
+	if err != nil {
+		return false
+	}
+	req.Host = si.domain
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get(proxy.ErrorHeader) == ""
+}
+
+func dialable(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// testEnv returns the variables that point the test command at the
+// services: ROXY_NAMESPACE, and ROXY_<NAME>_URL and ROXY_<NAME>_PORT for
+// each service.
+func testEnv(ns string, services []*serviceInfo) []string {
+	env := []string{"ROXY_NAMESPACE=" + ns}
+	for _, si := range services {
+		key := envName(si.name)
+		env = append(env,
+			fmt.Sprintf("ROXY_%s_URL=%s", key, si.url),
+			fmt.Sprintf("ROXY_%s_PORT=%d", key, si.port),
+		)
+	}
+	return env
+}
+
+// envName turns a service name into the form used in variable names:
+// "web-app" becomes "WEB_APP".
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
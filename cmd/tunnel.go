@@ -84,8 +84,9 @@ func TunnelSet() error {
 	return nil
 }
 
-// TunnelStatus shows the current tunnel provider configuration.
-func TunnelStatus() error {
+// TunnelStatus shows the current tunnel provider configuration, as text or
+// in the given format (see FormatJSON).
+func TunnelStatus(format string) error {
 	p := platform.Detect()
 	paths := platform.GetPaths(p)
 
@@ -94,6 +95,16 @@ func TunnelStatus() error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if format != "" {
+		info := TunnelStatusInfo{Provider: cfg.Tunnel.Provider}
+		if prov := tunnel.LookupProvider(cfg.Tunnel.Provider); prov != nil {
+			info.Supported = true
+			info.Binary = prov.Binary
+			info.Path, _ = exec.LookPath(prov.Binary)
+		}
+		return printFormatted(format, info)
+	}
+
 	if cfg.Tunnel.Provider == "" {
 		fmt.Println()
 		fmt.Println("  No tunnel provider configured.")
@@ -3,8 +3,11 @@ module github.com/logscore/roxy
 go 1.25.5
 
 require (
+	github.com/creack/pty v1.1.24
 	github.com/gorilla/websocket v1.5.3
 	github.com/miekg/dns v1.1.72
+	github.com/pelletier/go-toml/v2 v2.2.4
+	golang.org/x/term v0.40.0
 )
 
 require (
@@ -0,0 +1,148 @@
+package attach
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"ctrl-p,ctrl-q", []byte{0x10, 0x11}},
+		{"ctrl-P, ctrl-[", []byte{0x10, 0x1b}},
+		{"x", []byte{'x'}},
+		{"ctrl-@,q", []byte{0x00, 'q'}},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeys(tt.in)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("ParseKeys(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "ctrl-1", "alt-x", "ctrl-pq"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Errorf("ParseKeys(%q): expected error", bad)
+		}
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	f := keyFilter{keys: []byte{0x10, 0x11}}
+
+	if out, detach := f.feed([]byte("ab\x10")); string(out) != "ab" || detach {
+		t.Errorf("partial sequence: out = %q, detach = %v", out, detach)
+	}
+	// The held ctrl-p turns out to be input.
+	if out, detach := f.feed([]byte("c")); string(out) != "\x10c" || detach {
+		t.Errorf("broken sequence: out = %q, detach = %v", out, detach)
+	}
+	// ctrl-p ctrl-p ctrl-q: the first ctrl-p is input.
+	if out, detach := f.feed([]byte("\x10\x10\x11rest")); string(out) != "\x10" || !detach {
+		t.Errorf("completed sequence: out = %q, detach = %v", out, detach)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for one writer and one reader.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitForOutput(t *testing.T, b *syncBuffer, want string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !strings.Contains(b.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output missing %q: %q", want, b.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	cmd := exec.Command("cat")
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		t.Skipf("no pseudo-terminal available: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = ptmx.Close()
+	}()
+
+	path := filepath.Join(t.TempDir(), "s.sock")
+	srv, err := Listen(path, ptmx)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = srv.Close() }()
+	go func() { _, _ = io.Copy(srv, ptmx) }()
+
+	client, err := Dial(path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if err := client.Resize(30, 100); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+
+	stdinR, stdinW := io.Pipe()
+	out := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() { done <- client.Run(stdinR, out, []byte{0x10, 0x11}) }()
+
+	_, _ = stdinW.Write([]byte("hello\n"))
+	waitForOutput(t, out, "hello")
+
+	if size, err := pty.GetsizeFull(ptmx); err != nil || size.Rows != 30 || size.Cols != 100 {
+		t.Errorf("terminal size = %+v, %v; want 30x100", size, err)
+	}
+
+	// A second client starts with the scrollback.
+	late, err := Dial(path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	lateOut := &syncBuffer{}
+	go func() { _ = late.Run(strings.NewReader(""), lateOut, nil) }()
+	waitForOutput(t, lateOut, "hello")
+
+	_, _ = stdinW.Write([]byte{0x10, 0x11})
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrDetached) {
+			t.Errorf("Run = %v, want ErrDetached", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("client did not detach")
+	}
+
+	// The service keeps running for other clients after a detach.
+	if cmd.ProcessState != nil {
+		t.Error("service exited on detach")
+	}
+}
@@ -0,0 +1,175 @@
+package attach
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DefaultDetachKeys is the key sequence that detaches a client, as in
+// `docker attach`.
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// ErrDetached is returned by Client.Run when the user typed the detach keys.
+var ErrDetached = errors.New("detached")
+
+// ParseKeys parses a comma-separated key sequence such as "ctrl-p,ctrl-q".
+// Each key is a single character or ctrl-<key>, where key is a letter or
+// one of @ [ \ ] ^ _.
+func ParseKeys(s string) ([]byte, error) {
+	var keys []byte
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		switch {
+		case len(k) == 1:
+			keys = append(keys, k[0])
+		case len(k) == 6 && strings.HasPrefix(strings.ToLower(k), "ctrl-"):
+			c := k[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				keys = append(keys, c-'a'+1)
+			case c >= 'A' && c <= 'Z':
+				keys = append(keys, c-'A'+1)
+			case c == '@' || c >= '[' && c <= '_':
+				keys = append(keys, c-'@')
+			default:
+				return nil, fmt.Errorf("invalid detach key %q", k)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %q (use a character or ctrl-<key>)", k)
+		}
+	}
+	return keys, nil
+}
+
+// Client is a connection to a Server.
+type Client struct {
+	conn net.Conn
+	mu   sync.Mutex // serializes frames
+}
+
+// Dial connects to the socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Resize tells the server the client's terminal size.
+func (c *Client) Resize(rows, cols uint16) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:], rows)
+	binary.BigEndian.PutUint16(payload[2:], cols)
+	return c.send(frameResize, payload)
+}
+
+func (c *Client) send(kind byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(payload) > 0 {
+		n := min(len(payload), 0xffff)
+		frame := make([]byte, 3+n)
+		frame[0] = kind
+		binary.BigEndian.PutUint16(frame[1:], uint16(n))
+		copy(frame[3:], payload[:n])
+		if _, err := c.conn.Write(frame); err != nil {
+			return err
+		}
+		payload = payload[n:]
+	}
+	return nil
+}
+
+// Run copies the terminal's output to stdout and stdin to the terminal. It
+// returns ErrDetached when detachKeys are typed, and nil when the service's
+// terminal closes.
+func (c *Client) Run(stdin io.Reader, stdout io.Writer, detachKeys []byte) error {
+	defer func() { _ = c.conn.Close() }()
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(stdout, c.conn)
+		outputDone <- err
+	}()
+
+	inputDone := make(chan error, 1)
+	go func() {
+		filter := keyFilter{keys: detachKeys}
+		buf := make([]byte, 4096)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				out, detach := filter.feed(buf[:n])
+				if len(out) > 0 {
+					if err := c.send(frameInput, out); err != nil {
+						inputDone <- err
+						return
+					}
+				}
+				if detach {
+					inputDone <- ErrDetached
+					return
+				}
+			}
+			if err != nil {
+				inputDone <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-outputDone:
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		return err
+	case err := <-inputDone:
+		if err == io.EOF {
+			// stdin closed (e.g. piped input): keep showing output.
+			return <-outputDone
+		}
+		return err
+	}
+}
+
+// keyFilter passes input through, holding back a partial detach sequence
+// until it either completes or turns out to be ordinary input.
+type keyFilter struct {
+	keys    []byte
+	matched int
+}
+
+// feed returns the input to forward and whether the detach sequence was
+// completed. Input after the sequence is dropped.
+func (f *keyFilter) feed(p []byte) ([]byte, bool) {
+	if len(f.keys) == 0 {
+		return p, false
+	}
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == f.keys[f.matched] {
+			f.matched++
+			if f.matched == len(f.keys) {
+				return out, true
+			}
+			continue
+		}
+		if f.matched > 0 {
+			out = append(out, f.keys[:f.matched]...)
+			f.matched = 0
+			if b == f.keys[0] {
+				f.matched = 1
+				continue
+			}
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
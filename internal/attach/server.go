@@ -0,0 +1,163 @@
+// Package attach connects terminals to services running under a roxy-held
+// pseudo-terminal. The process running the service serves a Unix socket;
+// `roxy attach` dials it.
+//
+// Server to client, the socket carries the terminal's raw output. Client to
+// server, it carries frames: a type byte, a big-endian uint16 length and the
+// payload. frameInput payloads are keystrokes; frameResize payloads are the
+// client's rows and columns as two big-endian uint16s.
+package attach
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+const (
+	frameInput  = 'i'
+	frameResize = 'w'
+
+	// scrollbackBytes is how much recent output a new client is sent, so it
+	// sees the current screen instead of a blank one.
+	scrollbackBytes = 64 << 10
+	// writeTimeout drops clients that stop reading instead of stalling the
+	// service's output.
+	writeTimeout = 2 * time.Second
+)
+
+// Server broadcasts a pseudo-terminal's output to attached clients and
+// feeds their input back into it.
+type Server struct {
+	ln   net.Listener
+	path string
+	pty  *os.File
+
+	mu         sync.Mutex
+	clients    map[net.Conn]struct{}
+	scrollback []byte
+	closed     bool
+}
+
+// Listen serves the terminal ptmx on a Unix socket at path, replacing a
+// stale socket left by a previous run. Only the current user can connect.
+func Listen(path string, ptmx *os.File) (*Server, error) {
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	s := &Server{ln: ln, path: path, pty: ptmx, clients: make(map[net.Conn]struct{})}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		// Registering and replaying under mu keeps the scrollback and the
+		// live output in order.
+		s.clients[conn] = struct{}{}
+		if len(s.scrollback) > 0 {
+			s.send(conn, s.scrollback)
+		}
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+// serve reads a client's frames until it disconnects.
+func (s *Server) serve(conn net.Conn) {
+	defer s.drop(conn)
+	header := make([]byte, 3)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		switch header[0] {
+		case frameInput:
+			if _, err := s.pty.Write(payload); err != nil {
+				return
+			}
+		case frameResize:
+			if len(payload) == 4 {
+				_ = pty.Setsize(s.pty, &pty.Winsize{
+					Rows: binary.BigEndian.Uint16(payload[0:]),
+					Cols: binary.BigEndian.Uint16(payload[2:]),
+				})
+			}
+		}
+	}
+}
+
+// Write records terminal output and sends it to every client. It never
+// fails, so a stuck client cannot break the service's log.
+func (s *Server) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scrollback = append(s.scrollback, p...)
+	if len(s.scrollback) > scrollbackBytes {
+		s.scrollback = append(s.scrollback[:0], s.scrollback[len(s.scrollback)-scrollbackBytes:]...)
+	}
+	for conn := range s.clients {
+		s.send(conn, p)
+	}
+	return len(p), nil
+}
+
+// send writes p to conn, dropping the client on error. Caller must hold mu.
+func (s *Server) send(conn net.Conn, p []byte) {
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write(p); err != nil {
+		delete(s.clients, conn)
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) drop(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.clients, conn)
+	s.mu.Unlock()
+	_ = conn.Close()
+}
+
+// Close disconnects all clients and removes the socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.clients {
+		_ = conn.Close()
+	}
+	s.clients = nil
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	if rmErr := os.Remove(s.path); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) && err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -32,6 +32,16 @@ func Generate(nameOverride string) (string, error) {
 	return sanitize(sub) + "." + root + ".test", nil
 }
 
+// WithNamespace adds ns as the second label of domain, so
+// "web.my-app.test" becomes "web.ns.my-app.test".
+func WithNamespace(domain, ns string) string {
+	sub, rest, ok := strings.Cut(domain, ".")
+	if !ok {
+		return sanitize(ns) + "." + domain
+	}
+	return sub + "." + sanitize(ns) + "." + rest
+}
+
 // rootDomain returns the project name from the git worktree root directory.
 // Falls back to the current directory name if not in a git repo.
 func rootDomain() (string, error) {
@@ -186,6 +186,20 @@ func TrustCA(p Platform, caCertPath string) error {
 	return nil
 }
 
+// OpenURL opens url in the user's default browser without waiting for it.
+func OpenURL(p Platform, url string) error {
+	name := "xdg-open"
+	if p == PlatformDarwin {
+		name = "open"
+	}
+	cmd := exec.Command(name, url)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }()
+	return nil
+}
+
 func configureLinux(paths Paths, dnsPort int) error {
 	dnsAddr := "127.0.0.1"
 	if dnsPort != 53 {
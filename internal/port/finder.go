@@ -145,6 +145,12 @@ func Find(exactPort int, routesFile string) (int, error) {
 	return 0, fmt.Errorf("no available port found in range %d-%d", minPort, maxPort)
 }
 
+// Available returns nil if port can be listened on right now. A restart
+// uses it to wait for the old process to let go of its port.
+func Available(port int) error {
+	return checkAvailable(port)
+}
+
 // checkAvailable tests whether a TCP port is free by briefly listening on it.
 func checkAvailable(port int) error {
 	ln, err := net.Listen("tcp4", fmt.Sprintf(":%d", port))
@@ -0,0 +1,73 @@
+package process
+
+import (
+	"os"
+	"sync"
+)
+
+const (
+	// outputMaxBytes is how large a foreground output file may grow before
+	// it is cut back to its tail.
+	outputMaxBytes = 256 << 10
+	// outputKeepBytes is how much recent output survives a cut.
+	outputKeepBytes = 64 << 10
+)
+
+// OutputBuffer keeps the recent output of a foreground process in a file,
+// so the proxy can show it on its error page. The file is cut back to the
+// last outputKeepBytes whenever it passes outputMaxBytes.
+type OutputBuffer struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int
+	tail []byte // last outputKeepBytes written
+}
+
+// NewOutputBuffer creates (or truncates) the file at path.
+func NewOutputBuffer(path string) (*OutputBuffer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &OutputBuffer{f: f}, nil
+}
+
+// Write never fails, so a full disk cannot break the process's output to
+// the terminal.
+func (b *OutputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tail = append(b.tail, p...)
+	if len(b.tail) > outputKeepBytes {
+		b.tail = append(b.tail[:0], b.tail[len(b.tail)-outputKeepBytes:]...)
+	}
+
+	if b.size+len(p) > outputMaxBytes {
+		if err := b.f.Truncate(0); err == nil {
+			if _, err := b.f.Seek(0, 0); err == nil {
+				n, _ := b.f.Write(b.tail)
+				b.size = n
+				return len(p), nil
+			}
+		}
+	}
+	n, _ := b.f.Write(p)
+	b.size += n
+	return len(p), nil
+}
+
+// Close closes the file but leaves it in place, so the error page can
+// still show the output of a process that has exited. Later writes are
+// dropped.
+func (b *OutputBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = b.f.Close()
+}
+
+// Remove closes the file and deletes it, for when its route goes away.
+func (b *OutputBuffer) Remove() {
+	b.Close()
+	_ = os.Remove(b.f.Name())
+}
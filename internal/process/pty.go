@@ -0,0 +1,93 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+
+	"github.com/logscore/roxy/internal/attach"
+)
+
+const (
+	// ptyRows and ptyCols size a terminal when there is no better guess.
+	ptyRows = 24
+	ptyCols = 80
+	// ptyDrainTimeout bounds how long output is read after the service
+	// exits; background children may keep the terminal open.
+	ptyDrainTimeout = time.Second
+)
+
+// PTY is the pseudo-terminal a command runs under.
+type PTY struct {
+	f      *os.File
+	copied chan struct{}
+}
+
+// StartPTY starts cmd under a pseudo-terminal of the given size, so it sees
+// a TTY on stdin, stdout and stderr. Zero sizes use 80x24. Call CopyTo to
+// read its output.
+func StartPTY(cmd *exec.Cmd, rows, cols uint16) (*PTY, error) {
+	if rows == 0 || cols == 0 {
+		rows, cols = ptyRows, ptyCols
+	}
+	// pty.Start only connects the streams that are unset.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: rows, Cols: cols})
+	if err != nil {
+		return nil, err
+	}
+	return &PTY{f: f, copied: make(chan struct{})}, nil
+}
+
+// CopyTo copies the terminal's output to w in the background.
+func (p *PTY) CopyTo(w io.Writer) {
+	go func() {
+		// Ends with EIO once every process holding the terminal has exited.
+		_, _ = io.Copy(w, p.f)
+		close(p.copied)
+	}()
+}
+
+// Resize changes the terminal size; the command gets SIGWINCH.
+func (p *PTY) Resize(rows, cols uint16) error {
+	return pty.Setsize(p.f, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// Close waits briefly for the rest of the output and closes the terminal.
+// Call it after cmd.Wait.
+func (p *PTY) Close() {
+	select {
+	case <-p.copied:
+	case <-time.After(ptyDrainTimeout):
+	}
+	_ = p.f.Close()
+}
+
+// startAttachable starts cmd under a pseudo-terminal, copies its output to
+// out and serves the terminal on socketPath for `roxy attach`. Call the
+// returned function after cmd.Wait to release the terminal and socket.
+func startAttachable(cmd *exec.Cmd, out io.Writer, socketPath string) (func(), error) {
+	p, err := StartPTY(cmd, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := attach.Listen(socketPath, p.f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: roxy attach is unavailable: %v\n", err)
+		p.CopyTo(out)
+	} else {
+		p.CopyTo(io.MultiWriter(out, srv))
+	}
+
+	return func() {
+		p.Close()
+		if srv != nil {
+			_ = srv.Close()
+		}
+	}, nil
+}
@@ -13,39 +13,37 @@ import (
 	"github.com/logscore/roxy/pkg/config"
 )
 
-// Run spawns the command with PORT set, tracks the route, optionally
+// Run spawns route.Command with PORT set, tracks the route, optionally
 // starts a tunnel sidecar, and handles cleanup on exit or signal.
+// route carries everything the proxy needs (ID, domain, ports, TLS and
+// per-route proxy options); Type, Public and Created are filled in here.
 // localURL is the formatted local URL (e.g. "https://main.my-app.test") used
 // for display when --public is set (both URLs printed together).
-func Run(id string, cmdStr string, port int, domain string, tlsEnabled bool, listenPort int, tunnelProvider *tunnel.Provider, localURL string, store *config.Store, configDir string, logFile string) error {
+func Run(route config.Route, tunnelProvider *tunnel.Provider, localURL string, store *config.Store) error {
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	defer signal.Stop(sigChan)
 
-	routeType := "http"
-	if listenPort > 0 {
-		routeType = "tcp"
+	route.Type = "http"
+	if route.ListenPort > 0 {
+		route.Type = "tcp"
 	}
+	route.Public = tunnelProvider != nil
+	route.Created = time.Now()
 
-	// Track route (the proxy watches routes.json for changes)
-	if err := store.AddRoute(config.Route{
-		ID:         id,
-		Domain:     domain,
-		Port:       port,
-		ListenPort: listenPort,
-		Type:       routeType,
-		TLS:        tlsEnabled,
-		Command:    cmdStr,
-		LogFile:    logFile,
-		Public:     tunnelProvider != nil,
-		Created:    time.Now(),
-	}); err != nil {
+	domain := route.Domain
+	port := route.Port
+
+	// Track route (the proxy watches routes.json for changes). A restart
+	// replaces the route it took over, so it is never missing.
+	if err := store.PutRoute(route); err != nil {
 		return fmt.Errorf("failed to register route: %w", err)
 	}
 
 	// Track the tunnel so cleanup can stop it
 	var tun *tunnel.Tunnel
+	pid := 0
 
 	// Ensure cleanup on any exit path
 	cleanup := func() {
@@ -54,14 +52,15 @@ func Run(id string, cmdStr string, port int, domain string, tlsEnabled bool, lis
 			tun.Stop()
 		}
 
-		if err := store.RemoveRoute(domain); err != nil {
+		// Leave the route alone if a restart has taken it over
+		if err := store.ReleaseRoute(domain, pid); err != nil {
 			fmt.Fprintf(os.Stderr, "warning: failed to remove route: %v\n", err)
 		}
 	}
 	defer cleanup()
 
 	// Spawn child process
-	cmd := exec.Command("sh", "-c", cmdStr)
+	cmd := exec.Command("sh", "-c", route.Command)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("PORT=%d", port),
 		"HOST=127.0.0.1",
@@ -70,13 +69,35 @@ func Run(id string, cmdStr string, port int, domain string, tlsEnabled bool, lis
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
-	if err := cmd.Start(); err != nil {
+	// Keep a copy of recent output for the proxy's error page
+	if route.OutputFile != "" {
+		out, err := NewOutputBuffer(route.OutputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to create output file: %v\n", err)
+		} else {
+			// The route is removed when Run returns, so the output goes too.
+			defer out.Remove()
+			cmd.Stdout = io.MultiWriter(os.Stdout, out)
+			cmd.Stderr = io.MultiWriter(os.Stderr, out)
+		}
+	}
+
+	// Detached services run under a pseudo-terminal that `roxy attach`
+	// connects to; their output still goes to the log file on stdout.
+	if route.AttachSocket != "" {
+		release, err := startAttachable(cmd, os.Stdout, route.AttachSocket)
+		if err != nil {
+			return fmt.Errorf("failed to start command: %w", err)
+		}
+		defer release()
+	} else if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start command: %w", err)
 	}
 
 	// Update route with PID (atomic — no gap where proxy sees no route)
+	pid = cmd.Process.Pid
 	_ = store.UpdateRoute(domain, func(r *config.Route) {
-		r.PID = cmd.Process.Pid
+		r.PID = pid
 	})
 
 	// Start tunnel sidecar if a provider was given
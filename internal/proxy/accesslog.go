@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats.
+const (
+	AccessLogJSON     = "json"     // one JSON object per line (default)
+	AccessLogCommon   = "common"   // NCSA Common Log Format
+	AccessLogCombined = "combined" // Common Log Format plus referer and user agent
+	AccessLogOff      = "off"      // no access logging
+)
+
+// clfTimeFormat is the timestamp layout used by Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogMaxBytes is how large a route's access log may grow before it
+// is rotated: the current file becomes <file>.1, replacing the previous
+// one, and a new file is started.
+const accessLogMaxBytes = 10 << 20
+
+// ValidateAccessLogFormat reports whether f is a supported format.
+// The empty string is accepted and means AccessLogJSON.
+func ValidateAccessLogFormat(f string) error {
+	switch f {
+	case "", AccessLogJSON, AccessLogCommon, AccessLogCombined, AccessLogOff:
+		return nil
+	}
+	return fmt.Errorf("access log format must be %q, %q, %q or %q, got %q",
+		AccessLogJSON, AccessLogCommon, AccessLogCombined, AccessLogOff, f)
+}
+
+// AccessLogPath returns the access log file for a route's domain.
+func AccessLogPath(logsDir, domain string) string {
+	return filepath.Join(logsDir, domain+".access.log")
+}
+
+// AccessEntry is one line of the access log.
+type AccessEntry struct {
+	Time       time.Time `json:"time"`
+	RouteID    string    `json:"route_id,omitempty"`
+	Host       string    `json:"host"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"` // 0 when no response was sent (reset or client gone)
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	UpstreamMS float64   `json:"upstream_ms,omitempty"` // time to upstream response headers
+	ClientIP   string    `json:"client_ip"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// Format renders the entry as a single line (without newline).
+func (e AccessEntry) Format(format string) string {
+	switch format {
+	case AccessLogCommon, AccessLogCombined:
+		bytes := "-"
+		if e.Bytes > 0 {
+			bytes = fmt.Sprintf("%d", e.Bytes)
+		}
+		line := fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`,
+			e.ClientIP, e.Time.Format(clfTimeFormat), e.Method, e.Path, e.Proto, e.Status, bytes)
+		if format == AccessLogCombined {
+			line += fmt.Sprintf(` %q %q`, dashIfEmpty(e.Referer), dashIfEmpty(e.UserAgent))
+		}
+		return line
+	default:
+		data, _ := json.Marshal(e)
+		return string(data)
+	}
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// accessLogger appends entries to one file per route domain. Files are
+// opened on first use and kept open until close, rotated once they reach
+// accessLogMaxBytes and deleted when their route is removed.
+type accessLogger struct {
+	dir    string
+	format string
+
+	mu    sync.Mutex
+	files map[string]*accessFile
+}
+
+// accessFile is an open access log and how much it holds.
+type accessFile struct {
+	f    *os.File
+	size int64
+}
+
+// newAccessLogger returns a logger writing to dir, or nil when logging is
+// disabled (no dir or format "off").
+func newAccessLogger(dir, format string) *accessLogger {
+	if dir == "" || format == AccessLogOff {
+		return nil
+	}
+	if format == "" {
+		format = AccessLogJSON
+	}
+	return &accessLogger{dir: dir, format: format, files: make(map[string]*accessFile)}
+}
+
+// log appends one entry to the domain's access log. Errors go to the proxy
+// log; access logging must never fail a request.
+func (l *accessLogger) log(domain string, e AccessEntry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	line := e.Format(l.format) + "\n"
+	af, err := l.file(domain)
+	if err == nil && af.size > 0 && af.size+int64(len(line)) > accessLogMaxBytes {
+		_ = af.f.Close()
+		delete(l.files, domain)
+		path := AccessLogPath(l.dir, domain)
+		if err := os.Rename(path, path+".1"); err != nil {
+			log.Printf("access log: %v", err)
+		}
+		af, err = l.file(domain)
+	}
+	if err != nil {
+		log.Printf("access log: %v", err)
+		return
+	}
+	// One unbuffered write per line so `roxy logs --access` sees it at once.
+	n, err := af.f.WriteString(line)
+	af.size += int64(n)
+	if err != nil {
+		log.Printf("access log: %v", err)
+	}
+}
+
+// file returns the domain's access log, opening it for appending if it is
+// not open yet. Caller must hold mu.
+func (l *accessLogger) file(domain string) (*accessFile, error) {
+	if af, ok := l.files[domain]; ok {
+		return af, nil
+	}
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(AccessLogPath(l.dir, domain), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	af := &accessFile{f: f, size: info.Size()}
+	l.files[domain] = af
+	return af, nil
+}
+
+// remove closes and deletes the domain's access logs, for a route that
+// has been removed.
+func (l *accessLogger) remove(domain string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if af, ok := l.files[domain]; ok {
+		_ = af.f.Close()
+		delete(l.files, domain)
+	}
+	path := AccessLogPath(l.dir, domain)
+	_ = os.Remove(path)
+	_ = os.Remove(path + ".1")
+}
+
+// close closes all open files.
+func (l *accessLogger) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for domain, af := range l.files {
+		_ = af.f.Close()
+		delete(l.files, domain)
+	}
+}
+
+// responseRecorder captures the status and size of a response for the
+// access log and metrics. Unwrap and Hijack keep flushing and WebSocket
+// upgrades working.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 || w.status < 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newAccessEntry fills in the request side of an access log entry.
+func newAccessEntry(r *http.Request, route Route, start time.Time) AccessEntry {
+	clientIP := r.RemoteAddr
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = ip
+	}
+	return AccessEntry{
+		Time:      start,
+		RouteID:   route.ID,
+		Host:      strings.ToLower(route.Domain),
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		Proto:     r.Proto,
+		ClientIP:  clientIP,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// durationMS converts d to fractional milliseconds.
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// clfPattern matches Common and Combined Log Format lines as written by
+// AccessEntry.Format.
+var clfPattern = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+) (\S+)" (\d+) (\d+|-)(?: ("(?:[^"\\]|\\.)*") ("(?:[^"\\]|\\.)*"))?$`)
+
+// ParseAccessEntry parses one access log line in any supported format.
+// Timing fields are only available in the JSON format.
+func ParseAccessEntry(line string) (AccessEntry, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var e AccessEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return AccessEntry{}, false
+		}
+		return e, true
+	}
+
+	m := clfPattern.FindStringSubmatch(line)
+	if m == nil {
+		return AccessEntry{}, false
+	}
+	e := AccessEntry{ClientIP: m[1], Method: m[3], Path: m[4], Proto: m[5]}
+	e.Time, _ = time.Parse(clfTimeFormat, m[2])
+	e.Status, _ = strconv.Atoi(m[6])
+	if m[7] != "-" {
+		e.Bytes, _ = strconv.ParseInt(m[7], 10, 64)
+	}
+	if m[8] != "" {
+		e.Referer = undash(m[8])
+		e.UserAgent = undash(m[9])
+	}
+	return e, true
+}
+
+// undash reverses the quoting and "-" placeholder of Combined Log Format.
+func undash(quoted string) string {
+	s, err := strconv.Unquote(quoted)
+	if err != nil || s == "-" {
+		return ""
+	}
+	return s
+}
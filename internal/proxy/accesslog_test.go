@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+func accessLogProxy(t *testing.T, format string, faults []config.FaultRule) (*httptest.Server, string) {
+	t.Helper()
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	dir := t.TempDir()
+	srv := &Server{access: newAccessLogger(dir, format)}
+	t.Cleanup(srv.access.close)
+	route := Route{ID: "abc123", Domain: "api.app.test", Port: parsePort(t, upstream.URL), Type: "http", Faults: faults}
+	return serveProxy(t, srv, []Route{route}), AccessLogPath(dir, "api.app.test")
+}
+
+// readAccessLog waits for n lines to appear; entries are written after the
+// response has been sent.
+func readAccessLog(t *testing.T, path string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(data) > 0 && len(lines) >= n {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("access log has %d lines, want %d:\n%s", len(lines), n, data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	proxyServer, path := accessLogProxy(t, "", nil)
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/hello?x=1", nil)
+	req.Host = "api.app.test"
+	req.Header.Set("User-Agent", "roxy-test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	lines := readAccessLog(t, path, 1)
+	e, ok := ParseAccessEntry(lines[0])
+	if !ok {
+		t.Fatalf("unparseable line %q", lines[0])
+	}
+	if e.RouteID != "abc123" || e.Host != "api.app.test" || e.Method != "GET" || e.Path != "/hello?x=1" {
+		t.Errorf("entry = %+v", e)
+	}
+	if e.Status != 200 || e.Bytes != 5 {
+		t.Errorf("status, bytes = %d, %d, want 200, 5", e.Status, e.Bytes)
+	}
+	if e.UserAgent != "roxy-test" || e.ClientIP != "127.0.0.1" {
+		t.Errorf("user agent, client ip = %q, %q", e.UserAgent, e.ClientIP)
+	}
+	if e.UpstreamMS <= 0 || e.DurationMS < e.UpstreamMS {
+		t.Errorf("duration_ms = %v, upstream_ms = %v", e.DurationMS, e.UpstreamMS)
+	}
+}
+
+func TestAccessLogIncludesFaults(t *testing.T) {
+	proxyServer, path := accessLogProxy(t, AccessLogJSON, []config.FaultRule{
+		{Action: config.FaultError, Path: "/broken", Status: 500},
+	})
+	for _, p := range []string{"/broken", "/missing"} {
+		resp, _, err := faultRequest(context.Background(), proxyServer.URL, "GET", p)
+		if err != nil {
+			t.Fatalf("GET %s: %v", p, err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	lines := readAccessLog(t, path, 2)
+	for i, want := range []int{500, 404} {
+		e, _ := ParseAccessEntry(lines[i])
+		if e.Status != want {
+			t.Errorf("line %d: status = %d, want %d", i, e.Status, want)
+		}
+	}
+	if e, _ := ParseAccessEntry(lines[0]); e.UpstreamMS != 0 {
+		t.Errorf("faulted request has upstream_ms = %v, want 0", e.UpstreamMS)
+	}
+}
+
+func TestAccessLogOff(t *testing.T) {
+	if newAccessLogger(t.TempDir(), AccessLogOff) != nil {
+		t.Error("format off: logger is not nil")
+	}
+	if newAccessLogger("", AccessLogJSON) != nil {
+		t.Error("no logs dir: logger is not nil")
+	}
+}
+
+func TestAccessEntryFormatRoundTrip(t *testing.T) {
+	e := AccessEntry{
+		Time:      time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		Method:    "POST",
+		Path:      "/api/orders?id=1",
+		Proto:     "HTTP/1.1",
+		Status:    201,
+		Bytes:     42,
+		ClientIP:  "127.0.0.1",
+		Referer:   "http://web.app.test/",
+		UserAgent: `curl/8.0 "quoted"`,
+	}
+
+	common := e.Format(AccessLogCommon)
+	if want := `127.0.0.1 - - [01/Mar/2026:12:30:00 +0000] "POST /api/orders?id=1 HTTP/1.1" 201 42`; common != want {
+		t.Errorf("common = %q, want %q", common, want)
+	}
+
+	for _, format := range []string{AccessLogJSON, AccessLogCommon, AccessLogCombined} {
+		got, ok := ParseAccessEntry(e.Format(format))
+		if !ok {
+			t.Fatalf("%s: unparseable %q", format, e.Format(format))
+		}
+		if got.Status != e.Status || got.Bytes != e.Bytes || got.Path != e.Path || !got.Time.Equal(e.Time) {
+			t.Errorf("%s: parsed %+v", format, got)
+		}
+		if format == AccessLogCombined && (got.Referer != e.Referer || got.UserAgent != e.UserAgent) {
+			t.Errorf("combined: referer, user agent = %q, %q", got.Referer, got.UserAgent)
+		}
+	}
+
+	if _, ok := ParseAccessEntry("not a log line"); ok {
+		t.Error("garbage parsed as an entry")
+	}
+}
+
+func TestAccessLogRotates(t *testing.T) {
+	dir := t.TempDir()
+	path := AccessLogPath(dir, "api.app.test")
+	full := strings.Repeat("x", accessLogMaxBytes-10) + "\n"
+	if err := os.WriteFile(path, []byte(full), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newAccessLogger(dir, AccessLogCommon)
+	t.Cleanup(l.close)
+	l.log("api.app.test", AccessEntry{Method: "GET", Path: "/", Proto: "HTTP/1.1", Status: 200})
+
+	if info, err := os.Stat(path + ".1"); err != nil || info.Size() != int64(len(full)) {
+		t.Fatalf("rotated file: %v, %v; want the full log", info, err)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"GET / HTTP/1.1" 200`) {
+		t.Errorf("new log = %q, want only the new entry", data)
+	}
+}
+
+func TestAccessLogRemovedWithRoute(t *testing.T) {
+	dir := t.TempDir()
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	store := config.NewStore(routesFile)
+	for _, domain := range []string{"api.app.test", "web.app.test"} {
+		if err := store.AddRoute(config.Route{Domain: domain, Port: 3000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{routesFile: routesFile, access: newAccessLogger(dir, ""), dash: newDashboard(routesFile, nil)}
+	t.Cleanup(srv.access.close)
+	if err := srv.loadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	srv.access.log("api.app.test", AccessEntry{Status: 200})
+	srv.access.log("web.app.test", AccessEntry{Status: 200})
+	if err := os.WriteFile(AccessLogPath(dir, "api.app.test")+".1", []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RemoveRoute("api.app.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.loadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{AccessLogPath(dir, "api.app.test"), AccessLogPath(dir, "api.app.test") + ".1"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s after its route was removed: err = %v, want it gone", filepath.Base(path), err)
+		}
+	}
+	if _, err := os.Stat(AccessLogPath(dir, "web.app.test")); err != nil {
+		t.Errorf("log of the remaining route: %v", err)
+	}
+}
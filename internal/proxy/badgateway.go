@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+const (
+	// badGatewayLogLines is how many lines of service output the 502 page
+	// shows.
+	badGatewayLogLines = 40
+	// probeHeader marks the requests the 502 page sends to see whether the
+	// service is back. They are left out of the access log and metrics.
+	probeHeader = "X-Roxy-Probe"
+)
+
+// ansiEscape matches terminal colour and cursor sequences in service output.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// wantsHTML reports whether r comes from a browser navigating to a page,
+// as opposed to fetch calls, API clients and curl, which keep getting the
+// plain-text error.
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// serveBadGateway renders the HTML 502 page for a route whose upstream
+// refused the connection: the route, the state of its process and the end
+// of its output. The page reloads itself once the service answers again.
+// It is only served to clients on this machine (see fromLocal).
+func (s *Server) serveBadGateway(w http.ResponseWriter, route Route, cause error) {
+	data := struct {
+		Route   Route
+		Command string
+		Dir     string
+		State   string
+		Error   string
+		Source  string
+		Lines   []string
+	}{Route: route, Error: cause.Error()}
+
+	details := config.NewStore(s.routesFile).FindRoute(route.Domain)
+	data.State = processState(details, route.Port)
+	if details != nil {
+		data.Command = details.Command
+		data.Dir = details.Dir
+		data.Source = outputPath(*details)
+	}
+	if data.Source != "" {
+		lines, err := tailLines(data.Source, badGatewayLogLines)
+		if err != nil {
+			data.Lines = []string{"roxy: " + err.Error()}
+		} else {
+			data.Lines = lines
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusBadGateway)
+	if err := badGatewayTmpl.Execute(w, data); err != nil {
+		log.Printf("warning: failed to render bad-gateway page: %v", err)
+	}
+}
+
+// processState describes why nothing is listening on port.
+func processState(r *config.Route, port int) string {
+	switch {
+	case r == nil:
+		return "not registered"
+	case r.PID == 0:
+		return "starting"
+	case !r.Alive():
+		return "exited"
+	default:
+		return "running, but not accepting connections on :" + strconv.Itoa(port) + " yet"
+	}
+}
+
+// outputPath returns the file holding a route's output: the log file of a
+// detached service, or the recent-output file of a foreground one.
+func outputPath(r config.Route) string {
+	if r.LogFile != "" {
+		return r.LogFile
+	}
+	return r.OutputFile
+}
+
+// tailLines returns the last n lines of the file at path, without terminal
+// escape sequences.
+func tailLines(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - logTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		// Drop the partial first line.
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	text := ansiEscape.ReplaceAllString(string(data), "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+var badGatewayTmpl = template.Must(template.New("badgateway").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>roxy - {{.Route.Domain}} unreachable</title>
+<style>
+  * { margin: 0; padding: 0; box-sizing: border-box; }
+  body { background: #0d1117; color: #c9d1d9; font-family: 'SF Mono', 'Cascadia Code', 'Fira Code', monospace; display: flex; justify-content: center; padding: 60px 20px; min-height: 100vh; }
+  .container { max-width: 900px; width: 100%; }
+  h1 { font-size: 1.4rem; color: #f85149; margin-bottom: 6px; }
+  .sub { color: #8b949e; font-size: 0.85rem; margin-bottom: 32px; }
+  h2 { font-size: 0.9rem; color: #8b949e; text-transform: uppercase; letter-spacing: 0.05em; margin-bottom: 12px; }
+  .details { border: 1px solid #21262d; border-radius: 6px; overflow: hidden; margin-bottom: 32px; }
+  .row { display: flex; padding: 10px 14px; border-bottom: 1px solid #21262d; font-size: 0.85rem; }
+  .row:last-child { border-bottom: none; }
+  .key { color: #8b949e; width: 110px; flex-shrink: 0; }
+  .val { word-break: break-all; }
+  .exited { color: #f85149; }
+  pre { border: 1px solid #21262d; border-radius: 6px; background: #010409; padding: 12px 14px; font-size: 0.8rem; line-height: 1.45; white-space: pre-wrap; word-break: break-all; }
+  .empty { color: #8b949e; }
+  .waiting { color: #8b949e; font-size: 0.8rem; margin-top: 16px; }
+</style>
+</head>
+<body>
+<div class="container">
+  <h1>502 bad gateway</h1>
+  <p class="sub"><strong>{{.Route.Domain}}</strong> is not answering on port {{.Route.Port}}</p>
+  <h2>route</h2>
+  <div class="details">
+    <div class="row"><span class="key">upstream</span><span class="val">127.0.0.1:{{.Route.Port}}</span></div>
+    <div class="row"><span class="key">process</span><span class="val{{if eq .State "exited"}} exited{{end}}">{{.State}}</span></div>
+    {{if .Command}}<div class="row"><span class="key">command</span><span class="val">{{.Command}}</span></div>{{end}}
+    {{if .Dir}}<div class="row"><span class="key">directory</span><span class="val">{{.Dir}}</span></div>{{end}}
+    <div class="row"><span class="key">error</span><span class="val">{{.Error}}</span></div>
+  </div>
+  <h2>recent output</h2>
+  {{if .Lines}}
+  <pre>{{range .Lines}}{{.}}
+{{end}}</pre>
+  {{else if .Source}}
+  <pre class="empty">no output yet</pre>
+  {{else}}
+  <pre class="empty">no output captured for this service</pre>
+  {{end}}
+  <p class="waiting">this page reloads when the service is back</p>
+</div>
+<script>
+// Check every second at first, then back off to every 10 seconds, so a
+// forgotten tab stays cheap.
+let delay = 1000;
+async function check() {
+  try {
+    const res = await fetch(location.href, { method: 'HEAD', cache: 'no-store', headers: { 'X-Roxy-Probe': '1' } });
+    if (res.status !== 502) return location.reload();
+  } catch (e) {}
+  delay = Math.min(delay * 2, 10000);
+  setTimeout(check, delay);
+}
+setTimeout(check, delay);
+</script>
+</body>
+</html>`))
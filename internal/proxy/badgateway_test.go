@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/logscore/roxy/internal/process"
+	"github.com/logscore/roxy/pkg/config"
+)
+
+func TestBadGatewayPage(t *testing.T) {
+	var log strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&log, "line %d\n", i)
+	}
+	log.WriteString("\x1b[31merror:\x1b[0m cannot find module 'express'\n")
+	logFile := filepath.Join(t.TempDir(), "web.log")
+	if err := os.WriteFile(logFile, []byte(log.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	port := freePort(t) // nothing listens here
+	srv, proxyServer, _, _ := dashboardProxy(t, []config.Route{
+		{ID: "aaa111", Domain: "web.app.test", Port: port, Command: "npm run dev", PID: os.Getpid(), LogFile: logFile},
+	})
+
+	get := func(accept string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+		req.Host = "web.app.test"
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return resp, string(body)
+	}
+
+	resp, body := get("text/html,application/xhtml+xml,*/*;q=0.8")
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if h := resp.Header.Get(ErrorHeader); h != "unreachable" {
+		t.Errorf("%s = %q, want unreachable", ErrorHeader, h)
+	}
+	for _, want := range []string{
+		"web.app.test",
+		"npm run dev",
+		fmt.Sprintf("running, but not accepting connections on :%d yet", port),
+		"error: cannot find module &#39;express&#39;",
+		"line 50",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "line 10\n") || strings.Contains(body, "\x1b[") {
+		t.Errorf("page shows more than %d lines or raw escapes:\n%s", badGatewayLogLines, body)
+	}
+
+	// Non-browser clients keep the plain-text error.
+	resp, body = get("")
+	if resp.StatusCode != http.StatusBadGateway || !strings.HasPrefix(body, "roxy: upstream unreachable") {
+		t.Errorf("plain request: status = %d, body = %q", resp.StatusCode, body)
+	}
+
+	// Browsers on other machines get the plain-text error too: the page
+	// would show them the command, directory and output.
+	req := httptest.NewRequest("GET", "http://web.app.test/", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	srv.handleHTTP(rec, req)
+	if rec.Code != http.StatusBadGateway || !strings.HasPrefix(rec.Body.String(), "roxy: upstream unreachable") {
+		t.Errorf("request from the LAN: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	for _, secret := range []string{"npm run dev", "cannot find module"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("request from the LAN sees %q", secret)
+		}
+	}
+}
+
+// TestBadGatewayShowsOutputOfExitedProcess verifies that the page shows
+// the last output of a foreground service that has crashed.
+func TestBadGatewayShowsOutputOfExitedProcess(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "web.app.test.out")
+	out, err := process.NewOutputBuffer(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "echo starting; echo 'fatal: cannot connect to database' >&2; exit 1")
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err == nil {
+		t.Fatal("command succeeded, want it to exit 1")
+	}
+	out.Close()
+
+	_, proxyServer, _, _ := dashboardProxy(t, []config.Route{
+		{ID: "aaa111", Domain: "web.app.test", Port: freePort(t), Command: "npm run dev", PID: cmd.Process.Pid, OutputFile: outFile},
+	})
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "web.app.test"
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", resp.StatusCode)
+	}
+	for _, want := range []string{"exited", "starting", "fatal: cannot connect to database"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("page missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// TestBadGatewayProbesAreNotRecorded verifies that the 502 page's checks
+// for whether the service is back stay out of the access log and metrics.
+func TestBadGatewayProbesAreNotRecorded(t *testing.T) {
+	dir := t.TempDir()
+	srv := &Server{access: newAccessLogger(dir, ""), metrics: newMetrics()}
+	t.Cleanup(srv.access.close)
+	proxyServer := serveProxy(t, srv, []Route{{Domain: "web.app.test", Port: freePort(t), Type: "http"}})
+
+	for _, probe := range []bool{true, true, false} {
+		req, _ := http.NewRequest("HEAD", proxyServer.URL+"/", nil)
+		req.Host = "web.app.test"
+		if probe {
+			req.Header.Set(probeHeader, "1")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("status = %d, want 502", resp.StatusCode)
+		}
+	}
+
+	if lines := readAccessLog(t, AccessLogPath(dir, "web.app.test"), 1); len(lines) != 1 {
+		t.Errorf("access log has %d lines, want only the request without %s:\n%s", len(lines), probeHeader, strings.Join(lines, "\n"))
+	}
+	waitForMetric(t, proxyServer.URL, `roxy_http_requests_total{route="web.app.test",method="HEAD",code="502"} 1`)
+}
+
+func TestTailLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out")
+	if err := os.WriteFile(path, []byte("a\r\nb\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := tailLines(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lines, ",") != "b,c" {
+		t.Errorf("lines = %q", lines)
+	}
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := tailLines(path, 2); err != nil || lines != nil {
+		t.Errorf("empty file: lines = %q, err = %v", lines, err)
+	}
+}
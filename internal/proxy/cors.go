@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// corsResponseHeaders are the headers roxy owns on routes with a CORS
+// policy. Values set by the service are dropped so they cannot conflict.
+var corsResponseHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Expose-Headers",
+}
+
+// isPreflight reports whether r is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// serveCORSPreflight answers a preflight request without contacting the
+// service. Disallowed origins get a 403 so the reason is visible in the
+// browser's network panel.
+func serveCORSPreflight(w http.ResponseWriter, r *http.Request, cfg *config.CORSConfig) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	origin := r.Header.Get("Origin")
+	if !cfg.AllowsOrigin(origin) {
+		http.Error(w, "roxy: origin "+origin+" is not allowed by this route's cors policy", http.StatusForbidden)
+		return
+	}
+
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = config.DefaultCORSMethods
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(cfg.Headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(cfg.Headers, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	}
+	if cfg.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if cfg.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(cfg.MaxAge).Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyCORS adds CORS headers to a response for a request from origin (the
+// client's Origin header, before any rewriting). The allowed origin is
+// always echoed rather than "*", which keeps credentialed requests working.
+func applyCORS(h http.Header, origin string, cfg *config.CORSConfig) {
+	if cfg == nil {
+		return
+	}
+	for _, name := range corsResponseHeaders {
+		h.Del(name)
+	}
+	h.Add("Vary", "Origin")
+
+	if !cfg.AllowsOrigin(origin) {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if cfg.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(cfg.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposeHeaders, ", "))
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+func corsProxy(t *testing.T, cors *config.CORSConfig, upstreamHits *int) *httptest.Server {
+	t.Helper()
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		*upstreamHits++
+		w.Header().Set("Access-Control-Allow-Origin", "*") // overridden by the policy
+		w.Header().Set("X-Request-Id", "abc")
+		w.WriteHeader(http.StatusOK)
+	})
+	return proxyTo(t, upstream, Route{Domain: "api.app.test", CORS: cors})
+}
+
+func TestCORSPreflight(t *testing.T) {
+	var hits int
+	proxyServer := corsProxy(t, &config.CORSConfig{
+		Origins:     []string{"*.test"},
+		Credentials: true,
+		MaxAge:      config.Duration(10 * time.Minute),
+	}, &hits)
+
+	tests := []struct {
+		name       string
+		origin     string
+		wantStatus int
+		wantOrigin string
+	}{
+		{"allowed wildcard", "http://web.app.test", http.StatusNoContent, "http://web.app.test"},
+		{"allowed with port", "https://web.app.test:8443", http.StatusNoContent, "https://web.app.test:8443"},
+		{"disallowed", "https://evil.example", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("OPTIONS", proxyServer.URL+"/users", nil)
+			req.Host = "api.app.test"
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+			req.Header.Set("Access-Control-Request-Headers", "content-type, x-csrf-token")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.wantStatus != http.StatusNoContent {
+				return
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Headers"); got != "content-type, x-csrf-token" {
+				t.Errorf("Allow-Headers = %q, want the requested headers", got)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Methods"); got != "GET, HEAD, POST, PUT, PATCH, DELETE" {
+				t.Errorf("Allow-Methods = %q, want the defaults", got)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Errorf("Allow-Credentials = %q, want true", got)
+			}
+			if got := resp.Header.Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("Max-Age = %q, want 600", got)
+			}
+		})
+	}
+
+	if hits != 0 {
+		t.Errorf("upstream saw %d preflight requests, want 0", hits)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	var hits int
+	proxyServer := corsProxy(t, &config.CORSConfig{
+		Origins:       []string{"http://web.app.test"},
+		ExposeHeaders: []string{"X-Request-Id"},
+	}, &hits)
+
+	fetch := func(origin string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", proxyServer.URL+"/users", nil)
+		req.Host = "api.app.test"
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp := fetch("http://web.app.test")
+	if got := resp.Header.Values("Access-Control-Allow-Origin"); len(got) != 1 || got[0] != "http://web.app.test" {
+		t.Errorf("Allow-Origin = %q, want exactly the request origin", got)
+	}
+	if got := resp.Header.Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+		t.Errorf("Expose-Headers = %q, want X-Request-Id", got)
+	}
+	if got := resp.Header.Get("Vary"); got != "Origin" {
+		t.Errorf("Vary = %q, want Origin", got)
+	}
+
+	resp = fetch("http://other.app.test")
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Allow-Origin for disallowed origin = %q, want none", got)
+	}
+	if hits != 2 {
+		t.Errorf("upstream hits = %d, want 2", hits)
+	}
+}
+
+func TestCORSOnUpstreamError(t *testing.T) {
+	proxyServer := serveProxy(t, &Server{}, []Route{{
+		Domain: "api.app.test", Port: 1, Type: "http",
+		CORS: &config.CORSConfig{Origins: []string{"*"}},
+	}})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "api.app.test"
+	req.Header.Set("Origin", "http://web.app.test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "http://web.app.test" {
+		t.Errorf("Allow-Origin = %q, want the request origin", got)
+	}
+}
@@ -0,0 +1,564 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+const (
+	// maxStoppedRoutes caps how many stopped services the dashboard remembers.
+	maxStoppedRoutes = 50
+	// logTailBytes is how much existing log output a new log stream starts with.
+	logTailBytes = 64 << 10
+	// logPollInterval is how often a log stream checks for new output.
+	logPollInterval = 250 * time.Millisecond
+)
+
+// Controller starts and stops services on behalf of the dashboard. The cmd
+// package provides the implementation, so the buttons do exactly what
+// `roxy run` and `roxy stop` do.
+type Controller interface {
+	Start(route config.Route) error
+	Stop(route config.Route) error
+	Restart(route config.Route) error
+}
+
+// dashboard serves the web UI at reservedHost. It reads routes.json for
+// the full route details and remembers routes that disappear from it, so
+// stopped services can be started again.
+type dashboard struct {
+	store   *config.Store
+	control Controller // nil: read-only dashboard
+
+	mu      sync.Mutex
+	seen    map[string]config.Route // by ID, as of the last observe
+	stopped map[string]stoppedRoute // by ID
+}
+
+type stoppedRoute struct {
+	route config.Route
+	at    time.Time
+}
+
+func newDashboard(routesFile string, control Controller) *dashboard {
+	return &dashboard{
+		store:   config.NewStore(routesFile),
+		control: control,
+		seen:    make(map[string]config.Route),
+		stopped: make(map[string]stoppedRoute),
+	}
+}
+
+// observe records the current routes. Routes that were present last time
+// but are gone now are remembered as stopped.
+func (d *dashboard) observe(routes []config.Route) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := make(map[string]config.Route, len(routes))
+	for _, r := range routes {
+		if r.ID == "" {
+			continue
+		}
+		current[r.ID] = r
+		delete(d.stopped, r.ID)
+	}
+	for id, r := range d.seen {
+		if _, ok := current[id]; !ok && r.Command != "" {
+			d.stopped[id] = stoppedRoute{route: r, at: time.Now()}
+		}
+	}
+	d.seen = current
+
+	for len(d.stopped) > maxStoppedRoutes {
+		oldest := ""
+		for id, sr := range d.stopped {
+			if oldest == "" || sr.at.Before(d.stopped[oldest].at) {
+				oldest = id
+			}
+		}
+		delete(d.stopped, oldest)
+	}
+}
+
+// routeView is one row of the dashboard, as served by /api/routes.
+type routeView struct {
+	ID         string    `json:"id"`
+	Domain     string    `json:"domain"`
+	URL        string    `json:"url,omitempty"`
+	Type       string    `json:"type"`
+	Port       int       `json:"port"`
+	ListenPort int       `json:"listen_port,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	Status     string    `json:"status"` // "running", "starting", "exited" or "stopped"
+	Created    time.Time `json:"created"`
+	Uptime     float64   `json:"uptime_seconds,omitempty"`
+	Restarts   int       `json:"restarts"`
+	PublicURL  string    `json:"public_url,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Dir        string    `json:"dir,omitempty"`
+	HasLogs    bool      `json:"has_logs"`
+}
+
+func newRouteView(r config.Route, status string) routeView {
+	v := routeView{
+		ID:         r.ID,
+		Domain:     r.Domain,
+		Type:       r.Type,
+		Port:       r.Port,
+		ListenPort: r.ListenPort,
+		Status:     status,
+		Created:    r.Created,
+		Restarts:   r.Restarts,
+		PublicURL:  r.PublicURL,
+		Command:    r.Command,
+		Dir:        r.Dir,
+		HasLogs:    outputPath(r) != "",
+	}
+	if v.Type == "" {
+		v.Type = "http"
+	}
+	if v.Type == "http" {
+		scheme := "http"
+		if r.TLS {
+			scheme = "https"
+		}
+		v.URL = scheme + "://" + r.Domain
+	}
+	if status != "stopped" {
+		v.PID = r.PID
+	}
+	if status == "running" && !r.Created.IsZero() {
+		v.Uptime = time.Since(r.Created).Round(time.Second).Seconds()
+	}
+	return v
+}
+
+// routes returns running routes followed by stopped ones, each sorted by
+// domain.
+func (d *dashboard) routes() ([]routeView, error) {
+	running, err := d.store.LoadRoutes()
+	if err != nil {
+		return nil, err
+	}
+	views := make([]routeView, 0, len(running))
+	for _, r := range running {
+		status := "running"
+		switch {
+		case r.PID == 0:
+			status = "starting"
+		case !r.Alive():
+			status = "exited"
+		}
+		views = append(views, newRouteView(r, status))
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Domain < views[j].Domain })
+
+	d.mu.Lock()
+	var stopped []routeView
+	for _, sr := range d.stopped {
+		stopped = append(stopped, newRouteView(sr.route, "stopped"))
+	}
+	d.mu.Unlock()
+	sort.Slice(stopped, func(i, j int) bool { return stopped[i].Domain < stopped[j].Domain })
+
+	return append(views, stopped...), nil
+}
+
+// find returns a running or stopped route by ID.
+func (d *dashboard) find(id string) (config.Route, bool, error) {
+	routes, err := d.store.LoadRoutes()
+	if err != nil {
+		return config.Route{}, false, err
+	}
+	for _, r := range routes {
+		if r.ID == id {
+			return r, true, nil
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if sr, ok := d.stopped[id]; ok {
+		return sr.route, false, nil
+	}
+	return config.Route{}, false, errRouteNotFound
+}
+
+var errRouteNotFound = errors.New("route not found")
+
+// fromLocal reports whether r comes from a client on this machine. The
+// proxy listens on every interface, so anything that shows a service's
+// command, directory or output is kept to local clients.
+func fromLocal(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// fromDashboard reports whether r may control services or read their
+// logs. The client must be on this machine, and the request must come from
+// the dashboard page itself: browsers always send Origin with POSTs and
+// WebSocket handshakes, so a missing Origin, like one from another site,
+// is refused.
+func fromDashboard(r *http.Request) bool {
+	if !fromLocal(r) {
+		return false
+	}
+	u, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && strings.EqualFold(u.Hostname(), reservedHost)
+}
+
+func (d *dashboard) serveIndex(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTmpl.Execute(w, struct{ ReadOnly bool }{d.control == nil}); err != nil {
+		log.Printf("warning: failed to render dashboard: %v", err)
+	}
+}
+
+// serveRoutes lists the routes for the dashboard. The list holds commands,
+// directories and public tunnel URLs, so it is only served locally.
+func (d *dashboard) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	if !fromLocal(r) {
+		http.Error(w, "request rejected: the dashboard is only available on this machine", http.StatusForbidden)
+		return
+	}
+	views, err := d.routes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(views)
+}
+
+func (d *dashboard) serveAction(w http.ResponseWriter, r *http.Request) {
+	if !fromDashboard(r) {
+		http.Error(w, "request rejected: use the dashboard at http://"+reservedHost, http.StatusForbidden)
+		return
+	}
+	if d.control == nil {
+		http.Error(w, "service control is not available", http.StatusNotImplemented)
+		return
+	}
+	route, running, err := d.find(r.PathValue("id"))
+	if errors.Is(err, errRouteNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	action := r.PathValue("action")
+	switch action {
+	case "start":
+		if running {
+			http.Error(w, route.Domain+" is already running", http.StatusConflict)
+			return
+		}
+		err = d.control.Start(route)
+	case "stop", "restart":
+		if !running {
+			http.Error(w, route.Domain+" is not running", http.StatusConflict)
+			return
+		}
+		if action == "stop" {
+			err = d.control.Stop(route)
+		} else {
+			err = d.control.Restart(route)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("dashboard: %s %s: %v", action, route.Domain, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("dashboard: %s %s", action, route.Domain)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var logUpgrader = websocket.Upgrader{CheckOrigin: fromDashboard}
+
+// serveLogs streams a route's log file over a WebSocket: the recent tail
+// first, then new output as it is written.
+func (d *dashboard) serveLogs(w http.ResponseWriter, r *http.Request) {
+	route, _, err := d.find(r.PathValue("id"))
+	if errors.Is(err, errRouteNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := logUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has replied
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Reading is only needed to notice the client going away.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	path := outputPath(route)
+	if path == "" {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("no output captured for "+route.Domain+"\n"))
+		return
+	}
+	if err := tailLog(conn, path, done); err != nil {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("roxy: "+err.Error()+"\n"))
+	}
+}
+
+// tailLog sends the end of path and then follows it until done is closed.
+// A truncated file (the service was restarted) is followed from the start.
+func tailLog(conn *websocket.Conn, path string, done <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	var offset int64
+	if info, err := f.Stat(); err == nil && info.Size() > logTailBytes {
+		offset = info.Size() - logTailBytes
+	}
+	skipPartial := offset > 0
+	buf := make([]byte, 32<<10)
+
+	for {
+		if info, err := f.Stat(); err == nil && info.Size() < offset {
+			offset = 0
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("\n--- log restarted ---\n"))
+		}
+		for {
+			n, err := f.ReadAt(buf, offset)
+			if n > 0 {
+				chunk := buf[:n]
+				offset += int64(n)
+				if skipPartial {
+					// Start at a line boundary when starting mid-file.
+					if i := strings.IndexByte(string(chunk), '\n'); i >= 0 {
+						chunk = chunk[i+1:]
+						skipPartial = false
+					} else {
+						chunk = nil
+					}
+				}
+				if len(chunk) > 0 {
+					if err := conn.WriteMessage(websocket.TextMessage, chunk); err != nil {
+						return nil // client went away
+					}
+				}
+			}
+			if err == io.EOF || n == 0 {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// serveReserved answers requests for reservedHost, which no route can
+// claim (generated domains always have a project label): the dashboard,
+// its API and /metrics.
+func (s *Server) serveReserved(w http.ResponseWriter, r *http.Request) {
+	s.reservedOnce.Do(func() {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", s.metrics)
+		mux.HandleFunc("GET /{$}", s.dash.serveIndex)
+		mux.HandleFunc("GET /api/routes", s.dash.serveRoutes)
+		mux.HandleFunc("POST /api/routes/{id}/{action}", s.dash.serveAction)
+		mux.HandleFunc("GET /api/routes/{id}/logs", s.dash.serveLogs)
+		s.reservedMux = mux
+	})
+	s.reservedMux.ServeHTTP(w, r)
+}
+
+var dashboardTmpl = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>roxy</title>
+<style>
+  * { margin: 0; padding: 0; box-sizing: border-box; }
+  body { background: #0d1117; color: #c9d1d9; font-family: 'SF Mono', 'Cascadia Code', 'Fira Code', monospace; display: flex; justify-content: center; padding: 60px 20px; min-height: 100vh; }
+  .container { max-width: 1000px; width: 100%; }
+  h1 { font-size: 1.4rem; color: #58a6ff; margin-bottom: 6px; }
+  .sub { color: #8b949e; font-size: 0.85rem; margin-bottom: 32px; }
+  .sub a { color: #8b949e; }
+  h2 { font-size: 0.9rem; color: #8b949e; text-transform: uppercase; letter-spacing: 0.05em; margin: 24px 0 12px; }
+  table { width: 100%; border: 1px solid #21262d; border-radius: 6px; border-collapse: separate; border-spacing: 0; overflow: hidden; font-size: 0.85rem; }
+  th { text-align: left; color: #8b949e; font-weight: normal; padding: 8px 12px; border-bottom: 1px solid #21262d; }
+  td { padding: 10px 12px; border-bottom: 1px solid #21262d; vertical-align: top; }
+  tr:last-child td { border-bottom: none; }
+  a { color: #58a6ff; text-decoration: none; }
+  a:hover { text-decoration: underline; }
+  .muted { color: #8b949e; }
+  .cmd { color: #8b949e; font-size: 0.75rem; margin-top: 4px; word-break: break-all; }
+  .status { font-size: 0.7rem; padding: 2px 6px; border-radius: 3px; background: #21262d; }
+  .running { color: #3fb950; }
+  .starting { color: #d29922; }
+  .exited, .stopped { color: #f85149; }
+  button { font: inherit; font-size: 0.75rem; color: #c9d1d9; background: #21262d; border: 1px solid #30363d; border-radius: 4px; padding: 3px 8px; cursor: pointer; margin: 0 4px 4px 0; }
+  button:hover { border-color: #8b949e; }
+  button:disabled { opacity: 0.5; cursor: default; }
+  .empty { padding: 20px 14px; color: #8b949e; text-align: center; }
+  #error { color: #f85149; font-size: 0.85rem; margin-bottom: 12px; min-height: 1em; }
+  #logs { display: none; }
+  #logs pre { background: #010409; border: 1px solid #21262d; border-radius: 6px; padding: 12px; height: 360px; overflow: auto; font-size: 0.75rem; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<div class="container">
+  <h1>roxy</h1>
+  <p class="sub">dev services behind the proxy &middot; <a href="/metrics">metrics</a></p>
+  <div id="error"></div>
+  <h2>services</h2>
+  <table>
+    <thead><tr><th>service</th><th>status</th><th>port</th><th>pid</th><th>uptime</th><th>restarts</th><th></th></tr></thead>
+    <tbody id="routes"><tr><td colspan="7" class="empty">loading&hellip;</td></tr></tbody>
+  </table>
+  <div id="logs">
+    <h2>logs &middot; <span id="logs-title"></span> <button onclick="closeLogs()">close</button></h2>
+    <pre id="logs-out"></pre>
+  </div>
+</div>
+<script>
+const readOnly = {{.ReadOnly}};
+let logSocket = null;
+
+function esc(s) {
+  return String(s ?? '').replace(/[&<>"']/g, c => ({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;',"'":'&#39;'}[c]));
+}
+
+function uptime(sec) {
+  if (!sec) return '';
+  const d = Math.floor(sec / 86400), h = Math.floor(sec % 86400 / 3600), m = Math.floor(sec % 3600 / 60), s = Math.floor(sec % 60);
+  if (d) return d + 'd ' + h + 'h';
+  if (h) return h + 'h ' + m + 'm';
+  if (m) return m + 'm ' + s + 's';
+  return s + 's';
+}
+
+function row(r) {
+  const name = r.url ? '<a href="' + esc(r.url) + '" target="_blank">' + esc(r.domain) + '</a>' : esc(r.domain);
+  const pub = r.public_url ? '<div class="cmd"><a href="' + esc(r.public_url) + '" target="_blank">' + esc(r.public_url) + '</a></div>' : '';
+  const port = r.type === 'tcp' ? ':' + r.listen_port + ' &rarr; :' + r.port : ':' + r.port;
+  let actions = '';
+  if (!readOnly) {
+    actions += r.status === 'stopped'
+      ? '<button data-action="start">start</button>'
+      : '<button data-action="restart">restart</button><button data-action="stop">stop</button>';
+  }
+  if (r.has_logs) {
+    actions += '<button data-action="logs">logs</button>';
+  }
+  return '<tr data-id="' + esc(r.id) + '" data-domain="' + esc(r.domain) + '"><td>' + name + pub + '<div class="cmd">' + esc(r.command) + '</div></td>' +
+    '<td><span class="status ' + esc(r.status) + '">' + esc(r.status) + '</span></td>' +
+    '<td class="muted">' + port + '</td>' +
+    '<td class="muted">' + (r.pid || '') + '</td>' +
+    '<td class="muted">' + uptime(r.uptime_seconds) + '</td>' +
+    '<td class="muted">' + r.restarts + '</td>' +
+    '<td>' + actions + '</td></tr>';
+}
+
+async function refresh() {
+  try {
+    const resp = await fetch('/api/routes');
+    const routes = await resp.json();
+    document.getElementById('routes').innerHTML = routes.length
+      ? routes.map(row).join('')
+      : '<tr><td colspan="7" class="empty">no services running &mdash; start one with roxy run</td></tr>';
+  } catch (e) {
+    document.getElementById('error').textContent = 'cannot reach the proxy: ' + e;
+  }
+}
+
+document.getElementById('routes').addEventListener('click', e => {
+  const btn = e.target.closest('button');
+  if (!btn) return;
+  const tr = btn.closest('tr');
+  if (btn.dataset.action === 'logs') {
+    openLogs(tr.dataset.id, tr.dataset.domain);
+  } else {
+    act(btn, tr.dataset.id, btn.dataset.action);
+  }
+});
+
+async function act(btn, id, action) {
+  btn.disabled = true;
+  document.getElementById('error').textContent = '';
+  const resp = await fetch('/api/routes/' + encodeURIComponent(id) + '/' + action, {method: 'POST'});
+  if (!resp.ok) {
+    document.getElementById('error').textContent = action + ' failed: ' + await resp.text();
+  }
+  setTimeout(refresh, 600);
+}
+
+function openLogs(id, domain) {
+  closeLogs();
+  const out = document.getElementById('logs-out');
+  out.textContent = '';
+  document.getElementById('logs-title').textContent = domain;
+  document.getElementById('logs').style.display = 'block';
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  logSocket = new WebSocket(proto + '//' + location.host + '/api/routes/' + encodeURIComponent(id) + '/logs');
+  logSocket.onmessage = e => {
+    const atBottom = out.scrollTop + out.clientHeight >= out.scrollHeight - 4;
+    out.textContent += e.data;
+    if (atBottom) out.scrollTop = out.scrollHeight;
+  };
+}
+
+function closeLogs() {
+  if (logSocket) { logSocket.close(); logSocket = null; }
+  document.getElementById('logs').style.display = 'none';
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>`))
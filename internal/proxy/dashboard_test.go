@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+type fakeController struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (c *fakeController) record(action string, r config.Route) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, action+" "+r.Domain)
+	return nil
+}
+
+func (c *fakeController) Start(r config.Route) error   { return c.record("start", r) }
+func (c *fakeController) Stop(r config.Route) error    { return c.record("stop", r) }
+func (c *fakeController) Restart(r config.Route) error { return c.record("restart", r) }
+
+func (c *fakeController) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func dashboardProxy(t *testing.T, routes []config.Route) (*Server, *httptest.Server, *fakeController, *config.Store) {
+	t.Helper()
+	routesFile := filepath.Join(t.TempDir(), "routes.json")
+	store := config.NewStore(routesFile)
+	for _, r := range routes {
+		if err := store.AddRoute(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctrl := &fakeController{}
+	srv := &Server{routesFile: routesFile, dash: newDashboard(routesFile, ctrl), metrics: newMetrics()}
+	if err := srv.loadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+	t.Cleanup(proxyServer.Close)
+	return srv, proxyServer, ctrl, store
+}
+
+func dashboardRequest(t *testing.T, proxyURL, method, path, origin string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, proxyURL+path, nil)
+	req.Host = reservedHost
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	return resp, string(body)
+}
+
+func fetchRouteViews(t *testing.T, proxyURL string) []routeView {
+	t.Helper()
+	resp, body := dashboardRequest(t, proxyURL, "GET", "/api/routes", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/routes: status %d: %s", resp.StatusCode, body)
+	}
+	var views []routeView
+	if err := json.Unmarshal([]byte(body), &views); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return views
+}
+
+func TestDashboardIndex(t *testing.T) {
+	_, proxyServer, _, _ := dashboardProxy(t, nil)
+
+	resp, body := dashboardRequest(t, proxyServer.URL, "GET", "/", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	// html/template pads JS values with spaces.
+	normalized := strings.Join(strings.Fields(body), " ")
+	if !strings.Contains(body, "<title>roxy</title>") || !strings.Contains(normalized, "const readOnly = false ;") {
+		t.Errorf("unexpected dashboard page:\n%s", body)
+	}
+}
+
+func TestDashboardRoutesAndActions(t *testing.T) {
+	srv, proxyServer, ctrl, store := dashboardProxy(t, []config.Route{
+		{ID: "aaa111", Domain: "web.app.test", Port: 3000, Command: "npm run dev", PID: os.Getpid(), Created: time.Now().Add(-time.Minute), Restarts: 2},
+		{ID: "bbb222", Domain: "api.app.test", Port: 3001, Command: "go run .", PID: os.Getpid()},
+	})
+
+	views := fetchRouteViews(t, proxyServer.URL)
+	if len(views) != 2 || views[0].Domain != "api.app.test" || views[1].Domain != "web.app.test" {
+		t.Fatalf("views = %+v", views)
+	}
+	web := views[1]
+	if web.Status != "running" || web.URL != "http://web.app.test" || web.Restarts != 2 || web.Uptime < 60 {
+		t.Errorf("web view = %+v", web)
+	}
+
+	// A route that leaves routes.json is kept as stopped.
+	if err := store.RemoveRoute("api.app.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.loadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	views = fetchRouteViews(t, proxyServer.URL)
+	if len(views) != 2 || views[1].Domain != "api.app.test" || views[1].Status != "stopped" || views[1].PID != 0 {
+		t.Fatalf("after stop: views = %+v", views)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/api/routes/bbb222/start", http.StatusNoContent},
+		{"/api/routes/bbb222/stop", http.StatusConflict},
+		{"/api/routes/aaa111/restart", http.StatusNoContent},
+		{"/api/routes/aaa111/stop", http.StatusNoContent},
+		{"/api/routes/aaa111/start", http.StatusConflict},
+		{"/api/routes/aaa111/explode", http.StatusNotFound},
+		{"/api/routes/zzz999/stop", http.StatusNotFound},
+	} {
+		resp, body := dashboardRequest(t, proxyServer.URL, "POST", tt.path, "http://roxy.test")
+		if resp.StatusCode != tt.want {
+			t.Errorf("POST %s: status = %d, want %d (%s)", tt.path, resp.StatusCode, tt.want, body)
+		}
+	}
+	want := []string{"start api.app.test", "restart web.app.test", "stop web.app.test"}
+	if got := ctrl.Calls(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("controller calls = %v, want %v", got, want)
+	}
+}
+
+func TestDashboardRejectsCrossOrigin(t *testing.T) {
+	_, proxyServer, ctrl, _ := dashboardProxy(t, []config.Route{
+		{ID: "aaa111", Domain: "web.app.test", Port: 3000, Command: "npm run dev", PID: os.Getpid()},
+	})
+
+	for _, origin := range []string{"http://evil.example", ""} {
+		resp, _ := dashboardRequest(t, proxyServer.URL, "POST", "/api/routes/aaa111/stop", origin)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Origin %q: status = %d, want 403", origin, resp.StatusCode)
+		}
+	}
+	if calls := ctrl.Calls(); len(calls) != 0 {
+		t.Errorf("controller called: %v", calls)
+	}
+}
+
+func TestDashboardRejectsRemoteClients(t *testing.T) {
+	srv, _, ctrl, _ := dashboardProxy(t, []config.Route{
+		{ID: "aaa111", Domain: "web.app.test", Port: 3000, Command: "npm run dev", PID: os.Getpid()},
+	})
+
+	for _, path := range []string{"/api/routes", "/api/routes/aaa111/stop", "/api/routes/aaa111/logs"} {
+		method := "GET"
+		if strings.HasSuffix(path, "/stop") {
+			method = "POST"
+		}
+		req := httptest.NewRequest(method, "http://"+reservedHost+path, nil)
+		req.RemoteAddr = "192.168.1.20:51234"
+		req.Header.Set("Origin", "http://"+reservedHost)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		rec := httptest.NewRecorder()
+		srv.handleHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s from the LAN: status = %d, want 403", method, path, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "npm run dev") {
+			t.Errorf("%s %s from the LAN sees the command: %s", method, path, rec.Body.String())
+		}
+	}
+	if calls := ctrl.Calls(); len(calls) != 0 {
+		t.Errorf("controller called: %v", calls)
+	}
+}
+
+func TestDashboardStreamsLogs(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "web.log")
+	if err := os.WriteFile(logFile, []byte("compiling...\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, proxyServer, _, _ := dashboardProxy(t, []config.Route{
+		{ID: "aaa111", Domain: "web.app.test", Port: 3000, Command: "npm run dev", PID: os.Getpid(), LogFile: logFile},
+	})
+
+	wsURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/api/routes/aaa111/logs"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Host": {reservedHost}, "Origin": {"http://" + reservedHost}})
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial: %v (status %d)", err, status)
+	}
+	defer func() { _ = conn.Close() }()
+
+	read := func() string {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return string(msg)
+	}
+
+	if got := read(); got != "compiling...\n" {
+		t.Errorf("first message = %q", got)
+	}
+
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("listening on :3000\n")
+	_ = f.Close()
+
+	if got := read(); got != "listening on :3000\n" {
+		t.Errorf("followed message = %q", got)
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// pickFault returns the first rule that matches r and fires, with its
+// position, or nil.
+func pickFault(rules []config.FaultRule, r *http.Request) (*config.FaultRule, int) {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Matches(r.Method, r.URL.Path) {
+			continue
+		}
+		if rule.Rate > 0 && rand.Float64() >= rule.Rate {
+			continue
+		}
+		return rule, i
+	}
+	return nil, -1
+}
+
+// injectFault applies a route's fault rules to r. It reports true when the
+// request was answered (or dropped) by a fault. A FaultAbort rule is
+// returned instead: the request is still proxied, but the caller must cut
+// the response short with an abortingResponseWriter.
+func injectFault(w http.ResponseWriter, r *http.Request, entry *routeEntry, host string) (*config.FaultRule, bool) {
+	rule, i := pickFault(entry.route.Faults, r)
+	if rule == nil {
+		return nil, false
+	}
+	log.Printf("fault [%s %s %s]: rule %d: %s", host, r.Method, r.URL.Path, i+1, rule)
+
+	switch rule.Action {
+	case config.FaultError:
+		applyCORS(w.Header(), r.Header.Get("Origin"), entry.route.CORS)
+		http.Error(w, "roxy: injected fault", rule.StatusCode())
+		return nil, true
+
+	case config.FaultTimeout:
+		if rule.Delay <= 0 {
+			<-r.Context().Done()
+			return nil, true
+		}
+		if sleepCtx(r.Context(), time.Duration(rule.Delay)) {
+			applyCORS(w.Header(), r.Header.Get("Origin"), entry.route.CORS)
+			http.Error(w, "roxy: injected timeout", http.StatusGatewayTimeout)
+		}
+		return nil, true
+
+	case config.FaultAbort:
+		return rule, false
+	}
+	return nil, false
+}
+
+// abortingResponseWriter passes through the response headers and the
+// first limit body bytes, then aborts the connection (or HTTP/2 stream).
+type abortingResponseWriter struct {
+	http.ResponseWriter
+	limit   int
+	written int
+}
+
+func (w *abortingResponseWriter) WriteHeader(code int) {
+	// With a known length, default to half the body and never let the
+	// last byte through.
+	if n, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil && n > 0 {
+		if w.limit == 0 {
+			w.limit = n / 2
+		}
+		w.limit = min(w.limit, n-1)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *abortingResponseWriter) Write(b []byte) (int, error) {
+	if remaining := w.limit - w.written; len(b) > remaining {
+		if remaining > 0 {
+			n, _ := w.ResponseWriter.Write(b[:remaining])
+			w.written += n
+		}
+		w.abort()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
+}
+
+// abort flushes what was written so far and kills the response.
+func (w *abortingResponseWriter) abort() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func (w *abortingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
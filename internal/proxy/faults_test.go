@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+func faultProxy(t *testing.T, faults []config.FaultRule, body []byte, upstreamHits *int) *httptest.Server {
+	t.Helper()
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if upstreamHits != nil {
+			*upstreamHits++
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	})
+	return proxyTo(t, upstream, Route{Domain: "api.app.test", Faults: faults})
+}
+
+func faultRequest(ctx context.Context, proxyURL, method, path string) (*http.Response, []byte, error) {
+	req, _ := http.NewRequestWithContext(ctx, method, proxyURL+path, nil)
+	req.Host = "api.app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	return resp, body, err
+}
+
+func TestFaultErrorMatchesPathAndMethod(t *testing.T) {
+	var hits int
+	proxyServer := faultProxy(t, []config.FaultRule{
+		{Action: config.FaultError, Path: "/api/orders", Method: "POST", Status: 500},
+		{Action: config.FaultError, Path: "/api/*/slow"},
+	}, []byte("ok"), &hits)
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"POST", "/api/orders/42", 500},
+		{"GET", "/api/orders/42", 200},
+		{"GET", "/api/users/slow", 503},
+		{"GET", "/api/users/fast", 200},
+	}
+	for _, tt := range tests {
+		resp, _, err := faultRequest(context.Background(), proxyServer.URL, tt.method, tt.path)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.want)
+		}
+	}
+	if hits != 2 {
+		t.Errorf("upstream hits = %d, want 2 (faulted requests must not reach it)", hits)
+	}
+}
+
+func TestFaultRate(t *testing.T) {
+	proxyServer := faultProxy(t, []config.FaultRule{{Action: config.FaultError, Rate: 0.5}}, []byte("ok"), nil)
+
+	failed := 0
+	for range 200 {
+		resp, _, err := faultRequest(context.Background(), proxyServer.URL, "GET", "/")
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			failed++
+		}
+	}
+	// Binomial(200, 0.5): outside 50-150 is astronomically unlikely.
+	if failed < 50 || failed > 150 {
+		t.Errorf("%d of 200 requests failed, want roughly half", failed)
+	}
+}
+
+func TestFaultTimeout(t *testing.T) {
+	withDelay := faultProxy(t, []config.FaultRule{
+		{Action: config.FaultTimeout, Delay: config.Duration(100 * time.Millisecond)},
+	}, []byte("ok"), nil)
+	start := time.Now()
+	resp, _, err := faultRequest(context.Background(), withDelay.URL, "GET", "/")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("answered after %v, want at least 100ms", elapsed)
+	}
+
+	forever := faultProxy(t, []config.FaultRule{{Action: config.FaultTimeout}}, []byte("ok"), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := faultRequest(ctx, forever.URL, "GET", "/"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the client's deadline to expire", err)
+	}
+}
+
+func TestFaultAbortMidBody(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 10_000)
+
+	tests := []struct {
+		name     string
+		rule     config.FaultRule
+		wantRead int
+	}{
+		{"half by default", config.FaultRule{Action: config.FaultAbort}, 5_000},
+		{"explicit byte count", config.FaultRule{Action: config.FaultAbort, AbortAfter: 1_234}, 1_234},
+		{"body shorter than limit", config.FaultRule{Action: config.FaultAbort, AbortAfter: 50_000}, 9_999},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyServer := faultProxy(t, []config.FaultRule{tt.rule}, payload, nil)
+			resp, body, err := faultRequest(context.Background(), proxyServer.URL, "GET", "/")
+			if err == nil {
+				t.Fatal("expected the response body to be cut off")
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want 200", resp.StatusCode)
+			}
+			if len(body) != tt.wantRead {
+				t.Errorf("read %d bytes before the abort, want %d", len(body), tt.wantRead)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// forwardingHeaders are the request headers that describe earlier hops.
+// They are always rewritten by the proxy; incoming values are only kept
+// (and extended) on routes that trust them.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// setForwardedHeaders writes X-Forwarded-For/Host/Port/Proto, X-Real-IP and
+// an RFC 7239 Forwarded element onto out, describing the request in as
+// received by this proxy.
+//
+// Without trust, whatever the client sent is discarded and the headers
+// describe only this hop. With trust (e.g. behind a tunnel that terminates
+// TLS), the client IP is appended to the incoming chain and the original
+// host, port and proto are preserved.
+func setForwardedHeaders(out http.Header, in *http.Request, trust bool) {
+	clientIP := in.RemoteAddr
+	if ip, _, err := net.SplitHostPort(in.RemoteAddr); err == nil {
+		clientIP = ip
+	}
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	host := in.Host
+	port := defaultPort(proto)
+	if _, p, err := net.SplitHostPort(in.Host); err == nil {
+		port = p
+	}
+
+	xff := clientIP
+	realIP := clientIP
+	forwarded := forwardedElement(clientIP, in.Host, proto)
+
+	if trust {
+		if prior := strings.Join(in.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+			xff = prior + ", " + clientIP
+			realIP = strings.TrimSpace(strings.Split(prior, ",")[0])
+		}
+		if v := in.Header.Get("X-Real-Ip"); v != "" {
+			realIP = v
+		}
+		if prior := strings.Join(in.Header.Values("Forwarded"), ", "); prior != "" {
+			forwarded = prior + ", " + forwarded
+		}
+		if v := in.Header.Get("X-Forwarded-Proto"); v != "" {
+			proto = v
+			if in.Header.Get("X-Forwarded-Port") == "" {
+				port = defaultPort(proto)
+			}
+		}
+		if v := in.Header.Get("X-Forwarded-Host"); v != "" {
+			host = v
+		}
+		if v := in.Header.Get("X-Forwarded-Port"); v != "" {
+			port = v
+		}
+	}
+
+	for _, h := range forwardingHeaders {
+		out.Del(h)
+	}
+	out.Set("X-Forwarded-For", xff)
+	out.Set("X-Forwarded-Host", host)
+	out.Set("X-Forwarded-Port", port)
+	out.Set("X-Forwarded-Proto", proto)
+	out.Set("X-Real-Ip", realIP)
+	out.Set("Forwarded", forwarded)
+}
+
+// forwardedElement formats one RFC 7239 forwarded-element.
+func forwardedElement(clientIP, host, proto string) string {
+	node := clientIP
+	if strings.Contains(node, ":") {
+		node = `"[` + node + `]"` // IPv6 must be bracketed and quoted
+	}
+	return "for=" + node + ";host=" + quoteForwarded(host) + ";proto=" + proto
+}
+
+// quoteForwarded quotes v unless it is a valid RFC 7230 token.
+func quoteForwarded(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
+
+func defaultPort(proto string) string {
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		host       string
+		tls        bool
+		incoming   map[string]string
+		trust      bool
+		want       map[string]string
+	}{
+		{
+			name:       "plain http",
+			remoteAddr: "10.0.0.5:51234",
+			host:       "web.app.test",
+			want: map[string]string{
+				"X-Forwarded-For":   "10.0.0.5",
+				"X-Forwarded-Host":  "web.app.test",
+				"X-Forwarded-Port":  "80",
+				"X-Forwarded-Proto": "http",
+				"X-Real-Ip":         "10.0.0.5",
+				"Forwarded":         "for=10.0.0.5;host=web.app.test;proto=http",
+			},
+		},
+		{
+			name:       "tls",
+			remoteAddr: "10.0.0.5:51234",
+			host:       "web.app.test",
+			tls:        true,
+			want: map[string]string{
+				"X-Forwarded-Port":  "443",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=10.0.0.5;host=web.app.test;proto=https",
+			},
+		},
+		{
+			name:       "explicit host port",
+			remoteAddr: "10.0.0.5:51234",
+			host:       "web.app.test:8080",
+			want: map[string]string{
+				"X-Forwarded-Host": "web.app.test:8080",
+				"X-Forwarded-Port": "8080",
+				"Forwarded":        `for=10.0.0.5;host="web.app.test:8080";proto=http`,
+			},
+		},
+		{
+			name:       "ipv6 client",
+			remoteAddr: "[::1]:51234",
+			host:       "web.app.test",
+			want: map[string]string{
+				"X-Forwarded-For": "::1",
+				"Forwarded":       `for="[::1]";host=web.app.test;proto=http`,
+			},
+		},
+		{
+			name:       "untrusted client headers are replaced",
+			remoteAddr: "10.0.0.5:51234",
+			host:       "web.app.test",
+			incoming: map[string]string{
+				"X-Forwarded-For":   "1.2.3.4",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "evil.example",
+				"X-Real-Ip":         "1.2.3.4",
+				"Forwarded":         "for=1.2.3.4",
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "10.0.0.5",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "web.app.test",
+				"X-Real-Ip":         "10.0.0.5",
+				"Forwarded":         "for=10.0.0.5;host=web.app.test;proto=http",
+			},
+		},
+		{
+			name:       "trusted chain is extended",
+			remoteAddr: "10.0.0.5:51234",
+			host:       "web.app.test",
+			incoming: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7, 198.51.100.1",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "abc.ngrok.app",
+				"Forwarded":         "for=203.0.113.7",
+			},
+			trust: true,
+			want: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7, 198.51.100.1, 10.0.0.5",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "abc.ngrok.app",
+				"X-Forwarded-Port":  "443",
+				"X-Real-Ip":         "203.0.113.7",
+				"Forwarded":         "for=203.0.113.7, for=10.0.0.5;host=web.app.test;proto=http",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := httptest.NewRequest("GET", "/", nil)
+			in.RemoteAddr = tt.remoteAddr
+			in.Host = tt.host
+			if tt.tls {
+				in.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range tt.incoming {
+				in.Header.Set(k, v)
+			}
+
+			out := in.Header.Clone()
+			setForwardedHeaders(out, in, tt.trust)
+
+			for k, want := range tt.want {
+				if got := out.Values(k); len(got) != 1 || got[0] != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxySetsForwardedHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := map[string]string{}
+		for _, h := range forwardingHeaders {
+			got[h] = r.Header.Get(h)
+		}
+		_ = json.NewEncoder(w).Encode(got)
+	}))
+	defer upstream.Close()
+
+	proxyServer := serveProxy(t, &Server{}, []Route{
+		{Domain: "web.app.test", Port: parsePort(t, upstream.URL), Type: "http"},
+		{Domain: "trusted.app.test", Port: parsePort(t, upstream.URL), Type: "http", TrustForwarded: true},
+	})
+
+	fetch := func(host string) map[string]string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+		req.Host = host
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var got map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return got
+	}
+
+	got := fetch("web.app.test")
+	if got["X-Forwarded-For"] != "127.0.0.1" {
+		t.Errorf("untrusted X-Forwarded-For = %q, want %q", got["X-Forwarded-For"], "127.0.0.1")
+	}
+	if got["X-Forwarded-Proto"] != "http" {
+		t.Errorf("untrusted X-Forwarded-Proto = %q, want %q", got["X-Forwarded-Proto"], "http")
+	}
+	if got["X-Forwarded-Host"] != "web.app.test" {
+		t.Errorf("X-Forwarded-Host = %q, want %q", got["X-Forwarded-Host"], "web.app.test")
+	}
+
+	got = fetch("trusted.app.test")
+	if got["X-Forwarded-For"] != "203.0.113.7, 127.0.0.1" {
+		t.Errorf("trusted X-Forwarded-For = %q, want %q", got["X-Forwarded-For"], "203.0.113.7, 127.0.0.1")
+	}
+	if got["X-Forwarded-Proto"] != "https" {
+		t.Errorf("trusted X-Forwarded-Proto = %q, want %q", got["X-Forwarded-Proto"], "https")
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// headerExpander returns a replacer for the config.HeaderVars placeholders
+// as seen by a request on route. r is the client's request (or a clone of
+// it), so RemoteAddr and TLS describe the client connection.
+func headerExpander(r *http.Request, route Route) *strings.Replacer {
+	clientIP := r.RemoteAddr
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = ip
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.NewReplacer(
+		"{domain}", route.Domain,
+		"{client_ip}", clientIP,
+		"{scheme}", scheme,
+		"{port}", strconv.Itoa(route.Port),
+	)
+}
+
+// applyHeaderOps edits h according to ops: remove, then set, then add.
+func applyHeaderOps(h http.Header, ops *config.HeaderOps, expand *strings.Replacer) {
+	if ops == nil {
+		return
+	}
+	for _, name := range ops.Remove {
+		h.Del(name)
+	}
+	for name, value := range ops.Set {
+		h.Set(name, expand.Replace(value))
+	}
+	for name, value := range ops.Add {
+		h.Add(name, expand.Replace(value))
+	}
+}
+
+// applyRequestHeaders applies the route's request rules to h, the headers
+// about to be sent upstream for client request r.
+func applyRequestHeaders(h http.Header, r *http.Request, route Route) {
+	if route.Headers == nil || route.Headers.Request == nil {
+		return
+	}
+	applyHeaderOps(h, route.Headers.Request, headerExpander(r, route))
+}
+
+// applyResponseHeaders applies the route's response rules to h, the headers
+// about to be sent to the client for request r.
+func applyResponseHeaders(h http.Header, r *http.Request, route Route) {
+	if route.Headers == nil || route.Headers.Response == nil {
+		return
+	}
+	applyHeaderOps(h, route.Headers.Response, headerExpander(r, route))
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+func TestProxyAppliesHeaderRules(t *testing.T) {
+	var seen http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	port := parsePort(t, upstream.URL)
+
+	proxyServer := serveProxy(t, &Server{}, []Route{{
+		Domain: "web.app.test", Port: port, Type: "http",
+		Headers: &config.HeaderRules{
+			Request: &config.HeaderOps{
+				Remove: []string{"X-Debug"},
+				Set:    map[string]string{"Authorization": "Bearer dev", "X-Client": "{client_ip} via {domain}"},
+				Add:    map[string]string{"X-Flags": "beta"},
+			},
+			Response: &config.HeaderOps{
+				Remove: []string{"X-Frame-Options"},
+				Set:    map[string]string{"X-Upstream": "{scheme}://localhost:{port}"},
+				Add:    map[string]string{"Cache-Control": "private"},
+			},
+		},
+	}})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "web.app.test"
+	req.Header.Set("Authorization", "Bearer prod")
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Flags", "dark-mode")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if got := seen.Get("Authorization"); got != "Bearer dev" {
+		t.Errorf("upstream Authorization = %q, want %q", got, "Bearer dev")
+	}
+	if got := seen.Get("X-Debug"); got != "" {
+		t.Errorf("upstream X-Debug = %q, want it removed", got)
+	}
+	if got := seen.Get("X-Client"); got != "127.0.0.1 via web.app.test" {
+		t.Errorf("upstream X-Client = %q, want %q", got, "127.0.0.1 via web.app.test")
+	}
+	if got := seen.Values("X-Flags"); len(got) != 2 || got[0] != "dark-mode" || got[1] != "beta" {
+		t.Errorf("upstream X-Flags = %q, want [dark-mode beta]", got)
+	}
+
+	if got := resp.Header.Get("X-Frame-Options"); got != "" {
+		t.Errorf("X-Frame-Options = %q, want it removed", got)
+	}
+	if want := fmt.Sprintf("http://localhost:%d", port); resp.Header.Get("X-Upstream") != want {
+		t.Errorf("X-Upstream = %q, want %q", resp.Header.Get("X-Upstream"), want)
+	}
+	if got := resp.Header.Values("Cache-Control"); len(got) != 2 {
+		t.Errorf("Cache-Control = %q, want upstream value plus added one", got)
+	}
+}
+
+func TestWebSocketAppliesHeaderRules(t *testing.T) {
+	seen := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer upstream.Close()
+
+	proxyServer := serveProxy(t, &Server{}, []Route{{
+		Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http",
+		Headers: &config.HeaderRules{
+			Request:  &config.HeaderOps{Set: map[string]string{"Authorization": "Bearer dev"}},
+			Response: &config.HeaderOps{Set: map[string]string{"X-Route": "{domain}"}},
+		},
+	}})
+
+	dialer := websocket.Dialer{
+		NetDial: func(network, _ string) (net.Conn, error) {
+			return net.Dial(network, strings.TrimPrefix(proxyServer.URL, "http://"))
+		},
+	}
+	conn, resp, err := dialer.Dial("ws://app.test/socket", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+
+	if got := (<-seen).Get("Authorization"); got != "Bearer dev" {
+		t.Errorf("upstream Authorization = %q, want %q", got, "Bearer dev")
+	}
+	if got := resp.Header.Get("X-Route"); got != "app.test" {
+		t.Errorf("handshake X-Route = %q, want %q", got, "app.test")
+	}
+}
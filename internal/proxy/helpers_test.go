@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newUpstream starts a service for a route to point at. It is closed when
+// the test ends.
+func newUpstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// proxyTo starts a proxy with route as its only route, pointing at upstream.
+func proxyTo(t *testing.T, upstream *httptest.Server, route Route) *httptest.Server {
+	t.Helper()
+	route.Port = parsePort(t, upstream.URL)
+	route.Type = "http"
+	return serveProxy(t, &Server{}, []Route{route})
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// --- helpers ---
+
+// h2cServer starts an upstream that only speaks cleartext HTTP/2 with prior
+// knowledge, the way a plaintext gRPC server does.
+func h2cServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	upstream := httptest.NewUnstartedServer(handler)
+	upstream.Config.Protocols = &protocols
+	upstream.Start()
+	return upstream
+}
+
+// h2cClient returns a client that talks cleartext HTTP/2 to the proxy.
+func h2cClient() *http.Client {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+}
+
+// --- HTTPS listener ---
+
+func TestHTTPSNegotiatesHTTP2(t *testing.T) {
+	srv := &Server{certsDir: t.TempDir()}
+	tlsConfig, err := srv.buildTLSConfig()
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	httpsServer := newHTTPSServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.Proto)
+	}), tlsConfig)
+	go func() { _ = httpsServer.Serve(ln) }()
+	defer func() { _ = httpsServer.Close() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("client negotiated %s, want HTTP/2", resp.Proto)
+	}
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("handler saw %q, want HTTP/2.0", body)
+	}
+}
+
+func TestHTTPSStillServesHTTP1(t *testing.T) {
+	srv := &Server{certsDir: t.TempDir()}
+	tlsConfig, err := srv.buildTLSConfig()
+	if err != nil {
+		t.Fatalf("buildTLSConfig: %v", err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	httpsServer := newHTTPSServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), tlsConfig)
+	go func() { _ = httpsServer.Serve(ln) }()
+	defer func() { _ = httpsServer.Close() }()
+
+	// An HTTP/1.1-only client (e.g. a WebSocket handshake) must not be
+	// forced onto h2.
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}},
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.ProtoMajor != 1 {
+		t.Errorf("client negotiated %s, want HTTP/1.1", resp.Proto)
+	}
+}
+
+// --- h2c upstreams ---
+
+func TestH2CUpstreamForwardsTrailers(t *testing.T) {
+	upstream := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "want HTTP/2, got "+r.Proto, http.StatusHTTPVersionNotSupported)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		_, _ = fmt.Fprint(w, "payload")
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+	})
+	defer upstream.Close()
+
+	proxyServer := serveProxy(t, &Server{}, []Route{{Domain: "grpc.app.test", Port: parsePort(t, upstream.URL), Type: "http", Protocol: "h2c"}})
+
+	req, _ := http.NewRequest("POST", proxyServer.URL+"/pkg.Service/Method", nil)
+	req.Host = "grpc.app.test"
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", resp.StatusCode, body)
+	}
+	if string(body) != "payload" {
+		t.Errorf("body = %q, want %q", body, "payload")
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want %q", got, "0")
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "ok" {
+		t.Errorf("Grpc-Message trailer = %q, want %q", got, "ok")
+	}
+}
+
+func TestH2CUpstreamUnreachableOverHTTP1(t *testing.T) {
+	// A plain HTTP/1.1 upstream registered as h2c must fail loudly rather
+	// than silently downgrading.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	proxyServer := serveProxy(t, &Server{}, []Route{{Domain: "grpc.app.test", Port: parsePort(t, upstream.URL), Type: "http", Protocol: "h2c"}})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "grpc.app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestH2CBidirectionalStreaming(t *testing.T) {
+	// The upstream echoes each request line as soon as it arrives. The client
+	// only sends the next line after reading the previous echo, so this
+	// deadlocks unless the proxy streams both directions without buffering.
+	upstream := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		flusher := w.(http.Flusher)
+		flusher.Flush()
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			_, _ = fmt.Fprintf(w, "echo:%s\n", scanner.Text())
+			flusher.Flush()
+		}
+	})
+	defer upstream.Close()
+
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "grpc.app.test", Port: parsePort(t, upstream.URL), Type: "http", Protocol: "h2c"}})
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	proxyServer := httptest.NewUnstartedServer(http.HandlerFunc(srv.handleHTTP))
+	proxyServer.Config.Protocols = &protocols
+	proxyServer.Start()
+	defer proxyServer.Close()
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest("POST", proxyServer.URL+"/stream", pr)
+	req.Host = "grpc.app.test"
+
+	respCh := make(chan *http.Response, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		resp, err := h2cClient().Do(req)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		respCh <- resp
+	}()
+
+	var resp *http.Response
+	select {
+	case resp = <-respCh:
+	case err := <-errCh:
+		t.Fatalf("request: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for response headers")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("proxy answered with %s, want HTTP/2", resp.Proto)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for i := range 3 {
+		if _, err := fmt.Fprintf(pw, "msg-%d\n", i); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		lineCh := make(chan string, 1)
+		go func() {
+			line, _ := reader.ReadString('\n')
+			lineCh <- line
+		}()
+		select {
+		case line := <-lineCh:
+			if want := fmt.Sprintf("echo:msg-%d\n", i); line != want {
+				t.Fatalf("line = %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d was not streamed back", i)
+		}
+	}
+	_ = pw.Close()
+}
+
+func TestProxyAcceptsPriorKnowledgeH2C(t *testing.T) {
+	upstream := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, r.Proto)
+	})
+	defer upstream.Close()
+
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "grpc.app.test", Port: parsePort(t, upstream.URL), Type: "http", Protocol: "h2c"}})
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	proxyServer := httptest.NewUnstartedServer(http.HandlerFunc(srv.handleHTTP))
+	proxyServer.Config.Protocols = &protocols
+	proxyServer.Start()
+	defer proxyServer.Close()
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "grpc.app.test"
+	resp, err := h2cClient().Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("client leg = %s, want HTTP/2", resp.Proto)
+	}
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("upstream leg = %q, want HTTP/2.0", body)
+	}
+}
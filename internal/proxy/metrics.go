@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the histogram upper bounds in seconds (the Prometheus
+// client defaults).
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Traffic directions used in byte counters, as in shaping: "up" is client →
+// service, "down" is service → client.
+const (
+	dirUp   = "up"
+	dirDown = "down"
+)
+
+// histogram is a cumulative Prometheus-style histogram.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets)+1)
+	}
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+type requestKey struct {
+	route, method, code string
+}
+
+type bytesKey struct {
+	route, direction string
+}
+
+// metrics collects per-route traffic counters for the Prometheus endpoint.
+// All methods are safe on a nil receiver, which records nothing.
+type metrics struct {
+	started time.Time
+
+	mu             sync.Mutex
+	requests       map[requestKey]uint64
+	durations      map[string]*histogram
+	upstream       map[string]*histogram
+	httpBytes      map[bytesKey]uint64
+	wsActive       map[string]int64
+	wsBytes        map[bytesKey]uint64
+	tcpActive      map[string]int64
+	tcpConnections map[string]uint64
+	tcpBytes       map[bytesKey]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:       make(map[requestKey]uint64),
+		durations:      make(map[string]*histogram),
+		upstream:       make(map[string]*histogram),
+		httpBytes:      make(map[bytesKey]uint64),
+		wsActive:       make(map[string]int64),
+		wsBytes:        make(map[bytesKey]uint64),
+		tcpActive:      make(map[string]int64),
+		tcpConnections: make(map[string]uint64),
+		tcpBytes:       make(map[bytesKey]uint64),
+		started:        time.Now(),
+	}
+}
+
+// knownMethods bounds the method label; anything else is counted as "other".
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+// observeRequest records a finished HTTP request. upstream is zero when the
+// request never got a response from the service.
+func (m *metrics) observeRequest(route, method string, status int, reqBytes, respBytes int64, duration, upstream time.Duration) {
+	if m == nil {
+		return
+	}
+	if !knownMethods[method] {
+		method = "other"
+	}
+	code := "none"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{route, method, code}]++
+	observe(m.durations, route, duration)
+	if upstream > 0 {
+		observe(m.upstream, route, upstream)
+	}
+	m.httpBytes[bytesKey{route, dirUp}] += uint64(reqBytes)
+	m.httpBytes[bytesKey{route, dirDown}] += uint64(respBytes)
+}
+
+func observe(hs map[string]*histogram, route string, d time.Duration) {
+	h := hs[route]
+	if h == nil {
+		h = &histogram{}
+		hs[route] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// wsOpened records a new WebSocket connection and returns the function that
+// records its end.
+func (m *metrics) wsOpened(route string) func() {
+	if m == nil {
+		return func() {}
+	}
+	return m.track(m.wsActive, route, nil)
+}
+
+// tcpOpened records a new TCP connection and returns the function that
+// records its end.
+func (m *metrics) tcpOpened(route string) func() {
+	if m == nil {
+		return func() {}
+	}
+	return m.track(m.tcpActive, route, m.tcpConnections)
+}
+
+func (m *metrics) track(active map[string]int64, route string, total map[string]uint64) func() {
+	m.mu.Lock()
+	active[route]++
+	if total != nil {
+		total[route]++
+	}
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		active[route]--
+		m.mu.Unlock()
+	}
+}
+
+// addWSBytes counts WebSocket payload bytes in one direction.
+func (m *metrics) addWSBytes(route, direction string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.wsBytes[bytesKey{route, direction}] += uint64(n)
+	m.mu.Unlock()
+}
+
+// addTCPBytes counts TCP stream bytes in one direction.
+func (m *metrics) addTCPBytes(route, direction string, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.tcpBytes[bytesKey{route, direction}] += uint64(n)
+	m.mu.Unlock()
+}
+
+// countingWriter reports every successful write to add, so long-lived
+// streams show up in the byte counters while they are still open.
+type countingWriter struct {
+	w   io.Writer
+	add func(int)
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.add(n)
+	return n, err
+}
+
+// countingBody counts request body bytes as the upstream reads them.
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = io.WriteString(w, m.render())
+}
+
+func (m *metrics) render() string {
+	if m == nil {
+		return ""
+	}
+	var b strings.Builder
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(&b, "roxy_http_requests_total", "counter", "HTTP requests handled, by route, method and status code.")
+	for _, k := range sortedKeys(m.requests, func(a, b requestKey) bool {
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	}) {
+		fmt.Fprintf(&b, "roxy_http_requests_total{route=%s,method=%s,code=%s} %d\n",
+			labelValue(k.route), labelValue(k.method), labelValue(k.code), m.requests[k])
+	}
+
+	writeHistograms(&b, "roxy_http_request_duration_seconds",
+		"Time from receiving a request to finishing its response.", m.durations)
+	writeHistograms(&b, "roxy_http_upstream_duration_seconds",
+		"Time from receiving a request to the service's response headers.", m.upstream)
+
+	writeBytes(&b, "roxy_http_bytes_total", "HTTP body bytes, by route and direction (up: request, down: response).", m.httpBytes)
+
+	writeGauges(&b, "roxy_websocket_connections", "Open WebSocket connections.", m.wsActive)
+	writeBytes(&b, "roxy_websocket_bytes_total", "WebSocket message payload bytes, by route and direction.", m.wsBytes)
+
+	writeGauges(&b, "roxy_tcp_connections", "Open TCP connections.", m.tcpActive)
+	header(&b, "roxy_tcp_connections_total", "counter", "TCP connections accepted.")
+	for _, route := range sortedKeys(m.tcpConnections, func(a, b string) bool { return a < b }) {
+		fmt.Fprintf(&b, "roxy_tcp_connections_total{route=%s} %d\n", labelValue(route), m.tcpConnections[route])
+	}
+	writeBytes(&b, "roxy_tcp_bytes_total", "TCP stream bytes, by route and direction.", m.tcpBytes)
+
+	header(&b, "roxy_start_time_seconds", "gauge", "Unix time the proxy started.")
+	fmt.Fprintf(&b, "roxy_start_time_seconds %d\n", m.started.Unix())
+	return b.String()
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeHistograms(b *strings.Builder, name, help string, hs map[string]*histogram) {
+	header(b, name, "histogram", help)
+	for _, route := range sortedKeys(hs, func(a, b string) bool { return a < b }) {
+		h := hs[route]
+		label := labelValue(route)
+		var cum uint64
+		for i, le := range latencyBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(b, "%s_bucket{route=%s,le=\"%s\"} %d\n", name, label, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(b, "%s_bucket{route=%s,le=\"+Inf\"} %d\n", name, label, h.count)
+		fmt.Fprintf(b, "%s_sum{route=%s} %s\n", name, label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "%s_count{route=%s} %d\n", name, label, h.count)
+	}
+}
+
+func writeBytes(b *strings.Builder, name, help string, counters map[bytesKey]uint64) {
+	header(b, name, "counter", help)
+	for _, k := range sortedKeys(counters, func(a, b bytesKey) bool {
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.direction < b.direction
+	}) {
+		fmt.Fprintf(b, "%s{route=%s,direction=%s} %d\n", name, labelValue(k.route), labelValue(k.direction), counters[k])
+	}
+}
+
+func writeGauges(b *strings.Builder, name, help string, gauges map[string]int64) {
+	header(b, name, "gauge", help)
+	for _, route := range sortedKeys(gauges, func(a, b string) bool { return a < b }) {
+		fmt.Fprintf(b, "%s{route=%s} %d\n", name, labelValue(route), gauges[route])
+	}
+}
+
+func sortedKeys[K comparable, V any](m map[K]V, less func(a, b K) bool) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+	return keys
+}
+
+// labelValue quotes a label value for the text exposition format.
+func labelValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, proxyURL string) string {
+	t.Helper()
+	req, _ := http.NewRequest("GET", proxyURL+"/metrics", nil)
+	req.Host = reservedHost
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// waitForMetric polls until the exposition contains line; requests are
+// recorded after their response is sent.
+func waitForMetric(t *testing.T, proxyURL, line string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		out := scrape(t, proxyURL)
+		if strings.Contains(out, line+"\n") {
+			return out
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics missing %q:\n%s", line, out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsHTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/missing" {
+			http.Error(w, "nope", http.StatusNotFound) // 5 bytes with newline
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	srv := &Server{
+		metrics: newMetrics(),
+	}
+	proxyServer := serveProxy(t, srv, []Route{{Domain: "api.app.test", Port: parsePort(t, upstream.URL), Type: "http"}})
+
+	for _, r := range []struct{ method, path, body string }{
+		{"POST", "/echo", "hello"},
+		{"POST", "/echo", "world!"},
+		{"GET", "/missing", ""},
+	} {
+		req, _ := http.NewRequest(r.method, proxyServer.URL+r.path, strings.NewReader(r.body))
+		req.Host = "api.app.test"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", r.method, r.path, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	out := waitForMetric(t, proxyServer.URL, `roxy_http_requests_total{route="api.app.test",method="GET",code="404"} 1`)
+	for _, want := range []string{
+		`roxy_http_requests_total{route="api.app.test",method="POST",code="200"} 2`,
+		`roxy_http_request_duration_seconds_count{route="api.app.test"} 3`,
+		`roxy_http_request_duration_seconds_bucket{route="api.app.test",le="+Inf"} 3`,
+		`roxy_http_upstream_duration_seconds_count{route="api.app.test"} 3`,
+		`roxy_http_bytes_total{route="api.app.test",direction="up"} 11`,
+		`roxy_http_bytes_total{route="api.app.test",direction="down"} 16`,
+		"# TYPE roxy_websocket_connections gauge",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsReservedHost(t *testing.T) {
+	srv := &Server{metrics: newMetrics()}
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+	defer proxyServer.Close()
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/other", nil)
+	req.Host = "ROXY.test:80"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown reserved path: status = %d, want 404", resp.StatusCode)
+	}
+	if out := scrape(t, proxyServer.URL); !strings.Contains(out, "roxy_start_time_seconds ") {
+		t.Errorf("metrics missing start time:\n%s", out)
+	}
+}
+
+func TestMetricsTCP(t *testing.T) {
+	upstreamPort := freePort(t)
+	listenPort := freePort(t)
+	_, stopEcho := tcpEchoServer(t, upstreamPort)
+	defer stopEcho()
+
+	srv := &Server{
+		tcpListeners: make(map[string]net.Listener),
+		metrics:      newMetrics(),
+	}
+	srv.setRoutes([]Route{{Domain: "db.app.test", Port: upstreamPort, ListenPort: listenPort, Type: "tcp"}})
+	srv.startTCPListeners()
+	defer func() {
+		srv.mu.Lock()
+		for _, ln := range srv.tcpListeners {
+			_ = ln.Close()
+		}
+		srv.mu.Unlock()
+	}()
+
+	conn := dialProxy(t, listenPort)
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// The proxy counts a write after it completes, so the echo may arrive
+	// first.
+	waitFor(t, srv.metrics, `roxy_tcp_bytes_total{route="db.app.test",direction="down"} 4`)
+	out := srv.metrics.render()
+	for _, want := range []string{
+		`roxy_tcp_connections{route="db.app.test"} 1`,
+		`roxy_tcp_connections_total{route="db.app.test"} 1`,
+		`roxy_tcp_bytes_total{route="db.app.test",direction="down"} 4`,
+		`roxy_tcp_bytes_total{route="db.app.test",direction="up"} 4`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+
+	_ = conn.Close()
+	waitFor(t, srv.metrics, `roxy_tcp_connections{route="db.app.test"} 0`)
+}
+
+func waitFor(t *testing.T, m *metrics, line string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(m.render(), line+"\n") {
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics missing %q:\n%s", line, m.render())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLabelValueEscaping(t *testing.T) {
+	if got, want := labelValue("a\"b\\c\nd"), `"a\"b\\c\nd"`; got != want {
+		t.Errorf("labelValue = %s, want %s", got, want)
+	}
+}
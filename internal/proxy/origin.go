@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// upstreamHost returns the Host header the upstream should see for a
+// request that arrived with host. Routes with ChangeOrigin (or
+// RewriteOrigin, which implies it) get "localhost:<port>" so dev servers
+// that check Host (Vite, webpack-dev-server, Django's ALLOWED_HOSTS)
+// accept the request; otherwise the original Host is preserved.
+func (e *routeEntry) upstreamHost(host string) string {
+	if e.route.ChangeOrigin || e.route.RewriteOrigin {
+		return fmt.Sprintf("localhost:%d", e.route.Port)
+	}
+	return host
+}
+
+// rewriteOriginHeaders points Origin and Referer at the upstream when they
+// refer to the route's own domain. Cross-origin values are left alone so
+// the upstream's CORS and CSRF checks still see them.
+func (e *routeEntry) rewriteOriginHeaders(h http.Header) {
+	if !e.route.RewriteOrigin {
+		return
+	}
+	origin := "http://" + e.upstreamHost("")
+
+	if v := h.Get("Origin"); v != "" {
+		if u, err := url.Parse(v); err == nil && e.isRouteHost(u.Host) {
+			h.Set("Origin", origin)
+		}
+	}
+	if v := h.Get("Referer"); v != "" {
+		if u, err := url.Parse(v); err == nil && e.isRouteHost(u.Host) {
+			u.Scheme = "http"
+			u.Host = e.upstreamHost("")
+			h.Set("Referer", u.String())
+		}
+	}
+}
+
+// isRouteHost reports whether hostport names this route's domain.
+func (e *routeEntry) isRouteHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	return host != "" && strings.EqualFold(host, e.route.Domain)
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// headerEcho records the Host, Origin and Referer seen by the upstream.
+type headerEcho struct {
+	host, origin, referer string
+}
+
+func echoUpstream(t *testing.T, seen chan<- headerEcho) *httptest.Server {
+	t.Helper()
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- headerEcho{r.Host, r.Header.Get("Origin"), r.Header.Get("Referer")}
+		if websocket.IsWebSocketUpgrade(r) {
+			if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+				_ = conn.Close()
+			}
+		}
+	}))
+}
+
+func TestProxyHostRewriting(t *testing.T) {
+	seen := make(chan headerEcho, 1)
+	upstream := echoUpstream(t, seen)
+	defer upstream.Close()
+	port := parsePort(t, upstream.URL)
+	localhost := fmt.Sprintf("localhost:%d", port)
+
+	tests := []struct {
+		name        string
+		route       Route
+		origin      string
+		referer     string
+		wantHost    string
+		wantOrigin  string
+		wantReferer string
+	}{
+		{
+			name:        "preserve by default",
+			route:       Route{Domain: "web.app.test", Port: port, Type: "http"},
+			origin:      "https://web.app.test",
+			referer:     "https://web.app.test/login?next=/",
+			wantHost:    "web.app.test",
+			wantOrigin:  "https://web.app.test",
+			wantReferer: "https://web.app.test/login?next=/",
+		},
+		{
+			name:        "change origin only",
+			route:       Route{Domain: "web.app.test", Port: port, Type: "http", ChangeOrigin: true},
+			origin:      "https://web.app.test",
+			referer:     "https://web.app.test/login",
+			wantHost:    localhost,
+			wantOrigin:  "https://web.app.test",
+			wantReferer: "https://web.app.test/login",
+		},
+		{
+			name:        "rewrite origin and referer",
+			route:       Route{Domain: "web.app.test", Port: port, Type: "http", RewriteOrigin: true},
+			origin:      "https://web.app.test",
+			referer:     "https://web.app.test/login?next=/",
+			wantHost:    localhost,
+			wantOrigin:  "http://" + localhost,
+			wantReferer: "http://" + localhost + "/login?next=/",
+		},
+		{
+			name:        "cross-origin values untouched",
+			route:       Route{Domain: "web.app.test", Port: port, Type: "http", RewriteOrigin: true},
+			origin:      "https://evil.example",
+			referer:     "https://docs.example/page",
+			wantHost:    localhost,
+			wantOrigin:  "https://evil.example",
+			wantReferer: "https://docs.example/page",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyServer := serveProxy(t, &Server{}, []Route{tt.route})
+
+			req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+			req.Host = "web.app.test"
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Referer", tt.referer)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			_ = resp.Body.Close()
+
+			got := <-seen
+			if got.host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", got.host, tt.wantHost)
+			}
+			if got.origin != tt.wantOrigin {
+				t.Errorf("Origin = %q, want %q", got.origin, tt.wantOrigin)
+			}
+			if got.referer != tt.wantReferer {
+				t.Errorf("Referer = %q, want %q", got.referer, tt.wantReferer)
+			}
+		})
+	}
+}
+
+func TestWebSocketHostRewriting(t *testing.T) {
+	seen := make(chan headerEcho, 1)
+	upstream := echoUpstream(t, seen)
+	defer upstream.Close()
+	port := parsePort(t, upstream.URL)
+
+	proxyServer := serveProxy(t, &Server{}, []Route{{Domain: "app.test", Port: port, Type: "http", RewriteOrigin: true}})
+
+	dialer := websocket.Dialer{
+		NetDial: func(network, _ string) (net.Conn, error) {
+			return net.Dial(network, strings.TrimPrefix(proxyServer.URL, "http://"))
+		},
+	}
+	conn, _, err := dialer.Dial("ws://app.test/hmr", http.Header{"Origin": {"http://app.test"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+
+	got := <-seen
+	want := fmt.Sprintf("localhost:%d", port)
+	if got.host != want {
+		t.Errorf("Host = %q, want %q", got.host, want)
+	}
+	if got.origin != "http://"+want {
+		t.Errorf("Origin = %q, want %q", got.origin, "http://"+want)
+	}
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+const (
+	// upstreamKeepAlive is the TCP keep-alive period for upstream connections.
+	upstreamKeepAlive = 30 * time.Second
+	// upstreamMaxIdleConns is the keep-alive pool size per upstream.
+	upstreamMaxIdleConns = 64
+	// upstreamIdleConnTimeout is how long an unused pooled connection is kept.
+	upstreamIdleConnTimeout = 90 * time.Second
+	// upstreamExpectContinueTimeout bounds the wait for "100 Continue".
+	upstreamExpectContinueTimeout = time.Second
+)
+
+// routeTable indexes HTTP routes by host. It is rebuilt whenever the
+// route set changes; transports are carried over for upstreams that are
+// still present so their keep-alive pools survive the rebuild.
+type routeTable struct {
+	hosts      map[string]*routeEntry     // lowercase domain -> entry
+	transports map[string]*http.Transport // upstreamKey -> transport
+}
+
+// routeEntry is a resolved HTTP route with the reverse proxy serving it.
+type routeEntry struct {
+	route    Route
+	upstream string               // host:port of the service
+	shaping  config.ShapingConfig // resolved route.Shaping
+	proxy    *httputil.ReverseProxy
+}
+
+// requestState carries per-request values from handleHTTP to the shared
+// reverse proxy callbacks.
+type requestState struct {
+	host     string
+	origin   string // client's Origin header, before rewriting
+	guard    *streamGuard
+	start    time.Time     // when the proxy received the request
+	upstream time.Duration // time until the upstream's response headers
+	reqBytes atomic.Int64  // request body bytes read so far
+
+	// unreachable renders the HTML 502 page for local browsers; nil falls
+	// back to plain text.
+	unreachable func(w http.ResponseWriter, err error)
+}
+
+type requestStateKey struct{}
+
+// stateFrom returns the requestState stored on r's context.
+func stateFrom(r *http.Request) *requestState {
+	if st, ok := r.Context().Value(requestStateKey{}).(*requestState); ok {
+		return st
+	}
+	return &requestState{host: r.Host, origin: r.Header.Get("Origin")}
+}
+
+// newRouteTable builds a table for routes, reusing transports from prev.
+// Transports of upstreams that disappeared have their idle connections closed.
+func newRouteTable(routes []Route, prev *routeTable) *routeTable {
+	t := &routeTable{
+		hosts:      make(map[string]*routeEntry, len(routes)),
+		transports: make(map[string]*http.Transport),
+	}
+
+	for _, route := range routes {
+		if route.Type == "tcp" {
+			continue
+		}
+		host := strings.ToLower(route.Domain)
+		if _, dup := t.hosts[host]; dup {
+			continue // first route wins, matching the order in routes.json
+		}
+
+		key := upstreamKey(route)
+		transport, ok := t.transports[key]
+		if !ok && prev != nil {
+			transport, ok = prev.transports[key]
+		}
+		if !ok {
+			transport = newUpstreamTransport(route.Protocol)
+		}
+		t.transports[key] = transport
+
+		t.hosts[host] = newRouteEntry(route, transport)
+	}
+
+	if prev != nil {
+		for key, transport := range prev.transports {
+			if _, ok := t.transports[key]; !ok {
+				transport.CloseIdleConnections()
+			}
+		}
+	}
+	return t
+}
+
+// lookup returns the entry for host (case-insensitive), or nil.
+func (t *routeTable) lookup(host string) *routeEntry {
+	if e, ok := t.hosts[host]; ok {
+		return e
+	}
+	return t.hosts[strings.ToLower(host)]
+}
+
+// upstreamKey identifies an upstream connection pool.
+func upstreamKey(route Route) string {
+	return fmt.Sprintf("%s|%d", route.Protocol, route.Port)
+}
+
+// newUpstreamTransport returns a transport tuned for a local dev server:
+// a bounded dial timeout, a keep-alive pool sized for bursts of parallel
+// asset requests, and no transparent compression so encodings pass through
+// untouched. Routes with protocol "h2c" get cleartext HTTP/2 with prior
+// knowledge (gRPC servers and the like).
+func newUpstreamTransport(protocol string) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   tcpDialTimeout,
+		KeepAlive: upstreamKeepAlive,
+	}
+	t := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          upstreamMaxIdleConns,
+		MaxIdleConnsPerHost:   upstreamMaxIdleConns,
+		IdleConnTimeout:       upstreamIdleConnTimeout,
+		ExpectContinueTimeout: upstreamExpectContinueTimeout,
+		DisableCompression:    true,
+	}
+	if protocol == "h2c" {
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+		t.Protocols = &protocols
+	}
+	return t
+}
+
+// newRouteEntry builds the reverse proxy for a single route. Per-request
+// values (the client-facing host and stream guard) come from requestState.
+// The upstream sees the original Host header unless the route rewrites it.
+func newRouteEntry(route Route, transport http.RoundTripper) *routeEntry {
+	e := &routeEntry{
+		route:    route,
+		upstream: fmt.Sprintf("127.0.0.1:%d", route.Port),
+		shaping:  route.Shaping.Resolve(),
+	}
+
+	e.proxy = &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = e.upstream
+			pr.Out.Host = e.upstreamHost(pr.In.Host)
+			e.rewriteOriginHeaders(pr.Out.Header)
+			setForwardedHeaders(pr.Out.Header, pr.In, route.TrustForwarded)
+			applyRequestHeaders(pr.Out.Header, pr.In, route)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			st := stateFrom(resp.Request)
+			if !st.start.IsZero() {
+				st.upstream = time.Since(st.start)
+			}
+			applyCORS(resp.Header, st.origin, route.CORS)
+			applyResponseHeaders(resp.Header, resp.Request, route)
+			if st.guard != nil {
+				st.guard.gotResponse(resp)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			st := stateFrom(r)
+			// Keep CORS headers on errors so the browser reports the
+			// real status instead of a CORS failure.
+			applyCORS(w.Header(), st.origin, route.CORS)
+			if st.guard != nil && st.guard.timedOut.Load() {
+				log.Printf("proxy timeout [%s → %s]: %v", st.host, e.upstream, err)
+				w.Header().Set(ErrorHeader, "timeout")
+				http.Error(w, "roxy: upstream timed out", http.StatusGatewayTimeout)
+				return
+			}
+			log.Printf("proxy error [%s → %s]: %v", st.host, e.upstream, err)
+			w.Header().Set(ErrorHeader, "unreachable")
+			// The page shows the service's command, directory and
+			// output, so other machines get the plain error.
+			if st.unreachable != nil && wantsHTML(r) && fromLocal(r) {
+				st.unreachable(w, err)
+				return
+			}
+			http.Error(w, fmt.Sprintf("roxy: upstream unreachable (%v)", err), http.StatusBadGateway)
+		},
+	}
+
+	// gRPC and other h2c streams are full duplex; flush every write
+	// instead of waiting for the default buffering heuristics.
+	if route.Protocol == "h2c" {
+		e.proxy.FlushInterval = -1
+	}
+	applyStreaming(e.proxy, route.Streaming)
+
+	return e
+}
+
+// withRequestState attaches per-request values for the route's proxy.
+func withRequestState(r *http.Request, st *requestState) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestStateKey{}, st))
+}
+
+// setRoutes swaps in a new route set and rebuilds the HTTP route table.
+func (s *Server) setRoutes(routes []Route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes = routes
+	s.table = newRouteTable(routes, s.table)
+}
+
+// lookupHTTP returns the HTTP route entry for host, or nil.
+func (s *Server) lookupHTTP(host string) *routeEntry {
+	s.mu.RLock()
+	table := s.table
+	s.mu.RUnlock()
+
+	if table == nil {
+		return nil // no routes set yet
+	}
+	return table.lookup(host)
+}
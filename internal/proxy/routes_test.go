@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// --- helpers ---
+
+// manyRoutes returns n HTTP routes named svc-<i>.app.test on distinct ports.
+func manyRoutes(n, basePort int) []Route {
+	routes := make([]Route, 0, n)
+	for i := range n {
+		routes = append(routes, Route{
+			Domain: fmt.Sprintf("svc-%d.app.test", i),
+			Port:   basePort + i,
+			Type:   "http",
+		})
+	}
+	return routes
+}
+
+// serveProxy sets srv's routes and serves its HTTP handler. The server is
+// closed when the test ends.
+func serveProxy(t *testing.T, srv *Server, routes []Route) *httptest.Server {
+	t.Helper()
+	srv.setRoutes(routes)
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+	t.Cleanup(proxyServer.Close)
+	return proxyServer
+}
+
+// --- Route table ---
+
+func TestRouteTableLookup(t *testing.T) {
+	table := newRouteTable([]Route{
+		{Domain: "web.app.test", Port: 3000, Type: "http"},
+		{Domain: "db.app.test", Port: 5432, ListenPort: 5432, Type: "tcp"},
+		{Domain: "web.app.test", Port: 3001, Type: "http"}, // duplicate, ignored
+	}, nil)
+
+	if e := table.lookup("web.app.test"); e == nil || e.route.Port != 3000 {
+		t.Errorf("lookup(web.app.test) = %+v, want port 3000", e)
+	}
+	if e := table.lookup("WEB.App.Test"); e == nil || e.route.Port != 3000 {
+		t.Errorf("lookup is not case-insensitive: %+v", e)
+	}
+	if e := table.lookup("db.app.test"); e != nil {
+		t.Errorf("lookup(db.app.test) = %+v, want nil for tcp route", e)
+	}
+	if e := table.lookup("missing.app.test"); e != nil {
+		t.Errorf("lookup(missing.app.test) = %+v, want nil", e)
+	}
+}
+
+func TestRouteTableReusesTransports(t *testing.T) {
+	first := newRouteTable([]Route{
+		{Domain: "web.app.test", Port: 3000, Type: "http"},
+		{Domain: "api.app.test", Port: 4000, Type: "http"},
+	}, nil)
+
+	second := newRouteTable([]Route{
+		{Domain: "web.app.test", Port: 3000, Type: "http"},
+		{Domain: "grpc.app.test", Port: 4000, Type: "http", Protocol: "h2c"},
+	}, first)
+
+	if first.transports["|3000"] != second.transports["|3000"] {
+		t.Error("transport for unchanged upstream was rebuilt")
+	}
+	if _, ok := second.transports["|4000"]; ok {
+		t.Error("transport for removed upstream was carried over")
+	}
+	h2c, ok := second.transports["h2c|4000"]
+	if !ok {
+		t.Fatal("no transport for new h2c upstream")
+	}
+	if h2c.Protocols == nil || !h2c.Protocols.UnencryptedHTTP2() {
+		t.Error("h2c upstream transport does not speak cleartext HTTP/2")
+	}
+}
+
+func TestSetRoutesRebuildsTable(t *testing.T) {
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "web.app.test", Port: 3000, Type: "http"}})
+
+	if srv.lookupHTTP("api.app.test") != nil {
+		t.Fatal("api.app.test resolved before it was added")
+	}
+
+	srv.setRoutes([]Route{
+		{Domain: "web.app.test", Port: 3000, Type: "http"},
+		{Domain: "api.app.test", Port: 4000, Type: "http"},
+	})
+
+	if e := srv.lookupHTTP("api.app.test"); e == nil || e.route.Port != 4000 {
+		t.Errorf("lookupHTTP(api.app.test) = %+v after rebuild, want port 4000", e)
+	}
+}
+
+// --- Connection pooling ---
+
+func TestUpstreamConnectionsAreReused(t *testing.T) {
+	var newConns atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}})
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+	defer proxyServer.Close()
+
+	for i := range 20 {
+		req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+		req.Host = "app.test"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	if n := newConns.Load(); n != 1 {
+		t.Errorf("upstream saw %d connections for 20 sequential requests, want 1", n)
+	}
+}
+
+func TestUpstreamPoolSurvivesUnrelatedRouteChange(t *testing.T) {
+	var newConns atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	route := Route{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}
+	srv := &Server{}
+	srv.setRoutes([]Route{route})
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+	defer proxyServer.Close()
+
+	get := func() {
+		t.Helper()
+		req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+		req.Host = "app.test"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	get()
+	srv.setRoutes([]Route{route, {Domain: "other.test", Port: 1, Type: "http"}})
+	get()
+
+	if n := newConns.Load(); n != 1 {
+		t.Errorf("upstream saw %d connections across a route reload, want 1", n)
+	}
+}
+
+// --- Benchmarks ---
+
+func BenchmarkRouteLookup(b *testing.B) {
+	for _, n := range []int{10, 100, 500, 1000} {
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			srv := &Server{}
+			srv.setRoutes(manyRoutes(n, 20000))
+			host := fmt.Sprintf("svc-%d.app.test", n-1) // worst case for a linear scan
+			b.ReportAllocs()
+			for b.Loop() {
+				if srv.lookupHTTP(host) == nil {
+					b.Fatal("route not found")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProxyRequest measures a full request through handleHTTP with
+// hundreds of registered routes. Compare against BenchmarkDirectRequest to
+// see the proxy's own overhead.
+func BenchmarkProxyRequest(b *testing.B) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	upstreamPort := upstream.Listener.Addr().(*net.TCPAddr).Port
+
+	for _, n := range []int{1, 100, 500} {
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			routes := manyRoutes(n-1, 20000)
+			routes = append(routes, Route{Domain: "target.app.test", Port: upstreamPort, Type: "http"})
+			srv := &Server{}
+			srv.setRoutes(routes)
+
+			proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+			defer proxyServer.Close()
+
+			client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+			b.ReportAllocs()
+			for b.Loop() {
+				req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+				req.Host = "target.app.test"
+				resp, err := client.Do(req)
+				if err != nil {
+					b.Fatalf("request: %v", err)
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkDirectRequest(b *testing.B) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+	b.ReportAllocs()
+	for b.Loop() {
+		resp, err := client.Get(upstream.URL + "/")
+		if err != nil {
+			b.Fatalf("request: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
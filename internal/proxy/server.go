@@ -10,7 +10,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -22,6 +21,7 @@ import (
 	"github.com/gorilla/websocket"
 
 	roxydns "github.com/logscore/roxy/internal/dns"
+	"github.com/logscore/roxy/pkg/config"
 )
 
 const (
@@ -35,31 +35,59 @@ const (
 	ProxyStartRetryInterval = 100 * time.Millisecond
 	// shutdownTimeout is the max time allowed for graceful shutdown.
 	shutdownTimeout = 10 * time.Second
+	// reservedHost serves roxy's own endpoints: the dashboard and /metrics.
+	reservedHost = "roxy.test"
+
+	// ErrorHeader marks responses roxy sends in place of the service:
+	// "no-route" for an unknown host, "unreachable" when the service does
+	// not accept connections and "timeout" when it is too slow.
+	ErrorHeader = "X-Roxy-Error"
 )
 
 // Route is the in-memory representation of a proxy route.
 type Route struct {
+	ID         string `json:"id,omitempty"`
 	Domain     string `json:"domain"`
 	Port       int    `json:"port"`                  // upstream service port
 	ListenPort int    `json:"listen_port,omitempty"` // proxy listen port (TCP routes only)
 	Type       string `json:"type"`                  // "http" or "tcp"
+
+	// Per-route proxy behaviour (see config.Route).
+	Protocol       string                  `json:"protocol,omitempty"`        // upstream protocol: "http" (default) or "h2c"
+	TrustForwarded bool                    `json:"trust_forwarded,omitempty"` // keep incoming X-Forwarded-*/Forwarded chains
+	ChangeOrigin   bool                    `json:"change_origin,omitempty"`   // send Host: localhost:<port> upstream
+	RewriteOrigin  bool                    `json:"rewrite_origin,omitempty"`  // also rewrite same-site Origin/Referer (implies ChangeOrigin)
+	Streaming      *config.StreamingConfig `json:"streaming,omitempty"`       // flush interval and upstream timeouts
+	Headers        *config.HeaderRules     `json:"headers,omitempty"`         // request/response header edits
+	CORS           *config.CORSConfig      `json:"cors,omitempty"`            // answer preflights and add CORS headers
+	Shaping        *config.ShapingConfig   `json:"shaping,omitempty"`         // simulated latency, bandwidth and resets
+	Faults         []config.FaultRule      `json:"faults,omitempty"`          // injected errors, timeouts and aborts
 }
 
 // Server is the built-in reverse proxy.
 type Server struct {
-	httpAddr   string
-	httpsAddr  string
-	dnsPort    int
-	tlsEnabled bool
-	certsDir   string
-	routesFile string
+	httpAddr    string
+	httpsAddr   string
+	dnsPort     int
+	tlsEnabled  bool
+	certsDir    string
+	routesFile  string
+	access      *accessLogger // nil when access logging is off
+	metrics     *metrics
+	metricsAddr string
+	dash        *dashboard
+
+	reservedOnce sync.Once
+	reservedMux  *http.ServeMux // see serveReserved
 
 	mu     sync.RWMutex
 	routes []Route
+	table  *routeTable // HTTP routes indexed by host; see setRoutes
 
-	httpServer   *http.Server
-	httpsServer  *http.Server
-	tcpListeners map[string]net.Listener // domain -> listener
+	httpServer    *http.Server
+	httpsServer   *http.Server
+	metricsServer *http.Server
+	tcpListeners  map[string]net.Listener // domain -> listener
 }
 
 // Options configures the proxy server.
@@ -70,6 +98,19 @@ type Options struct {
 	TLS        bool
 	CertsDir   string
 	RoutesFile string
+	// LogsDir is where per-route access logs are written. Empty disables
+	// access logging.
+	LogsDir string
+	// AccessLog is the access log format: AccessLogJSON (default),
+	// AccessLogCommon, AccessLogCombined or AccessLogOff.
+	AccessLog string
+	// Controller starts and stops services from the dashboard at
+	// http://roxy.test. Nil makes the dashboard read-only.
+	Controller Controller
+	// MetricsAddr is an extra local address (e.g. "127.0.0.1:9091") that
+	// serves Prometheus metrics at /metrics. They are always available at
+	// http://roxy.test/metrics through the proxy itself.
+	MetricsAddr string
 }
 
 // New creates a new proxy server.
@@ -92,6 +133,10 @@ func New(opts Options) *Server {
 		tlsEnabled:   opts.TLS,
 		certsDir:     opts.CertsDir,
 		routesFile:   opts.RoutesFile,
+		access:       newAccessLogger(opts.LogsDir, opts.AccessLog),
+		metrics:      newMetrics(),
+		metricsAddr:  opts.MetricsAddr,
+		dash:         newDashboard(opts.RoutesFile, opts.Controller),
 		tcpListeners: make(map[string]net.Listener),
 	}
 }
@@ -108,11 +153,16 @@ func (s *Server) Run() error {
 		log.Printf("warning: failed to start DNS server: %v (is port %d in use?)", err, s.dnsPort)
 	}
 
-	// Start HTTP server
+	// Start HTTP server. Cleartext HTTP/2 (prior knowledge) is accepted
+	// alongside HTTP/1.1 so plaintext gRPC clients can reach h2c routes.
 	mux := http.HandlerFunc(s.handleHTTP)
+	var httpProtocols http.Protocols
+	httpProtocols.SetHTTP1(true)
+	httpProtocols.SetUnencryptedHTTP2(true)
 	s.httpServer = &http.Server{
-		Addr:    s.httpAddr,
-		Handler: mux,
+		Addr:      s.httpAddr,
+		Handler:   mux,
+		Protocols: &httpProtocols,
 	}
 
 	errCh := make(chan error, 2)
@@ -131,11 +181,7 @@ func (s *Server) Run() error {
 			log.Printf("warning: TLS setup failed: %v (HTTPS disabled)", err)
 			s.tlsEnabled = false
 		} else {
-			s.httpsServer = &http.Server{
-				Addr:      s.httpsAddr,
-				Handler:   mux,
-				TLSConfig: tlsConfig,
-			}
+			s.httpsServer = newHTTPSServer(s.httpsAddr, mux, tlsConfig)
 
 			go func() {
 				ln, err := tls.Listen("tcp", s.httpsAddr, tlsConfig)
@@ -151,6 +197,18 @@ func (s *Server) Run() error {
 		}
 	}
 
+	if s.metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", s.metrics)
+		s.metricsServer = &http.Server{Addr: s.metricsAddr, Handler: metricsMux}
+		go func() {
+			log.Printf("metrics listening on http://%s/metrics", s.metricsAddr)
+			if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("warning: metrics server: %v", err)
+			}
+		}()
+	}
+
 	// Start TCP listeners for tcp-type routes
 	s.startTCPListeners()
 
@@ -174,6 +232,20 @@ func (s *Server) Run() error {
 	return s.shutdown()
 }
 
+// newHTTPSServer builds the TLS-facing server. HTTP/2 is enabled alongside
+// HTTP/1.1 and is negotiated through ALPN (see buildTLSConfig).
+func newHTTPSServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	return &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+		Protocols: &protocols,
+	}
+}
+
 func (s *Server) shutdown() error {
 	s.mu.Lock()
 	for domain, ln := range s.tcpListeners {
@@ -195,6 +267,10 @@ func (s *Server) shutdown() error {
 			_ = s.httpsServer.Close()
 		}
 	}
+	if s.metricsServer != nil {
+		_ = s.metricsServer.Close()
+	}
+	s.access.close()
 	return nil
 }
 
@@ -207,47 +283,100 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		host = h
 	}
 
-	s.mu.RLock()
-	var matched *Route
-	for i := range s.routes {
-		if strings.EqualFold(s.routes[i].Domain, host) && s.routes[i].Type != "tcp" {
-			matched = &s.routes[i]
-			break
-		}
+	if strings.EqualFold(host, reservedHost) {
+		s.serveReserved(w, r)
+		return
 	}
-	s.mu.RUnlock()
 
-	if matched == nil {
+	entry := s.lookupHTTP(host)
+	if entry == nil {
 		s.serveNotFound(w, host)
 		return
 	}
 
-	upstream := fmt.Sprintf("127.0.0.1:%d", matched.Port)
+	// Every request that matches a route lands in its access log and
+	// metrics, including ones answered by CORS, faults or shaping, and
+	// aborted responses. The 502 page's own checks are left out.
+	probe := r.Header.Get(probeHeader) != "" && fromLocal(r)
+	r.Header.Del(probeHeader)
+	st := &requestState{host: host, origin: r.Header.Get("Origin"), start: time.Now()}
+	st.unreachable = func(w http.ResponseWriter, err error) { s.serveBadGateway(w, entry.route, err) }
+	if !probe {
+		rec := &responseRecorder{ResponseWriter: w}
+		defer s.finishRequest(rec, r, entry.route, st)
+		w = rec
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &countingBody{ReadCloser: r.Body, n: &st.reqBytes}
+	}
+
+	// Network simulation: a random reset or added latency comes first,
+	// as it would on a real slow link.
+	shaping := entry.shaping
+	if shaping.Enabled() && !shapeHTTPRequest(w, r, shaping) {
+		return
+	}
+
+	// Preflight requests are answered by the route's CORS policy.
+	if entry.route.CORS != nil && isPreflight(r) {
+		serveCORSPreflight(w, r, entry.route.CORS)
+		return
+	}
+
+	// Fault rules fire before anything reaches the service.
+	abort, handled := injectFault(w, r, entry, host)
+	if handled {
+		return
+	}
 
 	// WebSocket upgrades bypass httputil.ReverseProxy entirely.
 	// Go's HTTP transport can corrupt WebSocket frames (RSV1 errors),
 	// so we hijack both connections and copy raw bytes.
 	if websocket.IsWebSocketUpgrade(r) {
-		s.handleWebSocket(w, r, upstream, host)
+		if abort != nil {
+			panic(http.ErrAbortHandler) // drop the handshake
+		}
+		s.handleWebSocket(w, r, entry, host)
 		return
 	}
 
-	proxy := &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			req.URL.Scheme = "http"
-			req.URL.Host = upstream
-			req.Header.Set("X-Forwarded-Host", host)
-			if _, ok := req.Header["X-Forwarded-For"]; !ok {
-				req.Header.Set("X-Forwarded-For", r.RemoteAddr)
-			}
-		},
-		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Printf("proxy error [%s → %s]: %v", host, upstream, err)
-			http.Error(w, fmt.Sprintf("roxy: upstream unreachable (%v)", err), http.StatusBadGateway)
-		},
+	w, r = throttleHTTP(w, r, shaping)
+	if abort != nil {
+		aw := &abortingResponseWriter{ResponseWriter: w, limit: abort.AbortAfter}
+		defer aw.abort() // the body must never complete
+		w = aw
+	}
+
+	r, st.guard = newStreamGuard(r, entry.route.Streaming)
+	if st.guard != nil {
+		defer st.guard.stop()
 	}
 
-	proxy.ServeHTTP(w, r)
+	entry.proxy.ServeHTTP(w, withRequestState(r, st))
+}
+
+// finishRequest records a finished (or aborted) request in the access log
+// and metrics.
+func (s *Server) finishRequest(rec *responseRecorder, r *http.Request, route Route, st *requestState) {
+	duration := time.Since(st.start)
+	status := rec.status
+	if status == 0 && rec.hijacked && websocket.IsWebSocketUpgrade(r) {
+		status = http.StatusSwitchingProtocols
+	}
+	domain := strings.ToLower(route.Domain)
+	s.metrics.observeRequest(domain, r.Method, status, st.reqBytes.Load(), rec.bytes, duration, st.upstream)
+
+	if s.access == nil {
+		return
+	}
+	e := newAccessEntry(r, route, st.start)
+	e.Status = status
+	e.Bytes = rec.bytes
+	e.DurationMS = durationMS(duration)
+	if st.upstream > 0 {
+		e.UpstreamMS = durationMS(st.upstream)
+	}
+	s.access.log(domain, e)
 }
 
 // serveNotFound renders a styled HTML page listing all available routes.
@@ -263,6 +392,7 @@ func (s *Server) serveNotFound(w http.ResponseWriter, host string) {
 	}{Host: host, Routes: routes}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set(ErrorHeader, "no-route")
 	w.WriteHeader(http.StatusNotFound)
 	if err := notFoundTmpl.Execute(w, data); err != nil {
 		log.Printf("warning: failed to render not-found page: %v", err)
@@ -321,62 +451,6 @@ var notFoundTmpl = template.Must(template.New("notfound").Parse(`<!DOCTYPE html>
 </body>
 </html>`))
 
-// handleWebSocket proxies a WebSocket connection by terminating the
-// protocol on both sides (client ↔ proxy ↔ upstream). Because each
-// side is an independent WebSocket connection, compression negotiation
-// is fully isolated — the RSV1 frame corruption that occurs when
-// httputil.ReverseProxy passes compressed frames through is impossible.
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request, upstream, host string) {
-	// Accept the client's WebSocket upgrade
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(*http.Request) bool { return true },
-	}
-	clientConn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("websocket proxy: client upgrade: %v", err)
-		return
-	}
-	defer func() { _ = clientConn.Close() }()
-
-	// Dial the upstream as a fresh WebSocket connection (no compression)
-	dialer := websocket.Dialer{}
-	reqHeader := http.Header{
-		"Host":             {host},
-		"X-Forwarded-Host": {host},
-	}
-	if v := r.Header.Get("X-Forwarded-For"); v != "" {
-		reqHeader.Set("X-Forwarded-For", v)
-	} else {
-		reqHeader.Set("X-Forwarded-For", r.RemoteAddr)
-	}
-
-	upstreamConn, _, err := dialer.Dial("ws://"+upstream+r.URL.RequestURI(), reqHeader)
-	if err != nil {
-		log.Printf("websocket proxy: upstream dial ws://%s%s: %v", upstream, r.URL.RequestURI(), err)
-		return
-	}
-	defer func() { _ = upstreamConn.Close() }()
-
-	// Bidirectional message copy
-	errc := make(chan error, 2)
-	go func() { errc <- copyWS(upstreamConn, clientConn) }() // client → upstream
-	go func() { errc <- copyWS(clientConn, upstreamConn) }() // upstream → client
-	<-errc
-}
-
-// copyWS reads messages from src and writes them to dst until an error occurs.
-func copyWS(dst, src *websocket.Conn) error {
-	for {
-		mt, msg, err := src.ReadMessage()
-		if err != nil {
-			return err
-		}
-		if err := dst.WriteMessage(mt, msg); err != nil {
-			return err
-		}
-	}
-}
-
 // startTCPListeners starts a TCP listener for each tcp-type route.
 func (s *Server) startTCPListeners() {
 	s.mu.Lock()
@@ -420,14 +494,21 @@ func (s *Server) startTCPListenerLocked(route Route) {
 			if err != nil {
 				return // listener closed
 			}
-			go s.handleTCP(conn, route.Port)
+			go s.handleTCP(conn, route.Domain, route.Port, s.tcpShaping(route.Domain))
 		}
 	}()
 }
 
-func (s *Server) handleTCP(src net.Conn, targetPort int) {
+func (s *Server) handleTCP(src net.Conn, domain string, targetPort int, shaping config.ShapingConfig) {
+	defer s.metrics.tcpOpened(domain)()
+	if shouldReset(shaping) {
+		resetConn(src)
+		return
+	}
 	defer func() { _ = src.Close() }()
 
+	time.Sleep(shapeDelay(shaping))
+
 	dst, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", targetPort), tcpDialTimeout)
 	if err != nil {
 		log.Printf("tcp proxy: dial failed: %v", err)
@@ -439,7 +520,7 @@ func (s *Server) handleTCP(src net.Conn, targetPort int) {
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(dst, src)
+		_, _ = io.Copy(s.countTCP(throttleWriter(dst, shaping.UpKbps), domain, dirUp), src)
 		// Signal dst that no more data is coming from src
 		if tc, ok := dst.(*net.TCPConn); ok {
 			_ = tc.CloseWrite()
@@ -447,7 +528,7 @@ func (s *Server) handleTCP(src net.Conn, targetPort int) {
 	}()
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(src, dst)
+		_, _ = io.Copy(s.countTCP(throttleWriter(src, shaping.DownKbps), domain, dirDown), dst)
 		// Signal src that no more data is coming from dst
 		if tc, ok := src.(*net.TCPConn); ok {
 			_ = tc.CloseWrite()
@@ -456,6 +537,14 @@ func (s *Server) handleTCP(src net.Conn, targetPort int) {
 	wg.Wait()
 }
 
+// countTCP counts bytes written to w in the route's TCP metrics.
+func (s *Server) countTCP(w io.Writer, domain, direction string) io.Writer {
+	if s.metrics == nil {
+		return w
+	}
+	return &countingWriter{w: w, add: func(n int) { s.metrics.addTCPBytes(domain, direction, n) }}
+}
+
 // loadRoutes reads routes from the routes.json file.
 func (s *Server) loadRoutes() error {
 	data, err := os.ReadFile(s.routesFile)
@@ -481,13 +570,38 @@ func (s *Server) loadRoutes() error {
 		}
 	}
 
-	s.mu.Lock()
-	s.routes = routes
-	s.mu.Unlock()
+	s.mu.RLock()
+	old := s.routes
+	s.mu.RUnlock()
+	s.setRoutes(routes)
+	s.removeAccessLogs(old, routes)
+
+	// The dashboard needs the full route details (command, PID, ...).
+	var full []config.Route
+	if err := json.Unmarshal(data, &full); err == nil {
+		s.dash.observe(full)
+	}
 
 	return nil
 }
 
+// removeAccessLogs deletes the access logs of routes that were in old but
+// are not in routes.
+func (s *Server) removeAccessLogs(old, routes []Route) {
+	if s.access == nil {
+		return
+	}
+	active := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		active[strings.ToLower(route.Domain)] = true
+	}
+	for _, route := range old {
+		if domain := strings.ToLower(route.Domain); !active[domain] {
+			s.access.remove(domain)
+		}
+	}
+}
+
 // watchRoutes polls the routes file for changes and reloads.
 func (s *Server) watchRoutes() {
 	var lastMod time.Time
@@ -564,16 +678,19 @@ func (s *Server) buildTLSConfig() (*tls.Config, error) {
 
 	return &tls.Config{
 		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
 	}, nil
 }
 
 // ProxyState is the persisted proxy daemon state.
 type ProxyState struct {
-	PID       int  `json:"pid"`
-	HTTPPort  int  `json:"http_port"`
-	HTTPSPort int  `json:"https_port"`
-	DNSPort   int  `json:"dns_port"`
-	TLS       bool `json:"tls"`
+	PID         int    `json:"pid"`
+	HTTPPort    int    `json:"http_port"`
+	HTTPSPort   int    `json:"https_port"`
+	DNSPort     int    `json:"dns_port"`
+	TLS         bool   `json:"tls"`
+	AccessLog   string `json:"access_log,omitempty"`
+	MetricsAddr string `json:"metrics_addr,omitempty"`
 }
 
 // PidFile returns the path to the proxy PID file.
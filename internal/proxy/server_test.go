@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -69,9 +70,8 @@ func TestProxyIsolatesCompression(t *testing.T) {
 	}))
 	defer upstream.Close()
 
-	srv := &Server{
-		routes: []Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}},
-	}
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}})
 	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
 	defer proxyServer.Close()
 
@@ -124,9 +124,8 @@ func TestWebSocketEchoThroughProxy(t *testing.T) {
 	}))
 	defer upstream.Close()
 
-	srv := &Server{
-		routes: []Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}},
-	}
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}})
 	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
 	defer proxyServer.Close()
 
@@ -203,9 +202,8 @@ func TestNonWebSocketRequestPreservesHeaders(t *testing.T) {
 	}))
 	defer upstream.Close()
 
-	srv := &Server{
-		routes: []Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}},
-	}
+	srv := &Server{}
+	srv.setRoutes([]Route{{Domain: "app.test", Port: parsePort(t, upstream.URL), Type: "http"}})
 	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
 	defer proxyServer.Close()
 
@@ -226,6 +224,156 @@ func TestNonWebSocketRequestPreservesHeaders(t *testing.T) {
 	}
 }
 
+// TestWebSocketForwardsApplicationHeaders verifies that cookies, auth and
+// origin reach the upstream handshake, while hop-by-hop headers do not.
+func TestWebSocketForwardsApplicationHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+	}))
+	defer upstream.Close()
+
+	proxyServer := proxyTo(t, upstream, Route{Domain: "app.test"})
+
+	wsURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/socket"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{
+		"Host":                {"app.test"},
+		"Cookie":              {"session=abc123"},
+		"Authorization":       {"Bearer token"},
+		"Origin":              {"http://app.test"},
+		"Proxy-Authorization": {"Basic secret"},
+	})
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	_ = conn.Close()
+	_ = resp.Body.Close()
+
+	h := <-received
+	for k, want := range map[string]string{
+		"Cookie":           "session=abc123",
+		"Authorization":    "Bearer token",
+		"Origin":           "http://app.test",
+		"X-Forwarded-Host": "app.test",
+	} {
+		if got := h.Get(k); got != want {
+			t.Errorf("upstream %s = %q, want %q", k, got, want)
+		}
+	}
+	if got := h.Get("Proxy-Authorization"); got != "" {
+		t.Errorf("upstream Proxy-Authorization = %q, want it stripped", got)
+	}
+	if got := len(h.Values("Sec-Websocket-Key")); got != 1 {
+		t.Errorf("upstream received %d Sec-WebSocket-Key headers, want 1", got)
+	}
+}
+
+// TestWebSocketNegotiatesSubprotocol verifies that the client's subprotocol
+// offer reaches the upstream and the upstream's choice is mirrored back.
+func TestWebSocketNegotiatesSubprotocol(t *testing.T) {
+	offered := make(chan []string, 1)
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"graphql-transport-ws"},
+		CheckOrigin:  func(*http.Request) bool { return true },
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offered <- websocket.Subprotocols(r)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		_ = conn.Close()
+	}))
+	defer upstream.Close()
+
+	proxyServer := proxyTo(t, upstream, Route{Domain: "app.test"})
+
+	dialer := websocket.Dialer{Subprotocols: []string{"graphql-ws", "graphql-transport-ws"}}
+	wsURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/graphql"
+	conn, resp, err := dialer.Dial(wsURL, http.Header{"Host": {"app.test"}})
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	_ = conn.Close()
+	_ = resp.Body.Close()
+
+	if got := <-offered; strings.Join(got, ",") != "graphql-ws,graphql-transport-ws" {
+		t.Errorf("upstream saw offer %q, want both subprotocols in order", got)
+	}
+	if got := conn.Subprotocol(); got != "graphql-transport-ws" {
+		t.Errorf("client negotiated subprotocol %q, want %q", got, "graphql-transport-ws")
+	}
+}
+
+// TestWebSocketForwardsCloseCode verifies that the upstream's close code
+// and reason are passed on to the client.
+func TestWebSocketForwardsCloseCode(t *testing.T) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4401, "unauthorized"))
+		// Wait for the close handshake to complete.
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer upstream.Close()
+
+	proxyServer := proxyTo(t, upstream, Route{Domain: "app.test"})
+
+	wsURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Host": {"app.test"}})
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = resp.Body.Close()
+
+	_, _, err = conn.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) {
+		t.Fatalf("ReadMessage error = %v, want a close error", err)
+	}
+	if ce.Code != 4401 || ce.Text != "unauthorized" {
+		t.Errorf("close = %d %q, want 4401 %q", ce.Code, ce.Text, "unauthorized")
+	}
+}
+
+// TestWebSocketRelaysRejectedHandshake verifies that an upstream refusing
+// the upgrade is reported to the client with the upstream's status.
+func TestWebSocketRelaysRejectedHandshake(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "login required", http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	proxyServer := proxyTo(t, upstream, Route{Domain: "app.test"})
+
+	wsURL := "ws" + strings.TrimPrefix(proxyServer.URL, "http") + "/"
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Host": {"app.test"}})
+	if err == nil {
+		t.Fatal("expected handshake error, got nil")
+	}
+	if resp == nil {
+		t.Fatalf("expected handshake response, got error %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
 func parsePort(t *testing.T, rawURL string) int {
 	t.Helper()
 	parts := strings.Split(rawURL, ":")
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// minShapeChunk is the smallest write a throttled stream is split into.
+const minShapeChunk = 512
+
+// shapeDelay returns the latency to add for one request or connection:
+// Latency varied uniformly by ±Jitter, never negative.
+func shapeDelay(cfg config.ShapingConfig) time.Duration {
+	d := time.Duration(cfg.Latency)
+	if j := time.Duration(cfg.Jitter); j > 0 {
+		d += time.Duration(rand.Int64N(int64(2*j)+1)) - j
+	}
+	return max(d, 0)
+}
+
+// shouldReset rolls the dice for a simulated connection reset.
+func shouldReset(cfg config.ShapingConfig) bool {
+	return cfg.ResetRate > 0 && rand.Float64() < cfg.ResetRate
+}
+
+// sleepCtx waits for d or until ctx is done, reporting whether the full
+// delay elapsed.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resetConn closes c with an RST instead of a FIN where possible.
+func resetConn(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+	_ = c.Close()
+}
+
+// shapeHTTPRequest applies a route's latency and reset simulation to an
+// incoming request. It reports false when the request was reset or the
+// client went away during the delay, in which case nothing more may be
+// written to w.
+func shapeHTTPRequest(w http.ResponseWriter, r *http.Request, cfg config.ShapingConfig) bool {
+	if shouldReset(cfg) {
+		// HTTP/1: drop the TCP connection. HTTP/2 cannot be hijacked;
+		// aborting the handler resets just this stream.
+		if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			resetConn(conn)
+			return false
+		}
+		panic(http.ErrAbortHandler)
+	}
+	return sleepCtx(r.Context(), shapeDelay(cfg))
+}
+
+// throttleHTTP caps the request body and response rates for a route.
+func throttleHTTP(w http.ResponseWriter, r *http.Request, cfg config.ShapingConfig) (http.ResponseWriter, *http.Request) {
+	if cfg.UpKbps > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = &throttledReader{ReadCloser: r.Body, p: newPacer(cfg.UpKbps)}
+	}
+	if cfg.DownKbps > 0 {
+		w = &throttledResponseWriter{ResponseWriter: w, p: newPacer(cfg.DownKbps)}
+	}
+	return w, r
+}
+
+// pacer spaces out bytes so that the running total never gets ahead of
+// the configured rate.
+type pacer struct {
+	bytesPerSec float64
+	chunk       int
+	start       time.Time
+	sent        int64
+}
+
+func newPacer(kbps int) *pacer {
+	bps := float64(kbps) * 1000 / 8
+	// Ten chunks per second keeps the stream smooth without tiny writes.
+	return &pacer{bytesPerSec: bps, chunk: max(int(bps/10), minShapeChunk), start: time.Now()}
+}
+
+// pace records n bytes and sleeps until they are due.
+func (p *pacer) pace(n int) {
+	p.sent += int64(n)
+	due := p.start.Add(time.Duration(float64(p.sent) / p.bytesPerSec * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// write sends b to w in paced chunks.
+func (p *pacer) write(w io.Writer, b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), p.chunk)
+		m, err := w.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p.pace(m)
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// throttledReader limits how fast a request body is read.
+type throttledReader struct {
+	io.ReadCloser
+	p *pacer
+}
+
+func (r *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > r.p.chunk {
+		b = b[:r.p.chunk]
+	}
+	n, err := r.ReadCloser.Read(b)
+	r.p.pace(n)
+	return n, err
+}
+
+// throttledResponseWriter limits how fast a response body is written.
+// Unwrap exposes the original writer to http.ResponseController, so
+// flushing keeps working.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	p *pacer
+}
+
+func (w *throttledResponseWriter) Write(b []byte) (int, error) {
+	return w.p.write(w.ResponseWriter, b)
+}
+
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttledWriter limits how fast data is written to a TCP stream.
+type throttledWriter struct {
+	w io.Writer
+	p *pacer
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	return w.p.write(w.w, b)
+}
+
+// throttleWriter wraps w with a rate cap, or returns it as-is for kbps 0.
+func throttleWriter(w io.Writer, kbps int) io.Writer {
+	if kbps <= 0 {
+		return w
+	}
+	return &throttledWriter{w: w, p: newPacer(kbps)}
+}
+
+// tcpShaping returns the current shaping settings for a TCP route. It is
+// read per connection so `roxy shape` takes effect without a restart.
+func (s *Server) tcpShaping(domain string) config.ShapingConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, route := range s.routes {
+		if route.Type == "tcp" && route.Domain == domain {
+			return route.Shaping.Resolve()
+		}
+	}
+	return config.ShapingConfig{}
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+func shapedHTTPProxy(t *testing.T, shaping *config.ShapingConfig, body []byte) *httptest.Server {
+	t.Helper()
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write(body)
+	})
+	return proxyTo(t, upstream, Route{Domain: "slow.app.test", Shaping: shaping})
+}
+
+func shapedGet(proxyURL string, body io.Reader) (*http.Response, []byte, time.Duration, error) {
+	method := "GET"
+	if body != nil {
+		method = "POST"
+	}
+	req, _ := http.NewRequest(method, proxyURL+"/", body)
+	req.Host = "slow.app.test"
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, time.Since(start), err
+	}
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	return resp, data, time.Since(start), err
+}
+
+func TestShapeDelayJitter(t *testing.T) {
+	cfg := config.ShapingConfig{Latency: config.Duration(100 * time.Millisecond), Jitter: config.Duration(30 * time.Millisecond)}
+	for range 200 {
+		d := shapeDelay(cfg)
+		if d < 70*time.Millisecond || d > 130*time.Millisecond {
+			t.Fatalf("shapeDelay = %v, want within 100ms ±30ms", d)
+		}
+	}
+
+	cfg = config.ShapingConfig{Latency: config.Duration(10 * time.Millisecond), Jitter: config.Duration(time.Second)}
+	for range 200 {
+		if d := shapeDelay(cfg); d < 0 {
+			t.Fatalf("shapeDelay = %v, want it clamped at 0", d)
+		}
+	}
+}
+
+func TestShapedHTTPLatency(t *testing.T) {
+	proxyServer := shapedHTTPProxy(t, &config.ShapingConfig{Latency: config.Duration(150 * time.Millisecond)}, []byte("ok"))
+
+	_, body, elapsed, err := shapedGet(proxyServer.URL, nil)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if string(body) != "ok" {
+		t.Errorf("body = %q, want %q", body, "ok")
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("request took %v, want at least 150ms", elapsed)
+	}
+}
+
+func TestShapedHTTPBandwidth(t *testing.T) {
+	// 160 kbps = 20 KB/s, so 10 KB takes about half a second each way.
+	payload := bytes.Repeat([]byte("x"), 10_000)
+
+	down := shapedHTTPProxy(t, &config.ShapingConfig{DownKbps: 160}, payload)
+	_, body, elapsed, err := shapedGet(down.URL, nil)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if len(body) != len(payload) {
+		t.Fatalf("got %d bytes, want %d", len(body), len(payload))
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("download took %v, want at least 400ms at 160kbps", elapsed)
+	}
+
+	up := shapedHTTPProxy(t, &config.ShapingConfig{UpKbps: 160}, []byte("ok"))
+	_, _, elapsed, err = shapedGet(up.URL, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("upload took %v, want at least 400ms at 160kbps", elapsed)
+	}
+}
+
+func TestShapedHTTPReset(t *testing.T) {
+	proxyServer := shapedHTTPProxy(t, &config.ShapingConfig{ResetRate: 1}, []byte("ok"))
+
+	if _, _, _, err := shapedGet(proxyServer.URL, nil); err == nil {
+		t.Fatal("expected the request to be reset, got a response")
+	}
+}
+
+func TestShapedHTTPProfileTogglesLive(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	route := Route{Domain: "slow.app.test", Port: parsePort(t, upstream.URL), Type: "http"}
+	srv := &Server{}
+	srv.setRoutes([]Route{route})
+	proxyServer := httptest.NewServer(http.HandlerFunc(srv.handleHTTP))
+	defer proxyServer.Close()
+
+	route.Shaping = &config.ShapingConfig{Profile: "lossy", ResetRate: 1}
+	srv.setRoutes([]Route{route})
+	if _, _, _, err := shapedGet(proxyServer.URL, nil); err == nil {
+		t.Fatal("expected a reset after shaping was enabled")
+	}
+
+	route.Shaping = nil
+	srv.setRoutes([]Route{route})
+	if _, _, _, err := shapedGet(proxyServer.URL, nil); err != nil {
+		t.Fatalf("request after shaping was removed: %v", err)
+	}
+}
+
+func TestShapedTCP(t *testing.T) {
+	upstreamPort := freePort(t)
+	listenPort := freePort(t)
+
+	_, cleanupEcho := tcpEchoServer(t, upstreamPort)
+	defer cleanupEcho()
+	srv, cleanup := setupTCPProxy(t, listenPort, upstreamPort, "db.app.test")
+	defer cleanup()
+
+	// Latency is applied per connection, before dialing the service.
+	srv.setRoutes([]Route{{
+		Domain: "db.app.test", Port: upstreamPort, ListenPort: listenPort, Type: "tcp",
+		Shaping: &config.ShapingConfig{Latency: config.Duration(150 * time.Millisecond)},
+	}})
+	conn := dialProxy(t, listenPort)
+	start := time.Now()
+	_, _ = conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	_ = conn.Close()
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("round trip took %v, want at least 150ms", elapsed)
+	}
+
+	// A reset rate of 1 drops every new connection.
+	srv.setRoutes([]Route{{
+		Domain: "db.app.test", Port: upstreamPort, ListenPort: listenPort, Type: "tcp",
+		Shaping: &config.ShapingConfig{ResetRate: 1},
+	}})
+	conn = dialProxy(t, listenPort)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _ = conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, buf); err == nil {
+		t.Fatal("expected the connection to be reset")
+	}
+}
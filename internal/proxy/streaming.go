@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// applyStreaming configures a route's flush interval on the reverse proxy.
+// httputil.ReverseProxy already flushes text/event-stream and
+// unknown-length (chunked) responses after every write regardless of
+// FlushInterval, so SSE and streamed responses never wait on this setting.
+func applyStreaming(proxy *httputil.ReverseProxy, cfg *config.StreamingConfig) {
+	if cfg != nil && cfg.FlushInterval != 0 {
+		proxy.FlushInterval = time.Duration(cfg.FlushInterval)
+	}
+}
+
+// streamGuard enforces a route's response-header and idle timeouts by
+// cancelling the context of the proxied request.
+type streamGuard struct {
+	cancel   context.CancelFunc
+	header   *time.Timer
+	idle     time.Duration
+	timedOut atomic.Bool
+}
+
+// newStreamGuard returns r bound to a cancellable context and a guard that
+// watches it. Both timeouts are optional; when neither is set the request
+// is returned unchanged with a nil guard.
+func newStreamGuard(r *http.Request, cfg *config.StreamingConfig) (*http.Request, *streamGuard) {
+	if cfg == nil || (cfg.ResponseHeaderTimeout <= 0 && cfg.IdleTimeout <= 0) {
+		return r, nil
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	g := &streamGuard{cancel: cancel, idle: time.Duration(cfg.IdleTimeout)}
+	if cfg.ResponseHeaderTimeout > 0 {
+		g.header = time.AfterFunc(time.Duration(cfg.ResponseHeaderTimeout), g.expire)
+	}
+	return r.WithContext(ctx), g
+}
+
+// expire records the timeout and aborts the upstream request.
+func (g *streamGuard) expire() {
+	g.timedOut.Store(true)
+	g.cancel()
+}
+
+// gotResponse is called once upstream headers arrive. It stops the header
+// timer and, with an idle timeout, wraps the body so every read re-arms it.
+func (g *streamGuard) gotResponse(resp *http.Response) {
+	if g.header != nil {
+		g.header.Stop()
+	}
+	if g.idle > 0 {
+		resp.Body = &idleTimeoutBody{
+			ReadCloser: resp.Body,
+			timer:      time.AfterFunc(g.idle, g.expire),
+			idle:       g.idle,
+		}
+	}
+}
+
+// stop releases the guard's timers and context once the request is done.
+func (g *streamGuard) stop() {
+	if g.header != nil {
+		g.header.Stop()
+	}
+	g.cancel()
+}
+
+// idleTimeoutBody re-arms its timer whenever the upstream produces data.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.ReadCloser.Close()
+}
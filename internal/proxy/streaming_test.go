@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/logscore/roxy/pkg/config"
+)
+
+// --- helpers ---
+
+// streamingProxy starts a proxy with one HTTP route using the given settings.
+func streamingProxy(t *testing.T, upstream *httptest.Server, cfg *config.StreamingConfig) *httptest.Server {
+	t.Helper()
+	return proxyTo(t, upstream, Route{Domain: "app.test", Streaming: cfg})
+}
+
+// readLineWithin reads one line or fails the test after d.
+func readLineWithin(t *testing.T, r *bufio.Reader, d time.Duration) string {
+	t.Helper()
+	lineCh := make(chan string, 1)
+	go func() {
+		line, _ := r.ReadString('\n')
+		lineCh <- line
+	}()
+	select {
+	case line := <-lineCh:
+		return line
+	case <-time.After(d):
+		t.Fatalf("no data within %v (response is being buffered)", d)
+		return ""
+	}
+}
+
+// --- Server-sent events ---
+
+func TestSSEIsFlushedPerEvent(t *testing.T) {
+	next := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		for i := range 3 {
+			_, _ = fmt.Fprintf(w, "data: event-%d\n\n", i)
+			flusher.Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	// A long flush interval must not delay event streams.
+	proxyServer := streamingProxy(t, upstream, &config.StreamingConfig{
+		FlushInterval: config.Duration(time.Hour),
+	})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/events", nil)
+	req.Host = "app.test"
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for i := range 3 {
+		if got, want := readLineWithin(t, reader, 2*time.Second), fmt.Sprintf("data: event-%d\n", i); got != want {
+			t.Fatalf("event line = %q, want %q", got, want)
+		}
+		if blank := readLineWithin(t, reader, 2*time.Second); blank != "\n" {
+			t.Fatalf("expected event terminator, got %q", blank)
+		}
+		next <- struct{}{}
+	}
+}
+
+func TestChunkedStreamIsFlushedPerWrite(t *testing.T) {
+	next := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher := w.(http.Flusher)
+		for i := range 3 {
+			_, _ = fmt.Fprintf(w, "{\"token\":%d}\n", i)
+			flusher.Flush()
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	proxyServer := streamingProxy(t, upstream, nil)
+
+	req, _ := http.NewRequest("POST", proxyServer.URL+"/v1/completions", strings.NewReader("{}"))
+	req.Host = "app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	reader := bufio.NewReader(resp.Body)
+	for i := range 3 {
+		if got, want := readLineWithin(t, reader, 2*time.Second), fmt.Sprintf("{\"token\":%d}\n", i); got != want {
+			t.Fatalf("line = %q, want %q", got, want)
+		}
+		next <- struct{}{}
+	}
+}
+
+// --- Timeouts ---
+
+func TestResponseHeaderTimeoutReturns504(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	proxyServer := streamingProxy(t, upstream, &config.StreamingConfig{
+		ResponseHeaderTimeout: config.Duration(100 * time.Millisecond),
+	})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/slow", nil)
+	req.Host = "app.test"
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timeout took %v, want ~100ms", elapsed)
+	}
+}
+
+func TestResponseHeaderTimeoutDoesNotCutSlowBody(t *testing.T) {
+	// Once headers arrive the header timeout no longer applies.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		_, _ = fmt.Fprint(w, "done")
+	}))
+	defer upstream.Close()
+
+	proxyServer := streamingProxy(t, upstream, &config.StreamingConfig{
+		ResponseHeaderTimeout: config.Duration(100 * time.Millisecond),
+	})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if err != nil || string(body) != "done" {
+		t.Errorf("body = %q, err = %v; want %q", body, err, "done")
+	}
+}
+
+func TestIdleTimeoutAbortsStalledStream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // stall until the proxy gives up
+	}))
+	defer upstream.Close()
+
+	proxyServer := streamingProxy(t, upstream, &config.StreamingConfig{
+		IdleTimeout: config.Duration(150 * time.Millisecond),
+	})
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/events", nil)
+	req.Host = "app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	done := make(chan []byte, 1)
+	go func() {
+		body, _ := io.ReadAll(resp.Body)
+		done <- body
+	}()
+
+	select {
+	case body := <-done:
+		if !strings.HasPrefix(string(body), "data: first") {
+			t.Errorf("body = %q, want the first event before the abort", body)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("stalled stream was not aborted by the idle timeout")
+	}
+}
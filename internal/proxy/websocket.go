@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsCloseTimeout bounds how long forwarding a close frame may block.
+const wsCloseTimeout = time.Second
+
+// wsSkipHeaders are request headers that are not copied onto the upstream
+// handshake: hop-by-hop headers, and the handshake headers the dialer
+// generates itself (the subprotocol offer is passed via Dialer.Subprotocols).
+var wsSkipHeaders = map[string]bool{
+	"Connection":               true,
+	"Keep-Alive":               true,
+	"Proxy-Authenticate":       true,
+	"Proxy-Authorization":      true,
+	"Proxy-Connection":         true,
+	"Te":                       true,
+	"Trailer":                  true,
+	"Transfer-Encoding":        true,
+	"Upgrade":                  true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+// handleWebSocket proxies a WebSocket connection by terminating the
+// protocol on both sides (client ↔ proxy ↔ upstream). Because each
+// side is an independent WebSocket connection, compression negotiation
+// is fully isolated — the RSV1 frame corruption that occurs when
+// httputil.ReverseProxy passes compressed frames through is impossible.
+//
+// The upstream is dialed first so its handshake decides the outcome: the
+// client's subprotocol offer is forwarded, and whichever subprotocol the
+// upstream picks is the one accepted on the client leg. A rejected
+// upstream handshake (e.g. 401) is relayed to the client as-is.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request, entry *routeEntry, host string) {
+	upstream := entry.upstream
+
+	// Dial the upstream as a fresh WebSocket connection (no compression)
+	dialer := websocket.Dialer{
+		Subprotocols: websocket.Subprotocols(r),
+	}
+	upstreamConn, resp, err := dialer.Dial("ws://"+upstream+r.URL.RequestURI(), wsUpstreamHeader(r, entry, host))
+	if err != nil {
+		log.Printf("websocket proxy: upstream dial ws://%s%s: %v", upstream, r.URL.RequestURI(), err)
+		if resp != nil {
+			relayHandshakeFailure(w, resp)
+			return
+		}
+		http.Error(w, "roxy: upstream unreachable ("+err.Error()+")", http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = upstreamConn.Close() }()
+
+	// Accept the client's WebSocket upgrade, mirroring the upstream's choice
+	// of subprotocol and any cookies it set during the handshake.
+	respHeader := http.Header{}
+	if proto := upstreamConn.Subprotocol(); proto != "" {
+		respHeader.Set("Sec-Websocket-Protocol", proto)
+	}
+	for _, c := range resp.Header.Values("Set-Cookie") {
+		respHeader.Add("Set-Cookie", c)
+	}
+	applyResponseHeaders(respHeader, r, entry.route)
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	clientConn, err := upgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		log.Printf("websocket proxy: client upgrade: %v", err)
+		return
+	}
+	defer func() { _ = clientConn.Close() }()
+
+	domain := strings.ToLower(entry.route.Domain)
+	defer s.metrics.wsOpened(domain)()
+	countUp := func(n int) { s.metrics.addWSBytes(domain, dirUp, n) }
+	countDown := func(n int) { s.metrics.addWSBytes(domain, dirDown, n) }
+
+	// Bidirectional message copy
+	errc := make(chan error, 2)
+	go func() { errc <- copyWS(upstreamConn, clientConn, countUp) }()   // client → upstream
+	go func() { errc <- copyWS(clientConn, upstreamConn, countDown) }() // upstream → client
+	<-errc
+}
+
+// wsUpstreamHeader builds the upstream handshake headers from the client
+// request. Application headers (Cookie, Authorization, Origin, User-Agent,
+// ...) pass through; hop-by-hop and handshake headers are dropped, and the
+// Host, Origin and forwarding headers and the route's request header rules
+// are applied as for plain HTTP requests.
+func wsUpstreamHeader(r *http.Request, entry *routeEntry, host string) http.Header {
+	h := http.Header{}
+	for k, vs := range r.Header {
+		if wsSkipHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set("Host", entry.upstreamHost(host))
+	entry.rewriteOriginHeaders(h)
+	setForwardedHeaders(h, r, entry.route.TrustForwarded)
+	applyRequestHeaders(h, r, entry.route)
+	return h
+}
+
+// relayHandshakeFailure writes an upstream's non-101 handshake response
+// back to the client so auth failures and 404s surface unchanged.
+func relayHandshakeFailure(w http.ResponseWriter, resp *http.Response) {
+	for k, vs := range resp.Header {
+		if wsSkipHeaders[k] || k == "Content-Length" {
+			continue
+		}
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if resp.Body != nil {
+		_, _ = io.Copy(w, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
+
+// copyWS reads messages from src and writes them to dst until an error
+// occurs, reporting each message's size to count. A close frame received
+// from src is forwarded to dst with the same code and reason.
+func copyWS(dst, src *websocket.Conn, count func(int)) error {
+	for {
+		mt, msg, err := src.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) && ce.Code != websocket.CloseAbnormalClosure {
+				_ = dst.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(ce.Code, ce.Text),
+					time.Now().Add(wsCloseTimeout))
+			}
+			return err
+		}
+		if err := dst.WriteMessage(mt, msg); err != nil {
+			return err
+		}
+		count(len(msg))
+	}
+}
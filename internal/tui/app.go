@@ -0,0 +1,562 @@
+// Package tui is the full-screen view of `roxy run -a`: a sidebar listing
+// each service with its status and URL, and a pane with the output of the
+// selected service or of all of them.
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Status is the state of a service's process.
+type Status int
+
+const (
+	Starting Status = iota
+	Running
+	Stopping
+	Stopped // stopped from the UI
+	Exited  // exited on its own
+	Failed  // could not be started
+)
+
+func (s Status) String() string {
+	switch s {
+	case Starting:
+		return "starting"
+	case Running:
+		return "running"
+	case Stopping:
+		return "stopping"
+	case Stopped:
+		return "stopped"
+	case Exited:
+		return "exited"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// Service is one service shown by the App. Write its output to Log.
+type Service struct {
+	Name  string
+	URL   string
+	Color string // ANSI color for the name
+	Log   *Log
+
+	mu      sync.Mutex
+	status  Status
+	detail  string
+	changed func()
+}
+
+// NewService returns a service in the Starting state.
+func NewService(name, url, color string) *Service {
+	return &Service{Name: name, URL: url, Color: color, Log: &Log{}}
+}
+
+// SetStatus updates the service's status. detail is shown next to it, for
+// example an exit code.
+func (s *Service) SetStatus(status Status, detail string) {
+	s.mu.Lock()
+	s.status, s.detail = status, detail
+	changed := s.changed
+	s.mu.Unlock()
+	if changed != nil {
+		changed()
+	}
+}
+
+// Status returns the service's status and its detail.
+func (s *Service) Status() (Status, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status, s.detail
+}
+
+// Actions are what the key bindings do. They are called from the UI loop,
+// so they must not block.
+type Actions struct {
+	Restart func(name string)
+	Stop    func(name string)
+	Open    func(url string) error
+}
+
+const (
+	// redrawInterval limits redraws while services print quickly.
+	redrawInterval = 50 * time.Millisecond
+
+	helpText = "↑↓ select  r restart  s stop  o open  space hide  / filter  pgup/pgdn scroll  q quit"
+)
+
+// App is the terminal UI. Run it once.
+type App struct {
+	mu       sync.Mutex
+	services []*Service // replaced, never modified, by SetServices
+	actions  Actions
+
+	// View state, owned by the Run loop.
+	selected int // 0 is the combined view; i is services[i-1]
+	hidden   map[string]bool
+	filter   string
+	editing  bool // typing a filter
+	input    []rune
+	scroll   int // lines scrolled back from the newest
+	message  string
+
+	dirty    chan struct{}
+	notices  chan string
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// New returns an App showing services.
+func New(services []*Service, actions Actions) *App {
+	a := &App{
+		actions: actions,
+		hidden:  make(map[string]bool),
+		dirty:   make(chan struct{}, 1),
+		notices: make(chan string, 4),
+		quit:    make(chan struct{}),
+	}
+	a.SetServices(services)
+	return a
+}
+
+// SetServices replaces the services shown, for example after the project
+// config changed. A Service kept from the old list keeps its output.
+func (a *App) SetServices(services []*Service) {
+	for _, s := range services {
+		s.mu.Lock()
+		s.changed = a.invalidate
+		s.mu.Unlock()
+		s.Log.mu.Lock()
+		s.Log.changed = a.invalidate
+		s.Log.mu.Unlock()
+	}
+	a.mu.Lock()
+	a.services = services
+	a.mu.Unlock()
+	a.invalidate()
+}
+
+// list returns the services shown.
+func (a *App) list() []*Service {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.services
+}
+
+// Notify shows msg in the bottom bar until the next key press.
+func (a *App) Notify(msg string) {
+	select {
+	case a.notices <- msg:
+	case <-a.quit:
+	}
+}
+
+func (a *App) invalidate() {
+	select {
+	case a.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Stop makes Run return, as if the user had quit.
+func (a *App) Stop() {
+	a.quitOnce.Do(func() { close(a.quit) })
+}
+
+// PaneSize returns the size of the output pane for a terminal of w columns
+// and h rows, for sizing the services' own terminals.
+func (a *App) PaneSize(w, h int) (rows, cols int) {
+	sideW := a.sidebarWidth(w)
+	return max(h-2, 1), max(w-sideW-1, 1)
+}
+
+func (a *App) sidebarWidth(w int) int {
+	sideW := 16
+	for _, s := range a.list() {
+		sideW = max(sideW, len(s.Name)+16, len(s.URL)+5)
+	}
+	return min(sideW, w/3)
+}
+
+// Run takes over the terminal on in and out until the user quits or Stop
+// is called. The screen is restored when it returns.
+func (a *App) Run(in, out *os.File) error {
+	defer a.Stop()
+
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = term.Restore(int(in.Fd()), state) }()
+
+	// Alternate screen, hidden cursor.
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	keys := make(chan []string)
+	go readKeys(in, keys)
+
+	for {
+		w, h, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			w, h = 80, 24
+		}
+		_, _ = io.WriteString(out, a.render(w, h))
+
+		select {
+		case <-a.quit:
+			return nil
+		case ks, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range ks {
+				if a.handleKey(k) {
+					return nil
+				}
+			}
+		case <-a.dirty:
+			time.Sleep(redrawInterval)
+		case msg := <-a.notices:
+			a.message = msg
+		case <-winch:
+		}
+	}
+}
+
+// readKeys sends the keys read from in until it fails.
+func readKeys(in io.Reader, keys chan<- []string) {
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			keys <- parseKeys(buf[:n])
+		}
+		if err != nil {
+			close(keys)
+			return
+		}
+	}
+}
+
+// parseKeys splits raw terminal input into key names: printable characters
+// as themselves, and "up", "down", "pgup", "pgdn", "home", "end", "enter",
+// "esc", "backspace" and "ctrl-c".
+func parseKeys(b []byte) []string {
+	var keys []string
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == 0x1b && i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O'):
+			seq := b[i+2:]
+			name, n := "", 1
+			switch {
+			case seq[0] == 'A':
+				name = "up"
+			case seq[0] == 'B':
+				name = "down"
+			case seq[0] == 'H':
+				name = "home"
+			case seq[0] == 'F':
+				name = "end"
+			case len(seq) > 1 && seq[1] == '~':
+				n = 2
+				switch seq[0] {
+				case '1', '7':
+					name = "home"
+				case '4', '8':
+					name = "end"
+				case '5':
+					name = "pgup"
+				case '6':
+					name = "pgdn"
+				}
+			}
+			if name != "" {
+				keys = append(keys, name)
+			}
+			i += 1 + n
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		case c >= 0x20:
+			keys = append(keys, string(c))
+		}
+	}
+	return keys
+}
+
+// handleKey applies a key and reports whether the user quit.
+func (a *App) handleKey(k string) bool {
+	if a.editing {
+		switch k {
+		case "ctrl-c":
+			return true
+		case "enter":
+			a.filter = string(a.input)
+			a.editing = false
+			a.scroll = 0
+		case "esc":
+			a.editing = false
+		case "backspace":
+			if len(a.input) > 0 {
+				a.input = a.input[:len(a.input)-1]
+			}
+		default:
+			if len(k) == 1 {
+				a.input = append(a.input, rune(k[0]))
+			}
+		}
+		return false
+	}
+
+	a.message = ""
+	svc := a.selectedService()
+	switch k {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		a.selected = max(a.selected-1, 0)
+		a.scroll = 0
+	case "down", "j":
+		a.selected = min(a.selected+1, len(a.list()))
+		a.scroll = 0
+	case "pgup":
+		a.scroll += 10
+	case "pgdn":
+		a.scroll = max(a.scroll-10, 0)
+	case "home", "g":
+		a.scroll = maxLines * (len(a.list()) + 1)
+	case "end", "G":
+		a.scroll = 0
+	case "r":
+		for _, s := range a.targets() {
+			a.actions.Restart(s.Name)
+		}
+	case "s":
+		for _, s := range a.targets() {
+			a.actions.Stop(s.Name)
+		}
+	case "o":
+		switch {
+		case svc == nil:
+			a.message = "select a service to open its URL"
+		case svc.URL == "":
+			a.message = svc.Name + " has no URL"
+		default:
+			if err := a.actions.Open(svc.URL); err != nil {
+				a.message = "open: " + err.Error()
+			} else {
+				a.message = "opened " + svc.URL
+			}
+		}
+	case " ":
+		if svc == nil {
+			clear(a.hidden)
+		} else {
+			a.hidden[svc.Name] = !a.hidden[svc.Name]
+		}
+	case "/":
+		a.editing = true
+		a.input = []rune(a.filter)
+	case "esc":
+		a.filter = ""
+	}
+	return false
+}
+
+// selectedService returns the selected service, or nil for the combined
+// view. The selection is a position in the list, clamped if the list got
+// shorter.
+func (a *App) selectedService() *Service {
+	services := a.list()
+	a.selected = min(a.selected, len(services))
+	if a.selected == 0 {
+		return nil
+	}
+	return services[a.selected-1]
+}
+
+// targets returns the services that restart and stop apply to: the
+// selected one, or every shown service in the combined view.
+func (a *App) targets() []*Service {
+	if svc := a.selectedService(); svc != nil {
+		return []*Service{svc}
+	}
+	var shown []*Service
+	for _, s := range a.list() {
+		if !a.hidden[s.Name] {
+			shown = append(shown, s)
+		}
+	}
+	return shown
+}
+
+// viewLines returns the lines of the output pane, oldest first: the
+// selected service's output, or the output of every shown service
+// prefixed with its name, narrowed by the filter.
+func (a *App) viewLines() []string {
+	var lines []string
+	keep := func(text string) bool {
+		return a.filter == "" || strings.Contains(strings.ToLower(plain(text)), strings.ToLower(a.filter))
+	}
+
+	if svc := a.selectedService(); svc != nil {
+		for _, l := range svc.Log.snapshot() {
+			if keep(l.text) {
+				lines = append(lines, l.text)
+			}
+		}
+		return lines
+	}
+
+	// Merge the shown services' lines in the order they were written.
+	nameW := 0
+	var logs [][]line
+	var shown []*Service
+	for _, s := range a.list() {
+		if a.hidden[s.Name] {
+			continue
+		}
+		nameW = max(nameW, len(s.Name))
+		logs = append(logs, s.Log.snapshot())
+		shown = append(shown, s)
+	}
+	next := make([]int, len(logs))
+	for {
+		pick := -1
+		for i, l := range logs {
+			if next[i] < len(l) && (pick < 0 || l[next[i]].seq < logs[pick][next[pick]].seq) {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			return lines
+		}
+		text := logs[pick][next[pick]].text
+		next[pick]++
+		if keep(text) {
+			s := shown[pick]
+			lines = append(lines, fmt.Sprintf("%s[%-*s]%s %s", s.Color, nameW, s.Name, sgrReset, text))
+		}
+	}
+}
+
+// render draws the whole screen for a terminal of w columns and h rows.
+func (a *App) render(w, h int) string {
+	sideW := a.sidebarWidth(w)
+	paneH, paneW := a.PaneSize(w, h)
+
+	lines := a.viewLines()
+	a.scroll = min(a.scroll, max(len(lines)-paneH, 0))
+	end := len(lines) - a.scroll
+	lines = lines[max(end-paneH, 0):end]
+
+	side := a.sidebar()
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+
+	// Title bar.
+	n := len(a.list())
+	title := fmt.Sprintf(" roxy  %d services", n)
+	if n == 1 {
+		title = " roxy  1 service"
+	}
+	if svc := a.selectedService(); svc != nil {
+		title += "  ·  " + svc.Name
+	} else {
+		title += "  ·  all"
+	}
+	if a.filter != "" {
+		title += "  ·  filter: " + a.filter
+	}
+	if a.scroll > 0 {
+		title += fmt.Sprintf("  ·  scrolled back %d lines", a.scroll)
+	}
+	sb.WriteString("\x1b[7m" + fit(title, w) + sgrReset + "\r\n")
+
+	for row := range paneH {
+		var left, right string
+		if row < len(side) {
+			left = side[row]
+		}
+		if row < len(lines) {
+			right = lines[row]
+		}
+		sb.WriteString(fit(left, sideW))
+		sb.WriteString("\x1b[2m│" + sgrReset)
+		sb.WriteString(fit(right, paneW))
+		sb.WriteString("\r\n")
+	}
+
+	// Bottom bar; one column short so the terminal never scrolls.
+	bottom := helpText
+	switch {
+	case a.editing:
+		bottom = "filter: " + string(a.input) + "█"
+	case a.message != "":
+		bottom = a.message
+	}
+	sb.WriteString("\x1b[2m" + fit(bottom, max(w-1, 0)) + sgrReset)
+	return sb.String()
+}
+
+// sidebar returns the sidebar's lines: the combined view, then each
+// service's status and URL.
+func (a *App) sidebar() []string {
+	marker := func(i int) string {
+		if i == a.selected {
+			return "\x1b[1m▸ "
+		}
+		return "  "
+	}
+
+	side := []string{marker(0) + "all" + sgrReset, ""}
+	for i, s := range a.list() {
+		status, detail := s.Status()
+		dot := "\x1b[32m●"
+		switch status {
+		case Starting, Stopping:
+			dot = "\x1b[33m●"
+		case Stopped:
+			dot = "\x1b[2m○"
+		case Exited, Failed:
+			dot = "\x1b[31m●"
+		}
+		state := status.String()
+		if detail != "" {
+			state += " " + detail
+		}
+		name := s.Color + s.Name + sgrReset
+		if a.hidden[s.Name] {
+			name = "\x1b[2m" + s.Name + " (hidden)" + sgrReset
+		}
+		side = append(side,
+			fmt.Sprintf("%s%s%s %s \x1b[2m%s%s", marker(i+1), dot, sgrReset, name, state, sgrReset),
+			"    \x1b[2m"+s.URL+sgrReset,
+		)
+	}
+	return side
+}
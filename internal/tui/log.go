@@ -0,0 +1,196 @@
+package tui
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	// maxLines is how many lines of output each service keeps.
+	maxLines = 5000
+
+	sgrReset = "\x1b[0m"
+)
+
+// seq orders lines across services for the combined view.
+var seq atomic.Uint64
+
+type line struct {
+	seq  uint64
+	text string
+}
+
+// Log collects a service's output as lines. A carriage return rewrites the
+// current line, as it would on a terminal, so progress bars show their
+// latest state. Colors are kept; other escape sequences are dropped.
+type Log struct {
+	mu         sync.Mutex
+	lines      []line
+	partial    []byte
+	partialSeq uint64
+	pendingCR  bool // the last byte was \r: a line break if \n follows
+	changed    func()
+}
+
+func (l *Log) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	for _, b := range p {
+		if l.pendingCR {
+			l.pendingCR = false
+			if b == '\n' {
+				l.finish()
+				continue
+			}
+			l.partial = l.partial[:0]
+		}
+		switch b {
+		case '\n':
+			l.finish()
+		case '\r':
+			l.pendingCR = true
+		default:
+			if len(l.partial) == 0 {
+				l.partialSeq = seq.Add(1)
+			}
+			l.partial = append(l.partial, b)
+		}
+	}
+	changed := l.changed
+	l.mu.Unlock()
+
+	if changed != nil {
+		changed()
+	}
+	return len(p), nil
+}
+
+// finish ends the current line. Caller must hold mu.
+func (l *Log) finish() {
+	s := l.partialSeq
+	if len(l.partial) == 0 {
+		s = seq.Add(1)
+	}
+	l.lines = append(l.lines, line{seq: s, text: clean(l.partial)})
+	l.partial = l.partial[:0]
+
+	// Trim in batches rather than copying on every line.
+	if len(l.lines) > maxLines+maxLines/4 {
+		l.lines = append(l.lines[:0], l.lines[len(l.lines)-maxLines:]...)
+	}
+}
+
+// snapshot returns the log's lines, ending with the unfinished one if any.
+func (l *Log) snapshot() []line {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := make([]line, len(l.lines), len(l.lines)+1)
+	copy(lines, l.lines)
+	if len(l.partial) > 0 {
+		lines = append(lines, line{seq: l.partialSeq, text: clean(l.partial)})
+	}
+	return lines
+}
+
+// clean keeps the printable text and color (SGR) sequences of b.
+func clean(b []byte) string {
+	var sb strings.Builder
+	s := string(b)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == 0x1b:
+			n, sgr := escapeLen(s[i:])
+			if sgr {
+				sb.WriteString(s[i : i+n])
+			}
+			i += n
+			continue
+		case c == '\t':
+			sb.WriteString("    ")
+		case c < 0x20 || c == 0x7f:
+		default:
+			sb.WriteByte(c)
+		}
+		i++
+	}
+	return sb.String()
+}
+
+// escapeLen returns the length of the escape sequence at the start of s
+// and whether it sets colors (SGR).
+func escapeLen(s string) (int, bool) {
+	if len(s) < 2 {
+		return len(s), false
+	}
+	switch s[1] {
+	case '[': // CSI: parameters, then a final byte in 0x40-0x7e
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1, s[i] == 'm'
+			}
+		}
+		return len(s), false
+	case ']': // OSC: ends with BEL or ESC \
+		for i := 2; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1, false
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2, false
+			}
+		}
+		return len(s), false
+	default:
+		return 2, false
+	}
+}
+
+// plain strips escape sequences from s.
+func plain(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			n, _ := escapeLen(s[i:])
+			i += n
+			continue
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return sb.String()
+}
+
+// fit truncates or pads s to exactly w columns, keeping its colors.
+func fit(s string, w int) string {
+	var sb strings.Builder
+	n := 0
+	colored := false
+	for i := 0; i < len(s); {
+		if s[i] == 0x1b {
+			l, _ := escapeLen(s[i:])
+			sb.WriteString(s[i : i+l])
+			i += l
+			colored = true
+			continue
+		}
+		if n == w {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		sb.WriteString(s[i : i+size])
+		i += size
+		n++
+	}
+	if colored {
+		sb.WriteString(sgrReset)
+	}
+	if n < w {
+		sb.WriteString(strings.Repeat(" ", w-n))
+	}
+	return sb.String()
+}
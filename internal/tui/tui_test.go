@@ -0,0 +1,143 @@
+package tui
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func texts(lines []line) []string {
+	var out []string
+	for _, l := range lines {
+		out = append(out, l.text)
+	}
+	return out
+}
+
+func TestLogWrite(t *testing.T) {
+	l := &Log{}
+	_, _ = l.Write([]byte("one\r\ntwo\n\x1b[31mred\x1b[0m\x1b[2K\x1b]0;title\x07\n"))
+	_, _ = l.Write([]byte("10%\r20%"))
+	_, _ = l.Write([]byte("\r30%"))
+
+	want := []string{"one", "two", "\x1b[31mred\x1b[0m", "30%"}
+	if got := texts(l.snapshot()); !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+
+	_, _ = l.Write([]byte("\r\ndone\n"))
+	want = []string{"one", "two", "\x1b[31mred\x1b[0m", "30%", "done"}
+	if got := texts(l.snapshot()); !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		in   string
+		w    int
+		want string
+	}{
+		{"abc", 5, "abc  "},
+		{"abcdef", 3, "abc"},
+		{"\x1b[32mgreen\x1b[0m", 3, "\x1b[32mgre\x1b[0m"},
+		{"héllo", 2, "hé"},
+	}
+	for _, tt := range tests {
+		if got := fit(tt.in, tt.w); got != tt.want {
+			t.Errorf("fit(%q, %d) = %q, want %q", tt.in, tt.w, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("q\x1b[A\x1b[6~\x1b\r\x7f\x03"))
+	want := []string{"q", "up", "pgdn", "esc", "enter", "backspace", "ctrl-c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeys = %q, want %q", got, want)
+	}
+}
+
+func TestViewLines(t *testing.T) {
+	web := NewService("web", "https://web.test", "")
+	api := NewService("api", "http://api.test", "")
+	var restarted []string
+	a := New([]*Service{web, api}, Actions{
+		Restart: func(name string) { restarted = append(restarted, name) },
+	})
+
+	_, _ = web.Log.Write([]byte("web ready\n"))
+	_, _ = api.Log.Write([]byte("api ready\n"))
+	_, _ = web.Log.Write([]byte("GET /\n"))
+
+	got := strings.Join(a.viewLines(), "\n")
+	want := "[web]\x1b[0m web ready\n[api]\x1b[0m api ready\n[web]\x1b[0m GET /"
+	if got != want {
+		t.Errorf("combined view = %q, want %q", got, want)
+	}
+
+	// Hide web from the combined view; restart applies to shown services.
+	a.handleKey("down")
+	a.handleKey(" ")
+	a.handleKey("up")
+	if got := a.viewLines(); len(got) != 1 || !strings.HasSuffix(got[0], "api ready") {
+		t.Errorf("with web hidden = %q", got)
+	}
+	a.handleKey("r")
+	if !reflect.DeepEqual(restarted, []string{"api"}) {
+		t.Errorf("restarted %q, want [api]", restarted)
+	}
+
+	// Filter the selected service's output.
+	a.handleKey("down")
+	for _, k := range []string{"/", "g", "e", "t", "enter"} {
+		a.handleKey(k)
+	}
+	if got := a.viewLines(); !reflect.DeepEqual(got, []string{"GET /"}) {
+		t.Errorf("filtered view = %q", got)
+	}
+
+	if !a.handleKey("q") {
+		t.Error("q did not quit")
+	}
+}
+
+func TestRender(t *testing.T) {
+	web := NewService("web", "https://web.test", "")
+	web.SetStatus(Exited, "1")
+	a := New([]*Service{web}, Actions{})
+	for range 30 {
+		_, _ = web.Log.Write([]byte("line\n"))
+	}
+
+	screen := a.render(80, 10)
+	rows := strings.Split(screen, "\r\n")
+	if len(rows) != 10 {
+		t.Fatalf("rendered %d rows, want 10", len(rows))
+	}
+	if !strings.Contains(plain(screen), "exited 1") || !strings.Contains(plain(screen), "https://web.test") {
+		t.Errorf("sidebar is missing the status or URL:\n%s", plain(screen))
+	}
+}
+
+func TestSetServices(t *testing.T) {
+	web := NewService("web", "https://web.test", "")
+	api := NewService("api", "http://api.test", "")
+	a := New([]*Service{web, api}, Actions{})
+	a.handleKey("down")
+	a.handleKey("down")
+
+	// api is replaced while selected; the new service shows in the
+	// combined view.
+	worker := NewService("worker", "", "")
+	a.SetServices([]*Service{web, worker})
+	if svc := a.selectedService(); svc != worker {
+		t.Errorf("selected %v, want worker", svc)
+	}
+	_, _ = worker.Log.Write([]byte("started\n"))
+	a.handleKey("up")
+	a.handleKey("up")
+	if got := a.viewLines(); !reflect.DeepEqual(got, []string{"[worker]\x1b[0m started"}) {
+		t.Errorf("combined view = %q", got)
+	}
+}
@@ -1,35 +1,71 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/logscore/roxy/cmd"
+	"github.com/logscore/roxy/internal/attach"
+	"github.com/logscore/roxy/internal/proxy"
 	"github.com/logscore/roxy/pkg/config"
 )
 
+// Exit codes. Every command exits 0 on success. roxy run -a can also exit
+// with a service's exit code (see cmd.ExitCodeError).
+const (
+	exitError = 1 // the command failed
+	exitUsage = 2 // bad arguments; nothing was done
+)
+
 const usage = `roxy - dev server port multiplexer with subdomain routing
 
 Usage:
-  roxy run -a                    Run all services from roxy.json
-  roxy run <service>             Run a single service from roxy.json
+  roxy run -a [--profile <p>]    Run all services from the project config
+  roxy run <service>...          Run one or more services from the project config
   roxy run "<command>" [flags]   Run command with auto port/domain
-  roxy list                      List active routes
+  roxy list [--json]             List active routes
   roxy stop <id|domain>...       Stop one or more routes
   roxy stop -a [--remove-dns]    Stop all routes and proxy
+  roxy restart <id|domain|service>...  Restart routes, keeping domain and port
+  roxy attach <id|domain>        Attach to a detached process's terminal
+  roxy test [service...] -- <command>  Run a command against temporary services
   roxy logs <id|domain>          Tail logs for a detached process
+  roxy logs --access <id|domain> Tail the proxy access log for a route
+  roxy shape <id|domain> [flags] Simulate a slow or flaky network on a route
+  roxy fault <add|clear|list>    Inject errors, timeouts and aborts into a route
   roxy proxy <start|stop|restart|status|logs>  Manage the proxy server
   roxy tunnel <set|status>       Configure tunnel provider
 
+Output flags (list, run, proxy status, tunnel status):
+  --json                 Print JSON (a stable schema for scripts)
+  --format <tmpl>        Print through a Go template, e.g. '{{.URL}}'
+
+Exit codes: 0 success, 1 error, 2 invalid arguments
+
 Run flags:
   -d, --detach           Run in the background (detached mode)
+  --profile <p>          Also run services in profile <p> (implies -a)
+  --tty                  With -a: run services under a pseudo-terminal
+  --no-tui               With -a: print prefixed output instead of the terminal UI
+  --abort-on-exit        With -a: stop all services when any of them exits
+  --exit-code-from <s>   With -a: exit with service <s>'s exit code (implies --abort-on-exit)
   -p, --port <n>         Pin to an exact port (default: random)
   -n, --name <name>      Override subdomain name
   --tls                  Enable HTTPS for this process
   --public               Expose via tunnel (requires configured provider)
   --listen-port <n>      TCP mode: proxy listens on this port, forwards to service
+  --protocol <p>         Upstream protocol: http (default) or h2c (gRPC)
+  --flush-interval <d>   Flush proxied responses every <d> (negative: every write)
+  --response-header-timeout <d>  Return 504 if upstream headers take longer than <d>
+  --idle-timeout <d>     Abort responses that stall for longer than <d>
+  --trust-forwarded      Keep incoming X-Forwarded-*/Forwarded headers (behind another proxy)
+  --change-origin        Send Host: localhost:<port> to the service instead of the domain
+  --rewrite-origin       Also rewrite Origin/Referer to localhost (implies --change-origin)
 
 Stop flags:
   -a, --all          Stop all routes and the proxy
@@ -41,7 +77,9 @@ Proxy flags:
   --proxy-port <n>       HTTP proxy port (default: 80)
   --https-port <n>       HTTPS proxy port (default: 443)
   --dns-port <n>         DNS server port (default: 1299)
-  --tls                  Enable HTTPS`
+  --tls                  Enable HTTPS
+  --access-log <fmt>     Per-route access log format: json (default), common, combined, off
+  --metrics-addr <addr>  Also serve Prometheus metrics on <addr> (always at roxy.test/metrics)`
 
 func main() {
 	args := os.Args[1:]
@@ -58,16 +96,31 @@ func main() {
 		err = runCommand(args[1:])
 
 	case "list":
-		err = cmd.List()
+		err = cmd.List(formatArgs(args[1:], listUsage))
 
 	case "stop":
 		err = stopCommand(args[1:])
 
-	case "logs":
+	case "restart":
 		if len(args) < 2 {
-			die(logsUsage)
+			die(restartUsage)
 		}
-		err = cmd.Logs(args[1])
+		err = cmd.Restart(args[1:])
+
+	case "attach":
+		err = attachCommand(args[1:])
+
+	case "test":
+		err = testCommand(args[1:])
+
+	case "logs":
+		err = logsCommand(args[1:])
+
+	case "shape":
+		err = shapeCommand(args[1:])
+
+	case "fault":
+		err = faultCommand(args[1:])
 
 	case "proxy":
 		err = proxyCommand(args[1:])
@@ -83,25 +136,53 @@ func main() {
 		die("unknown command: " + args[0] + "\n\n" + usage)
 	}
 
+	var exitErr *cmd.ExitCodeError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 }
 
 const runUsage = `Usage:
-  roxy run -a                    Run all services from roxy.json
-  roxy run <service>             Run a single service from roxy.json
+  roxy run -a [--profile <p>]    Run all services from the project config
+  roxy run <service>...          Run one or more services from the project config
   roxy run "<command>" [flags]   Run command with auto port/domain
 
 Flags:
-  -a, --all              Run all services from roxy.json
+  -a, --all              Run all services from the project config (except ones with profiles)
+  --profile <p>          Also run services in profile <p> (implies -a; repeatable or comma-separated)
   -d, --detach           Run in the background (detached mode)
+  --tty                  With -a: run services under a pseudo-terminal (colors, progress bars)
+  --no-tui               With -a: print prefixed output instead of the terminal UI
+  --abort-on-exit        With -a: stop all services when any of them exits
+  --exit-code-from <s>   With -a: exit with service <s>'s exit code (implies --abort-on-exit)
   -p, --port <n>         Sets the port for the process. Increments from that value if that port is taken
   -n, --name <name>      Override subdomain name
   --tls                  Enable HTTPS for this process
   --public               Expose via tunnel (requires configured provider)
-  --listen-port <n>      TCP mode: proxy listens on this port, forwards to service`
+  --listen-port <n>      TCP mode: proxy listens on this port, forwards to service
+  --protocol <p>         Upstream protocol: http (default) or h2c (gRPC)
+  --flush-interval <d>   Flush proxied responses every <d> (negative: every write)
+  --response-header-timeout <d>  Return 504 if upstream headers take longer than <d>
+  --idle-timeout <d>     Abort responses that stall for longer than <d>
+  --trust-forwarded      Keep incoming X-Forwarded-*/Forwarded headers (behind another proxy)
+  --change-origin        Send Host: localhost:<port> to the service instead of the domain
+  --rewrite-origin       Also rewrite Origin/Referer to localhost (implies --change-origin)
+  --json                 Print the route's ID, URL and port as JSON
+  --format <tmpl>        Print the route through a Go template, e.g. '{{.URL}}'
+
+The project config is roxy.json, roxy.yaml, roxy.yml or roxy.toml in the
+current directory.`
+
+const listUsage = `Usage:
+  roxy list [--json | --format <tmpl>]
+
+Flags:
+  --json                 Print routes as a JSON array
+  --format <tmpl>        Print each route through a Go template, e.g. '{{.Domain}} {{.Port}}'`
 
 const stopUsage = `Usage:
   roxy stop <id|domain>...       Stop one or more routes
@@ -111,14 +192,79 @@ Flags:
   -a, --all          Stop all routes and the proxy
   --remove-dns       Also remove DNS resolver configuration (with -a)`
 
+const restartUsage = `Usage:
+  roxy restart <id|domain|service>...   Restart one or more routes
+
+The process is stopped and started again with the same command, domain,
+port and settings, in the background. The route stays registered meanwhile.`
+
+const testUsage = `Usage:
+  roxy test [service...] [--profile <p>] [--timeout <d>] -- <command> [args...]
+
+Starts the services from the project config (or only the ones named, as
+with roxy run <service>...) on temporary domains such as
+web.test-3f9a1c.my-app.test, waits until each is reachable through the
+proxy, runs the command and stops the services again. roxy exits with the
+command's exit code.
+
+The command gets ROXY_NAMESPACE, and ROXY_<SERVICE>_URL and
+ROXY_<SERVICE>_PORT for each service (e.g. ROXY_WEB_URL).
+
+Flags:
+  --profile <p>   Also start services in profile <p>
+  --timeout <d>   How long to wait for the services to be ready (default: 60s)`
+
+const attachUsage = `Usage:
+  roxy attach <id|domain> [--detach-keys <keys>]
+
+Connects your terminal to a service started with --detach. Input, Ctrl+C
+and window resizes go to the service. Press ctrl-p ctrl-q to detach and
+leave it running.
+
+Flags:
+  --detach-keys <keys>   Detach sequence (default: ctrl-p,ctrl-q)`
+
 const logsUsage = `Usage:
-  roxy logs <id|domain>          Tail logs for a detached process`
+  roxy logs <id|domain>                  Tail logs for a detached process
+  roxy logs --access <id|domain> [flags] Tail the proxy access log for a route
+
+Access log flags:
+  --status <list>        Only show these statuses, e.g. 5xx or 404,429
+  --path <p>             Only show this path prefix or glob (e.g. /api/*)
+  --raw                  Print lines as stored instead of a summary`
+
+const shapeUsage = `Usage:
+  roxy shape <id|domain>                 Show the route's current network simulation
+  roxy shape <id|domain> --profile <p>   Apply a preset (slow-3g, 3g, 4g, lossy)
+  roxy shape <id|domain> --off           Remove network simulation
+
+Flags (override the profile's values):
+  --latency <d>          Delay each request or connection by <d>
+  --jitter <d>           Vary the latency by up to ±<d>
+  --down <kbps>          Cap the response rate (kilobits/s)
+  --up <kbps>            Cap the request rate (kilobits/s)
+  --reset-rate <f>       Reset this fraction (0-1) of requests/connections`
+
+const faultUsage = `Usage:
+  roxy fault add <id|domain> [flags]   Add a fault rule to a route
+  roxy fault list <id|domain>          List a route's fault rules
+  roxy fault clear <id|domain>         Remove all fault rules from a route
+
+Add flags (one action; --status 503 is the default):
+  --status <n>           Respond with error status <n> (400-599)
+  --timeout              Hold the request open without answering
+  --abort                Proxy the request but cut the response off mid-body
+  --delay <d>            With --timeout: give up with a 504 after <d>
+  --after <bytes>        With --abort: let <bytes> of the body through first
+  --rate <f>             Fail this fraction (0-1) of matching requests (default: all)
+  --path <p>             Only match this path prefix or glob (e.g. /api/*)
+  --method <m>           Only match this HTTP method`
 
 const proxyUsage = `Usage:
   roxy proxy start [flags]       Start the proxy server
   roxy proxy stop                Stop the proxy server
   roxy proxy restart [flags]     Restart the proxy server
-  roxy proxy status              Show proxy status
+  roxy proxy status [--json]     Show proxy status
   roxy proxy logs [-a] [-w]      View proxy logs
 
 Flags:
@@ -127,11 +273,14 @@ Flags:
   --proxy-port <n>       HTTP proxy port (default: 80)
   --https-port <n>       HTTPS proxy port (default: 443)
   --dns-port <n>         DNS server port (default: 1299)
-  --tls                  Enable HTTPS`
+  --tls                  Enable HTTPS
+  --access-log <fmt>     Per-route access log format: json (default), common, combined, off
+  --metrics-addr <addr>  Also serve Prometheus metrics on <addr> (always at roxy.test/metrics)`
 
 func runCommand(args []string) error {
 	opts := cmd.RunOptions{}
 	runAll := false
+	var positional, profiles []string
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -157,20 +306,120 @@ func runCommand(args []string) error {
 			opts.TLS = true
 		case "--public":
 			opts.Public = true
+		case "--trust-forwarded":
+			opts.TrustForwarded = true
+		case "--change-origin":
+			opts.ChangeOrigin = true
+		case "--rewrite-origin":
+			opts.RewriteOrigin = true
 		case "-d", "--detach":
 			opts.Detach = true
+		case "--tty":
+			opts.TTY = true
+		case "--no-tui":
+			opts.NoTUI = true
+		case "--abort-on-exit":
+			opts.AbortOnExit = true
+		case "--exit-code-from":
+			if i+1 >= len(args) {
+				die("--exit-code-from requires a service name")
+			}
+			i++
+			opts.ExitCodeFrom = args[i]
+		case "--json":
+			opts.Format = cmd.FormatJSON
+		case "--format":
+			if i+1 >= len(args) {
+				die("--format requires a value")
+			}
+			i++
+			if err := cmd.ValidateFormat(args[i]); err != nil {
+				die(err.Error())
+			}
+			opts.Format = args[i]
 		case "--log-file":
 			if i+1 >= len(args) {
 				die("--log-file requires a value")
 			}
 			i++
 			opts.LogFile = args[i]
+		case "--headers":
+			if i+1 >= len(args) {
+				die("--headers requires a value")
+			}
+			i++
+			opts.Headers = &config.HeaderRules{}
+			if err := json.Unmarshal([]byte(args[i]), opts.Headers); err != nil {
+				die("invalid --headers: " + err.Error())
+			}
+			if err := opts.Headers.Validate(); err != nil {
+				die("invalid --headers: " + err.Error())
+			}
+		case "--cors":
+			if i+1 >= len(args) {
+				die("--cors requires a value")
+			}
+			i++
+			opts.CORS = &config.CORSConfig{}
+			if err := json.Unmarshal([]byte(args[i]), opts.CORS); err != nil {
+				die("invalid --cors: " + err.Error())
+			}
+			if err := opts.CORS.Validate(); err != nil {
+				die("invalid --cors: " + err.Error())
+			}
+		case "--shaping":
+			if i+1 >= len(args) {
+				die("--shaping requires a value")
+			}
+			i++
+			opts.Shaping = &config.ShapingConfig{}
+			if err := json.Unmarshal([]byte(args[i]), opts.Shaping); err != nil {
+				die("invalid --shaping: " + err.Error())
+			}
+			if err := opts.Shaping.Validate(); err != nil {
+				die("invalid --shaping: " + err.Error())
+			}
+		case "--faults":
+			if i+1 >= len(args) {
+				die("--faults requires a value")
+			}
+			i++
+			if err := json.Unmarshal([]byte(args[i]), &opts.Faults); err != nil {
+				die("invalid --faults: " + err.Error())
+			}
+			for j, rule := range opts.Faults {
+				if err := rule.Validate(); err != nil {
+					die(fmt.Sprintf("invalid --faults: faults[%d]: %v", j, err))
+				}
+			}
 		case "--id":
 			if i+1 >= len(args) {
 				die("--id requires a value")
 			}
 			i++
 			opts.ID = args[i]
+		case "--domain":
+			if i+1 >= len(args) {
+				die("--domain requires a value")
+			}
+			i++
+			opts.Domain = args[i]
+		case "--namespace":
+			if i+1 >= len(args) {
+				die("--namespace requires a value")
+			}
+			i++
+			opts.Namespace = args[i]
+		case "--restarts":
+			if i+1 >= len(args) {
+				die("--restarts requires a value")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				die("invalid --restarts: " + args[i])
+			}
+			opts.Restarts = n
 		case "--listen-port":
 			if i+1 >= len(args) {
 				die("--listen-port requires a value")
@@ -181,26 +430,85 @@ func runCommand(args []string) error {
 				die("invalid listen port: " + args[i])
 			}
 			opts.ListenPort = p
-		default:
-			if opts.Command == "" {
-				opts.Command = args[i]
-			} else {
-				die("unexpected argument: " + args[i])
+		case "--protocol":
+			if i+1 >= len(args) {
+				die("--protocol requires a value")
+			}
+			i++
+			if err := config.ValidateProtocol(args[i]); err != nil {
+				die(err.Error())
+			}
+			opts.Protocol = args[i]
+		case "--flush-interval", "--response-header-timeout", "--idle-timeout":
+			flag := args[i]
+			if i+1 >= len(args) {
+				die(flag + " requires a value")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil {
+				die("invalid duration for " + flag + ": " + args[i])
+			}
+			if opts.Streaming == nil {
+				opts.Streaming = &config.StreamingConfig{}
 			}
+			switch flag {
+			case "--flush-interval":
+				opts.Streaming.FlushInterval = config.Duration(d)
+			case "--response-header-timeout":
+				opts.Streaming.ResponseHeaderTimeout = config.Duration(d)
+			case "--idle-timeout":
+				opts.Streaming.IdleTimeout = config.Duration(d)
+			}
+			if err := opts.Streaming.Validate(); err != nil {
+				die(err.Error())
+			}
+		case "--profile":
+			if i+1 >= len(args) {
+				die("--profile requires a value")
+			}
+			i++
+			profiles = append(profiles, strings.Split(args[i], ",")...)
+			runAll = true
+		default:
+			positional = append(positional, args[i])
 		}
 	}
 
-	// roxy run -a / roxy run --all
-	if runAll {
-		cfg, err := config.LoadRoxyJSON(".")
+	// roxy run -a [--profile <p>] / roxy run <service> <service>...
+	if runAll || len(positional) > 1 {
+		if runAll && len(positional) > 0 {
+			die("unexpected argument with --all: " + positional[0] + "\n\n" + runUsage)
+		}
+		if opts.Format != "" {
+			die("--json and --format are not supported with several services; use roxy list --json")
+		}
+		cfg, err := config.LoadRoxyConfig(".")
 		if err != nil {
 			return err
 		}
 		if cfg == nil {
-			die("no roxy.json found in current directory")
+			die("no roxy.json, roxy.yaml or roxy.toml found in current directory")
+		}
+		cfg, err = cfg.Select(positional, profiles)
+		if err != nil {
+			die(err.Error())
+		}
+		opts.Services, opts.Profiles = positional, profiles
+		if opts.Detach && (opts.AbortOnExit || opts.ExitCodeFrom != "") {
+			die("--abort-on-exit and --exit-code-from cannot be used with --detach")
+		}
+		if _, ok := cfg.Services[opts.ExitCodeFrom]; opts.ExitCodeFrom != "" && !ok {
+			die("--exit-code-from: service " + opts.ExitCodeFrom + " is not being started")
 		}
 		return cmd.RunAll(cfg, opts)
 	}
+	if opts.TTY || opts.NoTUI || opts.AbortOnExit || opts.ExitCodeFrom != "" {
+		die("--tty, --no-tui, --abort-on-exit and --exit-code-from are only supported with several services")
+	}
+	if len(positional) == 1 {
+		opts.Command = positional[0]
+	}
 
 	// roxy run (no args) -> show usage
 	if opts.Command == "" && len(args) == 0 {
@@ -212,14 +520,14 @@ func runCommand(args []string) error {
 		die(runUsage)
 	}
 
-	// Single word (no spaces) -> must be a service name from roxy.json.
+	// Single word (no spaces) -> must be a service name from the project config.
 	if !strings.Contains(opts.Command, " ") {
-		cfg, err := config.LoadRoxyJSON(".")
+		cfg, err := config.LoadRoxyConfig(".")
 		if err != nil {
 			return err
 		}
 		if cfg == nil {
-			die(fmt.Sprintf("unknown service %q (no roxy.json found)\n\n%s", opts.Command, runUsage))
+			die(fmt.Sprintf("unknown service %q (no roxy.json, roxy.yaml or roxy.toml found)\n\n%s", opts.Command, runUsage))
 		}
 		if svc, ok := cfg.Services[opts.Command]; ok {
 			return cmd.RunService(opts.Command, svc, opts)
@@ -228,7 +536,7 @@ func runCommand(args []string) error {
 		for name := range cfg.Services {
 			names = append(names, name)
 		}
-		die(fmt.Sprintf("unknown service %q in roxy.json (available: %s)", opts.Command, strings.Join(names, ", ")))
+		die(fmt.Sprintf("unknown service %q in %s (available: %s)", opts.Command, cfg.FileName(), strings.Join(names, ", ")))
 	}
 
 	return cmd.Run(opts)
@@ -255,6 +563,268 @@ func stopCommand(args []string) error {
 	return cmd.Stop(opts)
 }
 
+func shapeCommand(args []string) error {
+	opts := cmd.ShapeOptions{}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--off":
+			opts.Off = true
+		case "--profile":
+			if i+1 >= len(args) {
+				die("--profile requires a value")
+			}
+			i++
+			opts.Shaping.Profile = args[i]
+			opts.Set = true
+		case "--latency", "--jitter":
+			flag := args[i]
+			if i+1 >= len(args) {
+				die(flag + " requires a value")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil {
+				die("invalid duration for " + flag + ": " + args[i])
+			}
+			if flag == "--latency" {
+				opts.Shaping.Latency = config.Duration(d)
+			} else {
+				opts.Shaping.Jitter = config.Duration(d)
+			}
+			opts.Set = true
+		case "--down", "--up":
+			flag := args[i]
+			if i+1 >= len(args) {
+				die(flag + " requires a value")
+			}
+			i++
+			kbps, err := strconv.Atoi(args[i])
+			if err != nil {
+				die("invalid rate for " + flag + ": " + args[i])
+			}
+			if flag == "--down" {
+				opts.Shaping.DownKbps = kbps
+			} else {
+				opts.Shaping.UpKbps = kbps
+			}
+			opts.Set = true
+		case "--reset-rate":
+			if i+1 >= len(args) {
+				die("--reset-rate requires a value")
+			}
+			i++
+			f, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				die("invalid reset rate: " + args[i])
+			}
+			opts.Shaping.ResetRate = f
+			opts.Set = true
+		default:
+			if opts.Target != "" {
+				die("unexpected argument: " + args[i])
+			}
+			opts.Target = args[i]
+		}
+	}
+
+	if opts.Target == "" {
+		die(shapeUsage)
+	}
+	if opts.Off && opts.Set {
+		die("--off cannot be combined with other shaping flags")
+	}
+
+	return cmd.Shape(opts)
+}
+
+func faultCommand(args []string) error {
+	if len(args) < 2 {
+		die(faultUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return cmd.FaultList(args[1])
+	case "clear":
+		return cmd.FaultClear(args[1])
+	case "add":
+	default:
+		die(fmt.Sprintf("unknown fault command: %s\n\n%s", args[0], faultUsage))
+	}
+
+	target := args[1]
+	rule := config.FaultRule{Action: config.FaultError}
+	flagArgs := args[2:]
+	for i := 0; i < len(flagArgs); i++ {
+		flag := flagArgs[i]
+		switch flag {
+		case "--timeout":
+			rule.Action = config.FaultTimeout
+			continue
+		case "--abort":
+			rule.Action = config.FaultAbort
+			continue
+		case "--status", "--delay", "--after", "--rate", "--path", "--method":
+		default:
+			die("unexpected argument: " + flag)
+		}
+
+		if i+1 >= len(flagArgs) {
+			die(flag + " requires a value")
+		}
+		i++
+		value := flagArgs[i]
+		switch flag {
+		case "--status":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				die("invalid status: " + value)
+			}
+			rule.Status = n
+		case "--delay":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				die("invalid duration for --delay: " + value)
+			}
+			rule.Delay = config.Duration(d)
+		case "--after":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				die("invalid byte count for --after: " + value)
+			}
+			rule.AbortAfter = n
+		case "--rate":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				die("invalid rate: " + value)
+			}
+			rule.Rate = f
+		case "--path":
+			rule.Path = value
+		case "--method":
+			rule.Method = strings.ToUpper(value)
+		}
+	}
+
+	if rule.Status != 0 && rule.Action != config.FaultError {
+		die("--status cannot be combined with --timeout or --abort")
+	}
+
+	return cmd.FaultAdd(target, rule)
+}
+
+// testCommand parses `roxy test` arguments.
+func testCommand(args []string) error {
+	opts := cmd.TestOptions{Timeout: cmd.DefaultTestTimeout}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--":
+			opts.Command = args[i+1:]
+			i = len(args)
+		case args[i] == "--profile":
+			if i+1 >= len(args) {
+				die("--profile requires a value")
+			}
+			i++
+			opts.Profiles = append(opts.Profiles, strings.Split(args[i], ",")...)
+		case args[i] == "--timeout":
+			if i+1 >= len(args) {
+				die("--timeout requires a value")
+			}
+			i++
+			d, err := time.ParseDuration(args[i])
+			if err != nil || d <= 0 {
+				die("invalid timeout: " + args[i])
+			}
+			opts.Timeout = d
+		case strings.HasPrefix(args[i], "-"):
+			die("unexpected argument: " + args[i] + "\n\n" + testUsage)
+		default:
+			opts.Services = append(opts.Services, args[i])
+		}
+	}
+	if len(opts.Command) == 0 {
+		die(testUsage)
+	}
+
+	cfg, err := config.LoadRoxyConfig(".")
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		die("no roxy.json, roxy.yaml or roxy.toml found in current directory")
+	}
+	return cmd.Test(cfg, opts)
+}
+
+// attachCommand parses `roxy attach` arguments.
+func attachCommand(args []string) error {
+	target := ""
+	detachKeys := attach.DefaultDetachKeys
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--detach-keys":
+			if i+1 >= len(args) {
+				die("--detach-keys requires a value")
+			}
+			i++
+			if _, err := attach.ParseKeys(args[i]); err != nil {
+				die(err.Error())
+			}
+			detachKeys = args[i]
+		default:
+			if strings.HasPrefix(args[i], "-") || target != "" {
+				die("unexpected argument: " + args[i] + "\n\n" + attachUsage)
+			}
+			target = args[i]
+		}
+	}
+	if target == "" {
+		die(attachUsage)
+	}
+	return cmd.Attach(target, detachKeys)
+}
+
+// logsCommand parses `roxy logs` arguments.
+func logsCommand(args []string) error {
+	var opts cmd.AccessLogOptions
+	access := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--access":
+			access = true
+		case "--status", "--path":
+			if i+1 >= len(args) {
+				die(args[i] + " requires a value")
+			}
+			if args[i] == "--status" {
+				opts.Status = args[i+1]
+			} else {
+				opts.Path = args[i+1]
+			}
+			i++
+		case "--raw":
+			opts.Raw = true
+		default:
+			if strings.HasPrefix(args[i], "-") || opts.Target != "" {
+				die("unexpected argument: " + args[i])
+			}
+			opts.Target = args[i]
+		}
+	}
+	if opts.Target == "" {
+		die(logsUsage)
+	}
+	if !access {
+		if opts.Status != "" || opts.Path != "" || opts.Raw {
+			die("--status, --path and --raw require --access")
+		}
+		return cmd.Logs(opts.Target)
+	}
+	return cmd.AccessLogs(opts)
+}
+
 // proxyCommand handles proxy subcommands.
 func proxyCommand(args []string) error {
 	if len(args) == 0 {
@@ -268,7 +838,7 @@ func proxyCommand(args []string) error {
 	case "stop":
 		return cmd.ProxyStop()
 	case "status":
-		return cmd.ProxyStatus()
+		return cmd.ProxyStatus(formatArgs(subArgs, proxyUsage))
 	case "logs":
 		printAll := false
 		watch := false
@@ -329,6 +899,21 @@ func proxyCommand(args []string) error {
 				die("invalid port: " + subArgs[i])
 			}
 			opts.DNSPort = p
+		case "--access-log":
+			if i+1 >= len(subArgs) {
+				die("--access-log requires a value")
+			}
+			i++
+			if err := proxy.ValidateAccessLogFormat(subArgs[i]); err != nil {
+				die(err.Error())
+			}
+			opts.AccessLog = subArgs[i]
+		case "--metrics-addr":
+			if i+1 >= len(subArgs) {
+				die("--metrics-addr requires a value")
+			}
+			i++
+			opts.MetricsAddr = subArgs[i]
 		default:
 			die("unexpected argument: " + subArgs[i])
 		}
@@ -354,7 +939,7 @@ func proxyCommand(args []string) error {
 
 const tunnelUsage = `Usage:
   roxy tunnel set              Choose a tunnel provider
-  roxy tunnel status           Show current tunnel configuration`
+  roxy tunnel status [--json]  Show current tunnel configuration`
 
 func tunnelCommand(args []string) error {
 	if len(args) == 0 {
@@ -365,14 +950,39 @@ func tunnelCommand(args []string) error {
 	case "set":
 		return cmd.TunnelSet()
 	case "status":
-		return cmd.TunnelStatus()
+		return cmd.TunnelStatus(formatArgs(args[1:], tunnelUsage))
 	default:
 		die(fmt.Sprintf("unknown tunnel command: %s\n\n%s", args[0], tunnelUsage))
 		return nil
 	}
 }
 
+// formatArgs parses the --json and --format flags of commands that take no
+// other arguments.
+func formatArgs(args []string, usage string) string {
+	format := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--json":
+			format = cmd.FormatJSON
+		case "--format":
+			if i+1 >= len(args) {
+				die("--format requires a value")
+			}
+			i++
+			if err := cmd.ValidateFormat(args[i]); err != nil {
+				die(err.Error())
+			}
+			format = args[i]
+		default:
+			die("unexpected argument: " + args[i] + "\n\n" + usage)
+		}
+	}
+	return format
+}
+
+// die reports a usage error and exits with exitUsage.
 func die(msg string) {
 	fmt.Fprintln(os.Stderr, msg)
-	os.Exit(1)
+	os.Exit(exitUsage)
 }
@@ -19,18 +19,34 @@ func GenerateID(domain string) string {
 
 // Route represents an active tunnel route.
 type Route struct {
-	ID         string    `json:"id"`
-	Domain     string    `json:"domain"`
-	Port       int       `json:"port"`                  // upstream service port
-	ListenPort int       `json:"listen_port,omitempty"` // proxy listen port (TCP routes only)
-	Type       string    `json:"type"`                  // "http" (default) or "tcp"
-	TLS        bool      `json:"tls"`                   // serve this route over HTTPS
-	Command    string    `json:"command"`
-	PID        int       `json:"pid"`
-	LogFile    string    `json:"log_file,omitempty"`   // stdout/stderr log for detached processes
-	Public     bool      `json:"public,omitempty"`     // tunnel is active for this route
-	PublicURL  string    `json:"public_url,omitempty"` // public tunnel URL (e.g. https://abc123.ngrok-free.app)
-	Created    time.Time `json:"created"`
+	ID           string    `json:"id"`
+	Domain       string    `json:"domain"`
+	Port         int       `json:"port"`                  // upstream service port
+	ListenPort   int       `json:"listen_port,omitempty"` // proxy listen port (TCP routes only)
+	Type         string    `json:"type"`                  // "http" (default) or "tcp"
+	TLS          bool      `json:"tls"`                   // serve this route over HTTPS
+	Command      string    `json:"command"`
+	PID          int       `json:"pid"`
+	LogFile      string    `json:"log_file,omitempty"`      // stdout/stderr log for detached processes
+	OutputFile   string    `json:"output_file,omitempty"`   // recent output of foreground processes
+	AttachSocket string    `json:"attach_socket,omitempty"` // `roxy attach` socket of detached processes
+	Public       bool      `json:"public,omitempty"`        // tunnel is active for this route
+	PublicURL    string    `json:"public_url,omitempty"`    // public tunnel URL (e.g. https://abc123.ngrok-free.app)
+	Created      time.Time `json:"created"`
+	Dir          string    `json:"dir,omitempty"`       // working directory the command was started from
+	Restarts     int       `json:"restarts,omitempty"`  // times the service was restarted from roxy
+	Namespace    string    `json:"namespace,omitempty"` // set on the temporary routes of `roxy test`
+
+	// Per-route proxy behaviour, from roxy.json or `roxy run` flags.
+	Protocol       string           `json:"protocol,omitempty"`        // upstream protocol: "http" (default) or "h2c"
+	TrustForwarded bool             `json:"trust_forwarded,omitempty"` // keep incoming X-Forwarded-*/Forwarded chains
+	ChangeOrigin   bool             `json:"change_origin,omitempty"`   // send Host: localhost:<port> upstream
+	RewriteOrigin  bool             `json:"rewrite_origin,omitempty"`  // also rewrite same-site Origin/Referer (implies ChangeOrigin)
+	Streaming      *StreamingConfig `json:"streaming,omitempty"`       // flush interval and upstream timeouts
+	Headers        *HeaderRules     `json:"headers,omitempty"`         // request/response header edits
+	CORS           *CORSConfig      `json:"cors,omitempty"`            // answer preflights and add CORS headers
+	Shaping        *ShapingConfig   `json:"shaping,omitempty"`         // simulated latency, bandwidth and resets
+	Faults         []FaultRule      `json:"faults,omitempty"`          // injected errors, timeouts and aborts
 }
 
 // Store manages the routes.json file.
@@ -85,6 +101,31 @@ func (s *Store) AddRoute(route Route) error {
 	return s.saveUnsafe(routes)
 }
 
+// PutRoute adds a route, replacing any route with the same domain. It is
+// how a restarted service takes its route back over.
+func (s *Store) PutRoute(route Route) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if route.Type == "" {
+		route.Type = "http"
+	}
+
+	routes, err := s.loadUnsafe()
+	if err != nil {
+		return err
+	}
+
+	for i := range routes {
+		if routes[i].Domain == route.Domain {
+			routes[i] = route
+			return s.saveUnsafe(routes)
+		}
+	}
+	routes = append(routes, route)
+	return s.saveUnsafe(routes)
+}
+
 // UpdateRoute atomically updates a route by domain, applying the given function.
 func (s *Store) UpdateRoute(domain string, fn func(*Route)) error {
 	s.mu.Lock()
@@ -125,6 +166,29 @@ func (s *Store) RemoveRoute(domain string) error {
 	return s.saveUnsafe(filtered)
 }
 
+// ReleaseRoute removes the route for domain if it still belongs to pid. A
+// route whose PID has changed was taken over by a restart and is kept.
+func (s *Store) ReleaseRoute(domain string, pid int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	routes, err := s.loadUnsafe()
+	if err != nil {
+		return err
+	}
+
+	var filtered []Route
+	for _, r := range routes {
+		if r.Domain != domain || r.PID != pid {
+			filtered = append(filtered, r)
+		}
+	}
+	if len(filtered) == len(routes) {
+		return nil
+	}
+	return s.saveUnsafe(filtered)
+}
+
 // PruneStaleRoutes removes routes whose PID is no longer alive.
 // Returns the number of routes pruned.
 func (s *Store) PruneStaleRoutes() (int, error) {
@@ -209,6 +273,11 @@ func (s *Store) ResolveRoute(input string) (*Route, error) {
 	return nil, fmt.Errorf("no route matching %q", input)
 }
 
+// Alive reports whether the route's process is running.
+func (r Route) Alive() bool {
+	return r.PID > 0 && processAlive(r.PID)
+}
+
 // processAlive checks if a process with the given PID is still running.
 func processAlive(pid int) bool {
 	proc, err := os.FindProcess(pid)
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePutRoute(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "routes.json"))
+	if err := store.AddRoute(Route{ID: "a1", Domain: "web.app.test", Port: 3000, PID: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddRoute(Route{ID: "b2", Domain: "api.app.test", Port: 3001, PID: 101}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same domain: replaced in place.
+	if err := store.PutRoute(Route{ID: "a1", Domain: "web.app.test", Port: 3000, PID: 200}); err != nil {
+		t.Fatal(err)
+	}
+	// New domain: appended.
+	if err := store.PutRoute(Route{ID: "c3", Domain: "db.app.test", Port: 5432}); err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := store.LoadRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("routes = %+v", routes)
+	}
+	if routes[0].Domain != "web.app.test" || routes[0].PID != 200 {
+		t.Errorf("replaced route = %+v", routes[0])
+	}
+	if routes[2].Domain != "db.app.test" || routes[2].Type != "http" {
+		t.Errorf("appended route = %+v", routes[2])
+	}
+}
+
+func TestStoreReleaseRoute(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "routes.json"))
+	if err := store.AddRoute(Route{ID: "a1", Domain: "web.app.test", Port: 3000, PID: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Taken over by another process: kept.
+	if err := store.ReleaseRoute("web.app.test", 99); err != nil {
+		t.Fatal(err)
+	}
+	if store.FindRoute("web.app.test") == nil {
+		t.Fatal("route released by a process that does not own it")
+	}
+
+	if err := store.ReleaseRoute("web.app.test", 100); err != nil {
+		t.Fatal(err)
+	}
+	if r := store.FindRoute("web.app.test"); r != nil {
+		t.Errorf("route not released: %+v", r)
+	}
+}
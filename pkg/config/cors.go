@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultCORSMethods are allowed when a CORS policy lists no methods.
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// CORSConfig is a per-route CORS policy. The proxy answers preflight
+// requests itself and adds CORS headers to the service's responses, so
+// the service needs no dev-only CORS setup.
+type CORSConfig struct {
+	// Origins lists the allowed origins. Entries are exact origins
+	// ("http://web.my-app.test"), hosts without a scheme ("web.my-app.test"),
+	// wildcard subdomains ("*.test", "https://*.my-app.test") or "*".
+	Origins []string `json:"origins"`
+	// Methods allowed on cross-origin requests. Defaults to DefaultCORSMethods.
+	Methods []string `json:"methods,omitempty"`
+	// Headers allowed on cross-origin requests. When empty, whatever the
+	// preflight asks for is allowed.
+	Headers []string `json:"headers,omitempty"`
+	// ExposeHeaders are response headers readable by the calling script.
+	ExposeHeaders []string `json:"expose-headers,omitempty"`
+	// Credentials allows cookies and HTTP auth on cross-origin requests.
+	Credentials bool `json:"credentials,omitempty"`
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge Duration `json:"max-age,omitempty"`
+}
+
+// Validate checks origins, methods and header names.
+func (c *CORSConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Origins) == 0 {
+		return fmt.Errorf("cors.origins must list at least one origin")
+	}
+	for _, o := range c.Origins {
+		if err := validateOriginPattern(o); err != nil {
+			return fmt.Errorf("cors.origins: %w", err)
+		}
+	}
+	for _, m := range c.Methods {
+		if !validHeaderName(m) || strings.ToUpper(m) != m {
+			return fmt.Errorf("cors.methods: invalid method %q", m)
+		}
+	}
+	for _, h := range c.Headers {
+		if !validHeaderName(h) {
+			return fmt.Errorf("cors.headers: invalid header name %q", h)
+		}
+	}
+	for _, h := range c.ExposeHeaders {
+		if !validHeaderName(h) {
+			return fmt.Errorf("cors.expose-headers: invalid header name %q", h)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors.max-age must not be negative")
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether origin (the request's Origin header) is
+// permitted by the policy.
+func (c *CORSConfig) AllowsOrigin(origin string) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok {
+		return false
+	}
+	for _, pattern := range c.Origins {
+		if matchOrigin(pattern, scheme, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches one origin pattern against an origin's scheme and
+// host[:port]. Patterns without a port ignore the origin's port.
+func matchOrigin(pattern, scheme, host string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if ps, ph, ok := strings.Cut(pattern, "://"); ok {
+		if !strings.EqualFold(ps, scheme) {
+			return false
+		}
+		pattern = ph
+	}
+	if !strings.Contains(pattern, ":") {
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(host) > len(suffix) && strings.HasSuffix(strings.ToLower(host), strings.ToLower(suffix))
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+func validateOriginPattern(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	host := pattern
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("invalid origin %q: scheme must be http or https", pattern)
+		}
+		host = rest
+	}
+	if host == "" || strings.ContainsAny(host, "/?# ") {
+		return fmt.Errorf("invalid origin %q", pattern)
+	}
+	if i := strings.Index(host, "*"); i >= 0 && (i != 0 || !strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+		return fmt.Errorf("invalid origin %q: wildcards are only allowed as a leading \"*.\"", pattern)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCORSConfigAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		origin   string
+		want     bool
+	}{
+		{[]string{"*"}, "https://anything.example", true},
+		{[]string{"*.test"}, "http://web.my-app.test", true},
+		{[]string{"*.test"}, "http://web.my-app.test:3000", true},
+		{[]string{"*.test"}, "http://example.com", false},
+		{[]string{"*.my-app.test"}, "http://my-app.test", false},
+		{[]string{"https://*.my-app.test"}, "http://web.my-app.test", false},
+		{[]string{"https://*.my-app.test"}, "https://web.my-app.test", true},
+		{[]string{"web.my-app.test"}, "https://WEB.my-app.test", true},
+		{[]string{"http://localhost:3000"}, "http://localhost:3000", true},
+		{[]string{"http://localhost:3000"}, "http://localhost:5173", false},
+		{[]string{"*"}, "null", false},
+		{[]string{"*"}, "", false},
+	}
+	for _, tt := range tests {
+		cfg := &CORSConfig{Origins: tt.patterns}
+		if got := cfg.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("%v.AllowsOrigin(%q) = %v, want %v", tt.patterns, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     CORSConfig
+		wantErr string
+	}{
+		{"valid", CORSConfig{Origins: []string{"*.test", "https://app.example:8443"}, Methods: []string{"GET"}}, ""},
+		{"no origins", CORSConfig{}, "at least one origin"},
+		{"bad wildcard", CORSConfig{Origins: []string{"web.*.test"}}, "wildcards"},
+		{"bad scheme", CORSConfig{Origins: []string{"ftp://files.test"}}, "scheme"},
+		{"path", CORSConfig{Origins: []string{"http://web.test/app"}}, "invalid origin"},
+		{"lowercase method", CORSConfig{Origins: []string{"*"}, Methods: []string{"get"}}, "invalid method"},
+		{"bad header", CORSConfig{Origins: []string{"*"}, Headers: []string{"X Token"}}, "invalid header name"},
+		{"negative max-age", CORSConfig{Origins: []string{"*"}, MaxAge: -1}, "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Extends lists the files a project config builds on: paths, relative to
+// the file, or names of templates in ~/.config/roxy/templates. A single
+// path may be given as a string.
+type Extends []string
+
+// UnmarshalJSON accepts a string or a list of strings.
+func (e *Extends) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*e = Extends{one}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("extends must be a path or a list of paths")
+	}
+	*e = list
+	return nil
+}
+
+// templatesDir is where extends looks up names that are not paths.
+func templatesDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "roxy", "templates")
+}
+
+// layers is a project config merged with the files it extends, before
+// defaults are applied. Definitions are kept as decoded JSON so that a later file
+// can override any value, including setting a boolean back to false.
+type layers struct {
+	schema   string
+	services map[string]any
+	defaults map[string]any
+
+	// Where definitions came from, in order, as "file:line:col", for
+	// error messages.
+	sources        map[string][]string // by service name
+	defaultSources []string
+}
+
+// merge applies over on top of l.
+func (l *layers) merge(over *layers) {
+	for name, svc := range over.services {
+		l.services[name] = mergeJSON(l.services[name], svc)
+		l.sources[name] = appendNew(l.sources[name], over.sources[name]...)
+	}
+	if over.defaults != nil {
+		l.defaults, _ = mergeJSON(l.defaults, over.defaults).(map[string]any)
+		l.defaultSources = appendNew(l.defaultSources, over.defaultSources...)
+	}
+}
+
+// resolve applies the defaults to every service and returns the config.
+func (l *layers) resolve() (*RoxyConfig, error) {
+	services := make(map[string]any, len(l.services))
+	for name, svc := range l.services {
+		services[name] = mergeJSON(l.defaults, svc)
+	}
+	data, err := json.Marshal(map[string]any{"services": services})
+	if err != nil {
+		return nil, err
+	}
+	cfg := &RoxyConfig{Schema: l.schema}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// origin describes where a service's definition came from.
+func (l *layers) origin(name string) string {
+	return "defined at " + strings.Join(appendNew(slices.Clone(l.sources[name]), l.defaultSources...), ", ")
+}
+
+// mergeJSON merges decoded JSON values: objects key by key, recursively;
+// anything else in over replaces base.
+func mergeJSON(base, over any) any {
+	b, ok := base.(map[string]any)
+	o, ok2 := over.(map[string]any)
+	if !ok || !ok2 {
+		return over
+	}
+	out := make(map[string]any, len(b)+len(o))
+	maps.Copy(out, b)
+	for k, v := range o {
+		out[k] = mergeJSON(b[k], v)
+	}
+	return out
+}
+
+func appendNew(list []string, items ...string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loader reads a project config and the files it extends.
+type loader struct {
+	dir   string   // directory of the top-level file
+	files []string // files read so far
+}
+
+// load reads the file at path and merges it over the files it extends.
+// stack holds the files that extend it, to report cycles.
+func (ld *loader) load(path string, stack []string) (*layers, error) {
+	name := ld.display(path)
+	if i := slices.Index(stack, path); i >= 0 {
+		chain := make([]string, 0, len(stack)-i+1)
+		for _, p := range stack[i:] {
+			chain = append(chain, ld.display(p))
+		}
+		return nil, fmt.Errorf("extends cycle: %s -> %s", strings.Join(chain, " -> "), name)
+	}
+	var extendedBy string
+	if len(stack) > 0 {
+		extendedBy = " (extended by " + ld.display(stack[len(stack)-1]) + ")"
+	}
+	where := name + extendedBy
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ld.files = appendNew(ld.files, path)
+	doc, err := parseDocument(path, data)
+	if err != nil {
+		var syntaxErr *syntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("parse %s:%d:%d%s: %s", name, syntaxErr.pos.line, syntaxErr.pos.col, extendedBy, syntaxErr.msg)
+		}
+		return nil, fmt.Errorf("parse %s: %w", where, err)
+	}
+	var cfg RoxyConfig
+	if err := doc.check(&cfg); err != nil {
+		var pathErr *pathError
+		if errors.As(err, &pathErr) {
+			return nil, fmt.Errorf("parse %s%s: %s", doc.at(name, pathErr.path), extendedBy, pathErr.msg)
+		}
+		return nil, fmt.Errorf("parse %s: %w", where, err)
+	}
+
+	// These can't be shared by several services.
+	if d := cfg.Defaults; d != nil {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{{"cmd", d.Cmd != ""}, {"name", d.Name != ""}, {"listen-port", d.ListenPort != 0}} {
+			if f.set {
+				return nil, fmt.Errorf("%s%s: defaults: %s must be set on each service", doc.at(name, "defaults."+f.name), extendedBy, f.name)
+			}
+		}
+	}
+
+	l := &layers{services: make(map[string]any), sources: make(map[string][]string)}
+	for _, ext := range cfg.Extends {
+		extPath, err := ld.find(ext, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: extends %q: %w", where, ext, err)
+		}
+		base, err := ld.load(extPath, append(slices.Clip(stack), path))
+		if err != nil {
+			return nil, err
+		}
+		l.merge(base)
+	}
+
+	services, _ := doc.values["services"].(map[string]any)
+	defaults, _ := doc.values["defaults"].(map[string]any)
+	own := &layers{services: services, defaults: defaults, sources: make(map[string][]string)}
+	for svcName := range services {
+		own.sources[svcName] = []string{doc.at(name, "services."+svcName)}
+	}
+	if defaults != nil {
+		own.defaultSources = []string{doc.at(name, "defaults")}
+	}
+	l.merge(own)
+	l.schema = cfg.Schema
+	return l, nil
+}
+
+// find returns the file an extends entry refers to. Paths start with
+// ".", "/" or "~" or contain a slash; a directory means the project config
+// in it. Anything else is a template name; without an extension, the
+// first of name.yaml, name.yml, name.toml and name.json is used.
+func (ld *loader) find(ext, dir string) (string, error) {
+	var path string
+	switch {
+	case ext == "":
+		return "", fmt.Errorf("empty path")
+	case strings.HasPrefix(ext, "~/"):
+		path = filepath.Join(os.Getenv("HOME"), ext[2:])
+	case filepath.IsAbs(ext):
+		path = ext
+	case strings.HasPrefix(ext, ".") || strings.Contains(ext, "/"):
+		path = filepath.Join(dir, ext)
+	default:
+		path = filepath.Join(templatesDir(), ext)
+		candidates := []string{path}
+		if filepath.Ext(ext) == "" {
+			candidates = candidates[:0]
+			for _, e := range configExts {
+				candidates = append(candidates, path+e)
+			}
+		}
+		for _, c := range candidates {
+			if _, err := os.Stat(c); err == nil {
+				return c, nil
+			}
+		}
+		return "", fmt.Errorf("no template %q in %s", ext, ld.display(templatesDir()))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("%s not found", ld.display(path))
+	}
+	if info.IsDir() {
+		return findConfigIn(path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// display shortens path for messages: relative to the top-level
+// file's directory, or starting with ~ if in the home directory.
+func (ld *loader) display(path string) string {
+	if rel, err := filepath.Rel(ld.dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		if rel, err := filepath.Rel(home, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join("~", rel)
+		}
+	}
+	return path
+}
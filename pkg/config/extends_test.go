@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestLoadRoxyConfig_Extends(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(home, ".config", "roxy", "templates", "postgres.json"), `{
+	  "services": {
+	    "db": {"cmd": "docker run --rm -p $PORT:5432 postgres:16", "listen-port": 5432}
+	  }
+	}`)
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "shared", "roxy.json"), `{
+	  "extends": "postgres",
+	  "defaults": {"tls": true, "headers": {"request": {"set": {"X-Env": "dev", "X-Team": "core"}}}},
+	  "services": {
+	    "api": {"cmd": "go run ./api", "port": 4000}
+	  }
+	}`)
+	dir := filepath.Join(root, "app")
+	writeFile(t, filepath.Join(dir, "roxy.json"), `{
+	  "extends": ["../shared"],
+	  "defaults": {"headers": {"request": {"set": {"X-Env": "local"}}}},
+	  "services": {
+	    "api": {"port": 5000},
+	    "db": {"tls": false},
+	    "web": {"cmd": "npm run dev"}
+	  }
+	}`)
+
+	cfg, err := LoadRoxyConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadRoxyConfig returned error: %v", err)
+	}
+	if got := strings.Join(cfg.serviceNames(), ","); got != "api,db,web" {
+		t.Fatalf("services = %s, want api,db,web", got)
+	}
+
+	api := cfg.Services["api"]
+	if api.Cmd != "go run ./api" || api.Port != 5000 || !api.TLS {
+		t.Errorf("api = %+v, want the shared cmd, port 5000 and tls from defaults", api)
+	}
+	set := api.Headers.Request.Set
+	if set["X-Env"] != "local" || set["X-Team"] != "core" {
+		t.Errorf("api request headers = %v, want defaults merged key by key", set)
+	}
+
+	db := cfg.Services["db"]
+	if db.TLS || db.ListenPort != 5432 || !strings.HasPrefix(db.Cmd, "docker run") {
+		t.Errorf("db = %+v, want the template with tls turned off", db)
+	}
+	if !cfg.Services["web"].TLS {
+		t.Error("web did not get tls from defaults")
+	}
+
+	wantFiles := []string{
+		filepath.Join(dir, "roxy.json"),
+		filepath.Join(root, "shared", "roxy.json"),
+		filepath.Join(home, ".config", "roxy", "templates", "postgres.json"),
+	}
+	if got := cfg.Files(); !slices.Equal(got, wantFiles) {
+		t.Errorf("Files() = %v, want %v", got, wantFiles)
+	}
+}
+
+func TestLoadRoxyConfig_ExtendsErrors(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(home, ".config", "roxy", "templates", "redis.json"), `{
+	  "services": {"cache": {"cmd": "redis-server", "port": 70000}}
+	}`)
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "provenance",
+			files:   map[string]string{"roxy.json": `{"extends": "redis"}`},
+			wantErr: `service "cache": port must be between 1 and 65535 (defined at ~/.config/roxy/templates/redis.json:2:17)`,
+		},
+		{
+			name:    "missing template",
+			files:   map[string]string{"roxy.json": `{"extends": "mysql"}`},
+			wantErr: `roxy.json: extends "mysql": no template "mysql" in ~/.config/roxy/templates`,
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"roxy.json": `{"extends": "./base.json"}`,
+				"base.json": `{"extends": "./roxy.json"}`,
+			},
+			wantErr: "extends cycle: roxy.json -> base.json -> roxy.json",
+		},
+		{
+			name: "unknown field in extended file",
+			files: map[string]string{
+				"roxy.json": `{"extends": "./base.json", "services": {"web": {"cmd": "npm start"}}}`,
+				"base.json": `{"services": {"web": {"tsl": true}}}`,
+			},
+			wantErr: `parse base.json:1:23 (extended by roxy.json): unknown field "tsl" in services.web`,
+		},
+		{
+			name:    "cmd in defaults",
+			files:   map[string]string{"roxy.json": `{"defaults": {"cmd": "npm start"}, "services": {}}`},
+			wantErr: "roxy.json:1:15: defaults: cmd must be set on each service",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(dir, name), content)
+			}
+			_, err := LoadRoxyConfig(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Fault actions.
+const (
+	// FaultError answers with an error status instead of proxying.
+	FaultError = "error"
+	// FaultTimeout holds the request open without answering.
+	FaultTimeout = "timeout"
+	// FaultAbort proxies the request but cuts the response off mid-body.
+	FaultAbort = "abort"
+)
+
+// defaultFaultStatus is used by FaultError rules without a status.
+const defaultFaultStatus = http.StatusServiceUnavailable
+
+// FaultRule injects a failure into matching requests on a route. Rules are
+// checked in order and the first one that fires wins.
+type FaultRule struct {
+	// Action is FaultError, FaultTimeout or FaultAbort.
+	Action string `json:"action"`
+	// Path limits the rule to request paths with this prefix, or matching
+	// this glob when it contains *, ? or [. Empty matches every path.
+	Path string `json:"path,omitempty"`
+	// Method limits the rule to one HTTP method. Empty matches every method.
+	Method string `json:"method,omitempty"`
+	// Rate is the probability (0-1) that a matching request fails.
+	// Zero means every matching request.
+	Rate float64 `json:"rate,omitempty"`
+	// Status is the response code for FaultError (default 503).
+	Status int `json:"status,omitempty"`
+	// Delay is how long FaultTimeout waits before giving up with a 504.
+	// Zero waits until the client disconnects.
+	Delay Duration `json:"delay,omitempty"`
+	// AbortAfter is how many body bytes FaultAbort lets through. Zero cuts
+	// the body in half when its length is known, otherwise right away.
+	AbortAfter int `json:"abort-after,omitempty"`
+}
+
+// StatusCode returns the status a FaultError rule responds with.
+func (f FaultRule) StatusCode() int {
+	if f.Status == 0 {
+		return defaultFaultStatus
+	}
+	return f.Status
+}
+
+// Matches reports whether the rule applies to a request with this method
+// and path. It does not roll the dice for Rate.
+func (f FaultRule) Matches(method, urlPath string) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, method) {
+		return false
+	}
+	if f.Path == "" {
+		return true
+	}
+	if strings.ContainsAny(f.Path, "*?[") {
+		ok, _ := path.Match(f.Path, urlPath)
+		return ok
+	}
+	return strings.HasPrefix(urlPath, f.Path)
+}
+
+// String describes the rule, e.g. "503 on POST /api/* (20%)".
+func (f FaultRule) String() string {
+	var s string
+	switch f.Action {
+	case FaultError:
+		s = fmt.Sprintf("%d", f.StatusCode())
+	case FaultTimeout:
+		s = "timeout"
+		if f.Delay > 0 {
+			s += " after " + time.Duration(f.Delay).String()
+		}
+	case FaultAbort:
+		s = "abort mid-body"
+		if f.AbortAfter > 0 {
+			s = fmt.Sprintf("abort after %d bytes", f.AbortAfter)
+		}
+	default:
+		s = f.Action
+	}
+
+	target := "*"
+	if f.Path != "" {
+		target = f.Path
+	}
+	if f.Method != "" {
+		target = strings.ToUpper(f.Method) + " " + target
+	}
+	s += " on " + target
+
+	if f.Rate > 0 && f.Rate < 1 {
+		s += fmt.Sprintf(" (%g%%)", f.Rate*100)
+	}
+	return s
+}
+
+// Validate checks the action and its parameters.
+func (f FaultRule) Validate() error {
+	switch f.Action {
+	case FaultError:
+		// 1xx would leave the response unfinished, and 2xx or 3xx are
+		// not errors.
+		if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+			return fmt.Errorf("fault status must be an error status between 400 and 599, got %d", f.Status)
+		}
+	case FaultTimeout, FaultAbort:
+	default:
+		return fmt.Errorf("fault action must be %q, %q or %q, got %q", FaultError, FaultTimeout, FaultAbort, f.Action)
+	}
+	if f.Rate < 0 || f.Rate > 1 {
+		return fmt.Errorf("fault rate must be between 0 and 1")
+	}
+	if f.Delay < 0 {
+		return fmt.Errorf("fault delay must not be negative")
+	}
+	if f.AbortAfter < 0 {
+		return fmt.Errorf("fault abort-after must not be negative")
+	}
+	if f.Path != "" {
+		if !strings.HasPrefix(f.Path, "/") {
+			return fmt.Errorf("fault path must start with /, got %q", f.Path)
+		}
+		if _, err := path.Match(f.Path, "/"); err != nil {
+			return fmt.Errorf("fault path %q: %w", f.Path, err)
+		}
+	}
+	if f.Method != "" && !validHeaderName(f.Method) {
+		return fmt.Errorf("fault method %q is not a valid HTTP method", f.Method)
+	}
+	return nil
+}
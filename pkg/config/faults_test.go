@@ -0,0 +1,79 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule         FaultRule
+		method, path string
+		want         bool
+	}{
+		{FaultRule{}, "GET", "/anything", true},
+		{FaultRule{Path: "/api"}, "GET", "/api/users", true},
+		{FaultRule{Path: "/api"}, "GET", "/web", false},
+		{FaultRule{Path: "/api/*/items"}, "GET", "/api/42/items", true},
+		{FaultRule{Path: "/api/*/items"}, "GET", "/api/42/other", false},
+		{FaultRule{Method: "POST"}, "post", "/", true},
+		{FaultRule{Method: "POST"}, "GET", "/", false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.Matches(tt.method, tt.path); got != tt.want {
+			t.Errorf("%+v.Matches(%q, %q) = %v, want %v", tt.rule, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFaultRuleString(t *testing.T) {
+	tests := []struct {
+		rule FaultRule
+		want string
+	}{
+		{FaultRule{Action: FaultError}, "503 on *"},
+		{FaultRule{Action: FaultError, Status: 500, Method: "post", Path: "/api", Rate: 0.2}, "500 on POST /api (20%)"},
+		{FaultRule{Action: FaultTimeout, Delay: Duration(30 * time.Second)}, "timeout after 30s on *"},
+		{FaultRule{Action: FaultAbort, AbortAfter: 100}, "abort after 100 bytes on *"},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestFaultRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    FaultRule
+		wantErr string
+	}{
+		{"error", FaultRule{Action: FaultError, Status: 500}, ""},
+		{"timeout", FaultRule{Action: FaultTimeout, Path: "/api/*"}, ""},
+		{"unknown action", FaultRule{Action: "explode"}, "fault action must be"},
+		{"bad status", FaultRule{Action: FaultError, Status: 999}, "between 400 and 599"},
+		{"informational status", FaultRule{Action: FaultError, Status: 101}, "between 400 and 599"},
+		{"success status", FaultRule{Action: FaultError, Status: 200}, "between 400 and 599"},
+		{"client error", FaultRule{Action: FaultError, Status: 429}, ""},
+		{"bad rate", FaultRule{Action: FaultAbort, Rate: 2}, "between 0 and 1"},
+		{"relative path", FaultRule{Action: FaultError, Path: "api"}, "must start with /"},
+		{"bad glob", FaultRule{Action: FaultError, Path: "/api/[x"}, "syntax error"},
+		{"bad method", FaultRule{Action: FaultError, Method: "GE T"}, "not a valid HTTP method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
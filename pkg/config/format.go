@@ -0,0 +1,486 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// RoxyConfigFiles are the names the project config may have, in the order
+// they are looked for. Only one of them may exist.
+var RoxyConfigFiles = []string{"roxy.yaml", "roxy.yml", "roxy.toml", "roxy.json"}
+
+// FindRoxyConfig returns the path of the project config in dir, or "" if
+// there is none.
+func FindRoxyConfig(dir string) (string, error) {
+	var found []string
+	for _, name := range RoxyConfigFiles {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	}
+	return "", fmt.Errorf("found %s in %s; keep only one", strings.Join(found, " and "), dir)
+}
+
+// position is a line and column in a config file, both counted from 1.
+type position struct {
+	line, col int
+}
+
+// document is a config file decoded into plain values (maps, slices,
+// strings, numbers and booleans), the same whatever its format, with the
+// position of each key.
+type document struct {
+	values map[string]any
+	pos    map[string]position // by key path, e.g. "services.web.port"
+}
+
+// syntaxError is a parse error at a position in the file.
+type syntaxError struct {
+	pos position
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.pos.line, e.pos.col, e.msg)
+}
+
+// pathError is an error about the value at a key path of a document.
+type pathError struct {
+	path string
+	msg  string
+}
+
+func (e *pathError) Error() string {
+	if e.path == "" {
+		return e.msg
+	}
+	return e.path + ": " + e.msg
+}
+
+// parseDocument parses a config file in the format its extension names:
+// .yaml or .yml, .toml, and JSON otherwise.
+func parseDocument(path string, data []byte) (*document, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	case ".toml":
+		return parseTOML(data)
+	}
+	return parseJSON(data)
+}
+
+// at returns where the key at path is, as "file:line:col". Without a
+// position for it, it falls back to its parent, then to just file.
+func (d *document) at(file, path string) string {
+	for {
+		if p, ok := d.pos[path]; ok {
+			return fmt.Sprintf("%s:%d:%d", file, p.line, p.col)
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return file
+		}
+		path = path[:i]
+	}
+}
+
+// check reports keys the config has no field for, like
+// json.Decoder.DisallowUnknownFields, and then decodes the document into
+// cfg. Errors are *pathErrors.
+func (d *document) check(cfg *RoxyConfig) error {
+	if path := unknownField(d.values, reflect.TypeOf(cfg), ""); path != "" {
+		i := strings.LastIndex(path, ".")
+		msg := fmt.Sprintf("unknown field %q", path[i+1:])
+		if i >= 0 {
+			msg += " in " + path[:i]
+		}
+		return &pathError{path: path, msg: msg}
+	}
+
+	data, err := json.Marshal(d.values)
+	if err != nil {
+		return &pathError{msg: err.Error()}
+	}
+	err = json.Unmarshal(data, cfg)
+	if err == nil {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		msg := fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)
+		if typeErr.Field != "" {
+			msg = typeErr.Field + ": " + msg
+		}
+		return &pathError{path: typeErr.Field, msg: msg}
+	}
+	// Errors from types that decode themselves, such as durations, don't
+	// say where they are: find the service or section they come from.
+	return &pathError{path: d.locate(), msg: err.Error()}
+}
+
+// locate returns the path of the first service or section that fails to
+// decode on its own.
+func (d *document) locate() string {
+	try := func(v, out any) bool {
+		data, err := json.Marshal(v)
+		return err == nil && json.Unmarshal(data, out) != nil
+	}
+	if services, ok := d.values["services"].(map[string]any); ok {
+		for _, name := range sortedKeys(services) {
+			if try(services[name], &ServiceConfig{}) {
+				return "services." + name
+			}
+		}
+	}
+	if try(d.values["defaults"], &ServiceConfig{}) {
+		return "defaults"
+	}
+	if try(d.values["extends"], &Extends{}) {
+		return "extends"
+	}
+	return ""
+}
+
+var unmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+
+// unknownField returns the path of the first key in v that type t has no
+// field for, or "". Names match case-insensitively, as in encoding/json.
+func unknownField(v any, t reflect.Type, path string) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return ""
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, _ := v.(map[string]any)
+		for _, key := range sortedKeys(m) {
+			f, ok := jsonField(t, key)
+			if !ok {
+				return joinPath(path, key)
+			}
+			if p := unknownField(m[key], f.Type, joinPath(path, key)); p != "" {
+				return p
+			}
+		}
+	case reflect.Map:
+		m, _ := v.(map[string]any)
+		for _, key := range sortedKeys(m) {
+			if p := unknownField(m[key], t.Elem(), joinPath(path, key)); p != "" {
+				return p
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		s, _ := v.([]any)
+		for i, item := range s {
+			if p := unknownField(item, t.Elem(), joinPath(path, strconv.Itoa(i))); p != "" {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// jsonField returns the field of struct type t that JSON key decodes into.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fold reflect.StructField
+	found := false
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if name == key {
+			return f, true
+		}
+		if !found && strings.EqualFold(name, key) {
+			fold, found = f, true
+		}
+	}
+	return fold, found
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// offsetPosition returns the position of byte offset in data.
+func offsetPosition(data []byte, offset int) position {
+	offset = min(max(offset, 0), len(data))
+	lead := data[:offset]
+	return position{
+		line: bytes.Count(lead, []byte{'\n'}) + 1,
+		col:  offset - bytes.LastIndexByte(lead, '\n'),
+	}
+}
+
+func parseJSON(data []byte) (*document, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// Offset counts the offending byte.
+			return nil, &syntaxError{pos: offsetPosition(data, max(int(syntaxErr.Offset)-1, 0)), msg: syntaxErr.Error()}
+		}
+		if err == io.EOF {
+			return nil, errors.New("empty file")
+		}
+		return nil, err
+	}
+	values, ok := v.(map[string]any)
+	if !ok {
+		return nil, &syntaxError{pos: position{1, 1}, msg: "expected an object"}
+	}
+
+	// Walk the tokens again to find where each key is.
+	doc := &document{values: values, pos: make(map[string]position)}
+	dec = json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := tok.(string)
+				quoted, _ := json.Marshal(key)
+				doc.pos[joinPath(path, key)] = offsetPosition(data, int(dec.InputOffset())-len(quoted))
+				if err := walk(joinPath(path, key)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(joinPath(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	_ = walk("")
+	return doc, nil
+}
+
+func parseYAML(data []byte) (*document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	doc := &document{values: map[string]any{}, pos: make(map[string]position)}
+	if len(root.Content) == 0 {
+		return doc, nil
+	}
+	v, err := doc.yamlValue(root.Content[0], "")
+	if err != nil {
+		return nil, err
+	}
+	values, ok := v.(map[string]any)
+	if !ok {
+		n := root.Content[0]
+		return nil, &syntaxError{pos: position{n.Line, n.Column}, msg: "expected a mapping"}
+	}
+	doc.values = values
+	return doc, nil
+}
+
+// yamlValue converts n to plain values, recording key positions.
+func (d *document) yamlValue(n *yaml.Node, path string) (any, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return d.yamlValue(n.Alias, path)
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(n.Content))
+		for i, item := range n.Content {
+			v, err := d.yamlValue(item, joinPath(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		var merged []map[string]any
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, vn := n.Content[i], n.Content[i+1]
+			if k.Tag == "!!merge" {
+				// "<<: *base" or "<<: [*a, *b]"; keys set here win.
+				v, err := d.yamlValue(vn, path)
+				if err != nil {
+					return nil, err
+				}
+				list, isList := v.([]any)
+				if !isList {
+					list = []any{v}
+				}
+				for _, item := range list {
+					if mm, ok := item.(map[string]any); ok {
+						merged = append(merged, mm)
+					}
+				}
+				continue
+			}
+			if k.Kind != yaml.ScalarNode {
+				return nil, &syntaxError{pos: position{k.Line, k.Column}, msg: "keys must be strings"}
+			}
+			if _, dup := m[k.Value]; dup {
+				return nil, &syntaxError{pos: position{k.Line, k.Column}, msg: fmt.Sprintf("duplicate key %q", k.Value)}
+			}
+			p := joinPath(path, k.Value)
+			d.pos[p] = position{k.Line, k.Column}
+			v, err := d.yamlValue(vn, p)
+			if err != nil {
+				return nil, err
+			}
+			m[k.Value] = v
+		}
+		for _, mm := range merged {
+			for k, v := range mm {
+				if _, ok := m[k]; !ok {
+					m[k] = v
+				}
+			}
+		}
+		return m, nil
+	default:
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, &syntaxError{pos: position{n.Line, n.Column}, msg: err.Error()}
+		}
+		return v, nil
+	}
+}
+
+func parseTOML(data []byte) (*document, error) {
+	var values map[string]any
+	if err := toml.Unmarshal(data, &values); err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, col := decodeErr.Position()
+			return nil, &syntaxError{pos: position{line, col}, msg: decodeErr.Error()}
+		}
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	doc := &document{values: values, pos: make(map[string]position)}
+
+	// The document parsed, so the parser only has to find the keys.
+	p := &unstable.Parser{}
+	p.Reset(data)
+	table := ""
+	arrays := make(map[string]int) // [[array.tables]] seen so far
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.Table:
+			table = doc.tomlKey(p, "", e.Key())
+		case unstable.ArrayTable:
+			path := doc.tomlKey(p, "", e.Key())
+			table = joinPath(path, strconv.Itoa(arrays[path]))
+			arrays[path]++
+		case unstable.KeyValue:
+			doc.tomlKeyValue(p, table, e)
+		}
+	}
+	return doc, nil
+}
+
+// tomlKey records the position of each part of a dotted key under path
+// and returns the key's full path.
+func (d *document) tomlKey(p *unstable.Parser, path string, key unstable.Iterator) string {
+	for key.Next() {
+		k := key.Node()
+		path = joinPath(path, string(k.Data))
+		if _, ok := d.pos[path]; !ok {
+			start := p.Shape(k.Raw).Start
+			d.pos[path] = position{start.Line, start.Column}
+		}
+	}
+	return path
+}
+
+// tomlKeyValue records the positions of a key/value pair's keys,
+// including those in inline tables.
+func (d *document) tomlKeyValue(p *unstable.Parser, table string, kv *unstable.Node) {
+	path := d.tomlKey(p, table, kv.Key())
+	d.tomlValue(p, path, kv.Value())
+}
+
+func (d *document) tomlValue(p *unstable.Parser, path string, v *unstable.Node) {
+	switch v.Kind {
+	case unstable.InlineTable:
+		children := v.Children()
+		for children.Next() {
+			if c := children.Node(); c.Kind == unstable.KeyValue {
+				d.tomlKeyValue(p, path, c)
+			}
+		}
+	case unstable.Array:
+		children := v.Children()
+		for i := 0; children.Next(); i++ {
+			d.tomlValue(p, joinPath(path, strconv.Itoa(i)), children.Node())
+		}
+	}
+}
+
+// findConfigIn returns the project config in dir, as FindRoxyConfig does,
+// failing if there is none.
+func findConfigIn(dir string) (string, error) {
+	path, err := FindRoxyConfig(dir)
+	if err == nil && path == "" {
+		err = fmt.Errorf("no %s in %s", strings.Join(RoxyConfigFiles, ", "), dir)
+	}
+	return path, err
+}
+
+// configExts are the extensions tried for a template named without one.
+var configExts = []string{".yaml", ".yml", ".toml", ".json"}
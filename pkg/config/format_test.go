@@ -0,0 +1,178 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRoxyConfig_Formats(t *testing.T) {
+	want := map[string]ServiceConfig{
+		"web": {Cmd: "npm run dev", Port: 3000, TLS: true, Profiles: []string{"frontend"}},
+		"api": {Cmd: "go run .", Name: "api", Streaming: &StreamingConfig{FlushInterval: Duration(-time.Millisecond)}},
+	}
+	tests := []struct {
+		file    string
+		content string
+	}{
+		{"roxy.json", `{
+  "services": {
+    "web": {"cmd": "npm run dev", "port": 3000, "tls": true, "profiles": ["frontend"]},
+    "api": {"cmd": "go run .", "name": "api", "streaming": {"flush-interval": "-1ms"}}
+  }
+}`},
+		{"roxy.yaml", `# yaml-language-server: $schema=./roxy.schema.json
+services:
+  web:
+    cmd: npm run dev # comments are allowed
+    port: 3000
+    tls: true
+    profiles: [frontend]
+  api:
+    cmd: go run .
+    name: api
+    streaming:
+      flush-interval: "-1ms"
+`},
+		{"roxy.yml", `services:
+  web: &web
+    cmd: npm run dev
+    port: 3000
+    tls: true
+    profiles: [frontend]
+  api:
+    cmd: go run .
+    name: api
+    streaming: {flush-interval: "-1ms"}
+`},
+		{"roxy.toml", `#:schema ./roxy.schema.json
+[services.web]
+cmd = "npm run dev" # comments are allowed
+port = 3000
+tls = true
+profiles = ["frontend"]
+
+[services.api]
+cmd = "go run ."
+name = "api"
+streaming = { flush-interval = "-1ms" }
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, tt.file), tt.content)
+			cfg, err := LoadRoxyConfig(dir)
+			if err != nil {
+				t.Fatalf("LoadRoxyConfig: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.Services, want) {
+				t.Fatalf("services = %+v, want %+v", cfg.Services, want)
+			}
+		})
+	}
+}
+
+func TestLoadRoxyConfig_FormatErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "json unknown field",
+			file:    "roxy.json",
+			content: "{\n  \"services\": {\n    \"web\": {\"cmd\": \"x\", \"tsl\": true}\n  }\n}",
+			wantErr: `parse roxy.json:3:25: unknown field "tsl" in services.web`,
+		},
+		{
+			name:    "json syntax",
+			file:    "roxy.json",
+			content: "{\n  \"services\": {,}\n}",
+			wantErr: "parse roxy.json:2:16:",
+		},
+		{
+			name:    "yaml unknown field",
+			file:    "roxy.yaml",
+			content: "services:\n  web:\n    cmd: x\n    tsl: true\n",
+			wantErr: `parse roxy.yaml:4:5: unknown field "tsl" in services.web`,
+		},
+		{
+			name:    "yaml wrong type",
+			file:    "roxy.yaml",
+			content: "services:\n  web:\n    cmd: x\n    port: http\n",
+			wantErr: "parse roxy.yaml:4:5: services.web.port",
+		},
+		{
+			name:    "yaml duplicate key",
+			file:    "roxy.yaml",
+			content: "services:\n  web:\n    cmd: x\n    cmd: y\n",
+			wantErr: "parse roxy.yaml:4:5:",
+		},
+		{
+			name:    "toml unknown field",
+			file:    "roxy.toml",
+			content: "[services.web]\ncmd = \"x\"\ntsl = true\n",
+			wantErr: `parse roxy.toml:3:1: unknown field "tsl" in services.web`,
+		},
+		{
+			name:    "toml syntax",
+			file:    "roxy.toml",
+			content: "[services.web]\ncmd = \n",
+			wantErr: "parse roxy.toml:2:",
+		},
+		{
+			name:    "validation",
+			file:    "roxy.toml",
+			content: "[services.web]\ncmd = \"x\"\nport = 70000\n",
+			wantErr: `service "web": port must be between 1 and 65535 (defined at roxy.toml:1:11)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, tt.file), tt.content)
+			_, err := LoadRoxyConfig(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRoxyConfig_ExtendsAcrossFormats(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFile(t, filepath.Join(templatesDir(), "redis.toml"), "[services.cache]\ncmd = \"redis-server\"\nport = 6379\n")
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "roxy.yaml"), "extends: redis\nservices:\n  cache:\n    tls: true\n")
+	cfg, err := LoadRoxyConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadRoxyConfig: %v", err)
+	}
+	want := ServiceConfig{Cmd: "redis-server", Port: 6379, TLS: true}
+	if got := cfg.Services["cache"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("cache = %+v, want %+v", got, want)
+	}
+}
+
+func TestFindRoxyConfig(t *testing.T) {
+	dir := t.TempDir()
+	if path, err := FindRoxyConfig(dir); path != "" || err != nil {
+		t.Fatalf("FindRoxyConfig(empty) = %q, %v; want \"\", nil", path, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "roxy.toml"), "")
+	if path, err := FindRoxyConfig(dir); path != filepath.Join(dir, "roxy.toml") || err != nil {
+		t.Fatalf("FindRoxyConfig = %q, %v; want roxy.toml", path, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "roxy.json"), "{}")
+	if _, err := FindRoxyConfig(dir); err == nil || !strings.Contains(err.Error(), "roxy.toml and roxy.json") {
+		t.Fatalf("FindRoxyConfig with two files: error = %v", err)
+	}
+}
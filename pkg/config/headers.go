@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// HeaderVars are the placeholders that may appear in header rule values.
+// They are expanded by the proxy for every request.
+var HeaderVars = []string{
+	"domain",    // the route's domain, e.g. web.my-app.test
+	"client_ip", // the address of the connecting client
+	"scheme",    // "http" or "https", as received by the proxy
+	"port",      // the service's port
+}
+
+// placeholderPattern matches {name} placeholders in header values. JSON or
+// other brace-heavy values do not match because names are [a-z_] only.
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// HeaderRules rewrites headers on requests sent to a service and on the
+// responses it returns. Nil sections are skipped.
+type HeaderRules struct {
+	Request  *HeaderOps `json:"request,omitempty"`
+	Response *HeaderOps `json:"response,omitempty"`
+}
+
+// HeaderOps is one set of header edits, applied in the order remove, set,
+// add. Values may contain placeholders from HeaderVars, e.g. "{client_ip}".
+type HeaderOps struct {
+	// Remove deletes these headers.
+	Remove []string `json:"remove,omitempty"`
+	// Set replaces any existing values of each header.
+	Set map[string]string `json:"set,omitempty"`
+	// Add appends a value, keeping existing ones.
+	Add map[string]string `json:"add,omitempty"`
+}
+
+// Validate checks header names and placeholders in both sections.
+func (r *HeaderRules) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if err := r.Request.validate("headers.request"); err != nil {
+		return err
+	}
+	return r.Response.validate("headers.response")
+}
+
+func (o *HeaderOps) validate(field string) error {
+	if o == nil {
+		return nil
+	}
+	for _, name := range o.Remove {
+		if !validHeaderName(name) {
+			return fmt.Errorf("%s.remove: invalid header name %q", field, name)
+		}
+	}
+	for op, m := range map[string]map[string]string{"set": o.Set, "add": o.Add} {
+		for name, value := range m {
+			if !validHeaderName(name) {
+				return fmt.Errorf("%s.%s: invalid header name %q", field, op, name)
+			}
+			for _, match := range placeholderPattern.FindAllStringSubmatch(value, -1) {
+				if !slices.Contains(HeaderVars, match[1]) {
+					return fmt.Errorf("%s.%s[%q]: unknown placeholder %s", field, op, name, match[0])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is a non-empty RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c < 0x80 && slices.Contains([]byte("!#$%&'*+-.^_`|~"), byte(c)):
+		default:
+			return false
+		}
+	}
+	return true
+}
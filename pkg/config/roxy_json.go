@@ -1,26 +1,36 @@
 package config
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
 	"strings"
 )
 
+const maxPortNumber = 65535
+
+// Upstream protocols the proxy can speak to a service.
 const (
-	roxyConfigFile = "roxy.json"
-	maxPortNumber  = 65535
+	// ProtocolHTTP proxies to the service over HTTP/1.1 (the default).
+	ProtocolHTTP = "http"
+	// ProtocolH2C proxies to the service over cleartext HTTP/2 (e.g. gRPC).
+	ProtocolH2C = "h2c"
 )
 
-// RoxyConfig represents a roxy.json file with multiple service definitions.
+// RoxyConfig represents a project config (roxy.json, roxy.yaml or
+// roxy.toml) with multiple service definitions.
 type RoxyConfig struct {
 	Schema   string                   `json:"$schema,omitempty"`
+	Extends  Extends                  `json:"extends,omitempty"`
+	Defaults *ServiceConfig           `json:"defaults,omitempty"`
 	Services map[string]ServiceConfig `json:"services"`
+
+	files []string // every file read to build the config
 }
 
-// ServiceConfig defines a single service in roxy.json.
+// ServiceConfig defines a single service in the project config.
 type ServiceConfig struct {
 	Cmd        string `json:"cmd"`
 	Name       string `json:"name,omitempty"`
@@ -28,54 +38,267 @@ type ServiceConfig struct {
 	TLS        bool   `json:"tls,omitempty"`
 	ListenPort int    `json:"listen-port,omitempty"`
 	Public     bool   `json:"public,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+
+	// TrustForwarded keeps X-Forwarded-* and Forwarded headers sent by the
+	// client (e.g. a tunnel) instead of replacing them.
+	TrustForwarded bool `json:"trust-forwarded,omitempty"`
+
+	// ChangeOrigin sends "Host: localhost:<port>" to the service instead of
+	// the route's domain. RewriteOrigin additionally rewrites Origin and
+	// Referer headers that point at the route, and implies ChangeOrigin.
+	ChangeOrigin  bool `json:"change-origin,omitempty"`
+	RewriteOrigin bool `json:"rewrite-origin,omitempty"`
+
+	// Profiles limits the service to `roxy run -a --profile <p>` for one of
+	// the listed profiles. Services without profiles always start with -a.
+	Profiles []string `json:"profiles,omitempty"`
+
+	// TTY runs the service under a pseudo-terminal with `roxy run -a`, so it
+	// keeps colors and progress bars. Its output is no longer split into
+	// stdout and stderr.
+	TTY bool `json:"tty,omitempty"`
+
+	Streaming *StreamingConfig `json:"streaming,omitempty"`
+	Headers   *HeaderRules     `json:"headers,omitempty"`
+	CORS      *CORSConfig      `json:"cors,omitempty"`
+	Shaping   *ShapingConfig   `json:"shaping,omitempty"`
+	Faults    []FaultRule      `json:"faults,omitempty"`
 }
 
-// LoadRoxyJSON reads roxy.json from the given directory.
-// Returns nil, nil if the file doesn't exist.
-func LoadRoxyJSON(dir string) (*RoxyConfig, error) {
-	path := filepath.Join(dir, roxyConfigFile)
-	data, err := os.ReadFile(path)
+// StreamingConfig tunes how the proxy streams responses for a route.
+// Zero values keep the proxy defaults.
+type StreamingConfig struct {
+	// FlushInterval is how often buffered response data is flushed to the
+	// client. Negative flushes after every write. text/event-stream and
+	// unknown-length responses are always flushed immediately.
+	FlushInterval Duration `json:"flush-interval,omitempty"`
+	// ResponseHeaderTimeout bounds the wait for the upstream's response
+	// headers. The client gets a 504 when it expires.
+	ResponseHeaderTimeout Duration `json:"response-header-timeout,omitempty"`
+	// IdleTimeout aborts a response whose body produces no data for this long.
+	IdleTimeout Duration `json:"idle-timeout,omitempty"`
+}
+
+// Validate checks that the timeouts are not negative.
+func (c *StreamingConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("streaming.response-header-timeout must not be negative")
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("streaming.idle-timeout must not be negative")
+	}
+	return nil
+}
+
+// LoadRoxyConfig reads the project config from the given directory:
+// roxy.yaml, roxy.yml, roxy.toml or roxy.json (see FindRoxyConfig).
+// Returns nil, nil if there is none.
+//
+// The files in extends are loaded first, in order, and each file's
+// services and defaults are merged over theirs: objects key by key, other
+// values replaced. The defaults are then merged under every service. The
+// returned config holds the resulting services. Errors give the file,
+// line and column they are about.
+func LoadRoxyConfig(dir string) (*RoxyConfig, error) {
+	dir, err := filepath.Abs(dir)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
 		return nil, err
 	}
-
-	var cfg RoxyConfig
-	dec := json.NewDecoder(bytes.NewReader(data))
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&cfg); err != nil {
-		return nil, fmt.Errorf("parse roxy.json: %w", err)
+	path, err := FindRoxyConfig(dir)
+	if err != nil || path == "" {
+		return nil, err
 	}
 
-	if err := validateRoxyConfig(cfg); err != nil {
+	ld := &loader{dir: dir}
+	l, err := ld.load(path, nil)
+	if err != nil {
 		return nil, err
 	}
+	cfg, err := l.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ld.display(path), err)
+	}
+	cfg.files = ld.files
+
+	for _, name := range cfg.serviceNames() {
+		if err := validateService(name, cfg.Services[name]); err != nil {
+			return nil, fmt.Errorf("%w (%s)", err, l.origin(name))
+		}
+	}
+
+	return cfg, nil
+}
 
-	return &cfg, nil
+// LoadRoxyJSON reads the project config from the given directory.
+// Returns nil, nil if there is none.
+//
+// Deprecated: Use LoadRoxyConfig, which it calls. Despite its name it
+// also reads roxy.yaml and roxy.toml.
+func LoadRoxyJSON(dir string) (*RoxyConfig, error) {
+	return LoadRoxyConfig(dir)
 }
 
-func validateRoxyConfig(cfg RoxyConfig) error {
-	for name, svc := range cfg.Services {
-		if strings.TrimSpace(svc.Cmd) == "" {
-			return fmt.Errorf("service %q: cmd is required", name)
+func validateService(name string, svc ServiceConfig) error {
+	if strings.TrimSpace(svc.Cmd) == "" {
+		return fmt.Errorf("service %q: cmd is required", name)
+	}
+
+	if svc.Port != 0 {
+		if err := validatePort(name, "port", svc.Port); err != nil {
+			return err
+		}
+	}
+
+	if svc.ListenPort != 0 {
+		if err := validatePort(name, "listen-port", svc.ListenPort); err != nil {
+			return err
 		}
+	}
+
+	if err := ValidateProtocol(svc.Protocol); err != nil {
+		return fmt.Errorf("service %q: %w", name, err)
+	}
+	if svc.Protocol == ProtocolH2C && svc.ListenPort != 0 {
+		return fmt.Errorf("service %q: protocol %q cannot be combined with listen-port", name, svc.Protocol)
+	}
+
+	if err := svc.Streaming.Validate(); err != nil {
+		return fmt.Errorf("service %q: %w", name, err)
+	}
+
+	if err := svc.Headers.Validate(); err != nil {
+		return fmt.Errorf("service %q: %w", name, err)
+	}
 
-		if svc.Port != 0 {
-			if err := validatePort(name, "port", svc.Port); err != nil {
-				return err
+	if err := svc.CORS.Validate(); err != nil {
+		return fmt.Errorf("service %q: %w", name, err)
+	}
+
+	if err := svc.Shaping.Validate(); err != nil {
+		return fmt.Errorf("service %q: %w", name, err)
+	}
+
+	for i, rule := range svc.Faults {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("service %q: faults[%d]: %w", name, i, err)
+		}
+	}
+
+	for _, profile := range svc.Profiles {
+		if strings.TrimSpace(profile) == "" || strings.Contains(profile, ",") {
+			return fmt.Errorf("service %q: invalid profile %q", name, profile)
+		}
+	}
+
+	return nil
+}
+
+// Select returns a copy of the config with only the services to start.
+// Named services are selected as given. Without names, the selection is
+// every service without profiles plus those in any of profiles, like
+// `docker compose --profile`.
+func (c *RoxyConfig) Select(names, profiles []string) (*RoxyConfig, error) {
+	selected := &RoxyConfig{Schema: c.Schema, Services: make(map[string]ServiceConfig), files: c.files}
+
+	if len(names) > 0 {
+		for _, name := range names {
+			svc, ok := c.Services[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown service %q in %s (available: %s)", name, c.FileName(), strings.Join(c.serviceNames(), ", "))
 			}
+			selected.Services[name] = svc
+		}
+		return selected, nil
+	}
+
+	active := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		active[p] = true
+	}
+	known := make(map[string]bool)
+	for name, svc := range c.Services {
+		include := len(svc.Profiles) == 0
+		for _, p := range svc.Profiles {
+			known[p] = true
+			include = include || active[p]
 		}
+		if include {
+			selected.Services[name] = svc
+		}
+	}
 
-		if svc.ListenPort != 0 {
-			if err := validatePort(name, "listen-port", svc.ListenPort); err != nil {
-				return err
+	for _, p := range profiles {
+		if !known[p] {
+			available := make([]string, 0, len(known))
+			for k := range known {
+				available = append(available, k)
 			}
+			sort.Strings(available)
+			if len(available) == 0 {
+				return nil, fmt.Errorf("unknown profile %q: no service in %s has profiles", p, c.FileName())
+			}
+			return nil, fmt.Errorf("unknown profile %q in %s (available: %s)", p, c.FileName(), strings.Join(available, ", "))
 		}
 	}
+	if len(selected.Services) == 0 && len(c.Services) > 0 {
+		return nil, fmt.Errorf("every service in %s has profiles; choose some with --profile", c.FileName())
+	}
+	return selected, nil
+}
 
-	return nil
+// Files returns the paths of the project config and every file it
+// extends, directly or not.
+func (c *RoxyConfig) Files() []string {
+	return slices.Clone(c.files)
+}
+
+// FileName returns the name of the file the config was loaded from, such
+// as "roxy.yaml", for messages. It is "the project config" for a config
+// not read from a file.
+func (c *RoxyConfig) FileName() string {
+	if len(c.files) == 0 {
+		return "the project config"
+	}
+	return filepath.Base(c.files[0])
+}
+
+// Diff compares the services of c with those of next and returns the
+// names, sorted, of services only in next, only in c, and in both with a
+// different definition. Profiles are ignored: they only affect which
+// services are selected.
+func (c *RoxyConfig) Diff(next *RoxyConfig) (added, removed, changed []string) {
+	for _, name := range next.serviceNames() {
+		svc, ok := c.Services[name]
+		if !ok {
+			added = append(added, name)
+			continue
+		}
+		newSvc := next.Services[name]
+		svc.Profiles, newSvc.Profiles = nil, nil
+		if !reflect.DeepEqual(svc, newSvc) {
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range c.serviceNames() {
+		if _, ok := next.Services[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed, changed
+}
+
+// serviceNames returns the names of the services, sorted.
+func (c *RoxyConfig) serviceNames() []string {
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func validatePort(serviceName, field string, value int) error {
@@ -84,3 +307,13 @@ func validatePort(serviceName, field string, value int) error {
 	}
 	return nil
 }
+
+// ValidateProtocol reports whether p is a supported upstream protocol.
+// The empty string is accepted and means ProtocolHTTP.
+func ValidateProtocol(p string) error {
+	switch p {
+	case "", ProtocolHTTP, ProtocolH2C:
+		return nil
+	}
+	return fmt.Errorf("protocol must be %q or %q, got %q", ProtocolHTTP, ProtocolH2C, p)
+}
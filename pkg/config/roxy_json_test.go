@@ -3,8 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadRoxyJSON_ParsesPortField(t *testing.T) {
@@ -101,3 +103,242 @@ func TestLoadRoxyJSON_RejectsUnknownFields(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestLoadRoxyJSON_Protocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  string
+		wantErr  string
+		wantProt string
+	}{
+		{"h2c", `{"cmd": "go run ./grpc", "protocol": "h2c"}`, "", "h2c"},
+		{"explicit http", `{"cmd": "npm run dev", "protocol": "http"}`, "", "http"},
+		{"default", `{"cmd": "npm run dev"}`, "", ""},
+		{"unknown", `{"cmd": "npm run dev", "protocol": "http3"}`, "protocol must be", ""},
+		{"h2c with listen-port", `{"cmd": "redis-server", "protocol": "h2c", "listen-port": 6379}`, "cannot be combined with listen-port", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := `{"services": {"api": ` + tt.service + `}}`
+			if err := os.WriteFile(filepath.Join(dir, "roxy.json"), []byte(content), 0644); err != nil {
+				t.Fatalf("write roxy.json: %v", err)
+			}
+
+			cfg, err := LoadRoxyJSON(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadRoxyJSON returned error: %v", err)
+			}
+			if got := cfg.Services["api"].Protocol; got != tt.wantProt {
+				t.Fatalf("Protocol = %q, want %q", got, tt.wantProt)
+			}
+		})
+	}
+}
+
+func TestLoadRoxyJSON_Streaming(t *testing.T) {
+	dir := t.TempDir()
+	content := `{
+	  "services": {
+	    "api": {
+	      "cmd": "npm run dev",
+	      "streaming": {
+	        "flush-interval": "-1ms",
+	        "response-header-timeout": "30s",
+	        "idle-timeout": "5m"
+	      }
+	    }
+	  }
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "roxy.json"), []byte(content), 0644); err != nil {
+		t.Fatalf("write roxy.json: %v", err)
+	}
+
+	cfg, err := LoadRoxyJSON(dir)
+	if err != nil {
+		t.Fatalf("LoadRoxyJSON returned error: %v", err)
+	}
+
+	st := cfg.Services["api"].Streaming
+	if st == nil {
+		t.Fatal("expected streaming config, got nil")
+	}
+	if time.Duration(st.FlushInterval) != -time.Millisecond {
+		t.Errorf("FlushInterval = %v, want -1ms", time.Duration(st.FlushInterval))
+	}
+	if time.Duration(st.ResponseHeaderTimeout) != 30*time.Second {
+		t.Errorf("ResponseHeaderTimeout = %v, want 30s", time.Duration(st.ResponseHeaderTimeout))
+	}
+	if time.Duration(st.IdleTimeout) != 5*time.Minute {
+		t.Errorf("IdleTimeout = %v, want 5m", time.Duration(st.IdleTimeout))
+	}
+}
+
+func TestLoadRoxyJSON_RejectsBadStreamingDurations(t *testing.T) {
+	tests := []struct {
+		name      string
+		streaming string
+		wantErr   string
+	}{
+		{"not a duration", `{"idle-timeout": "soon"}`, "invalid duration"},
+		{"number", `{"idle-timeout": 30}`, "duration must be a string"},
+		{"negative timeout", `{"response-header-timeout": "-1s"}`, "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := `{"services": {"api": {"cmd": "npm run dev", "streaming": ` + tt.streaming + `}}}`
+			if err := os.WriteFile(filepath.Join(dir, "roxy.json"), []byte(content), 0644); err != nil {
+				t.Fatalf("write roxy.json: %v", err)
+			}
+
+			_, err := LoadRoxyJSON(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRoxyJSON_HostRewriting(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"services": {
+		"web": {"cmd": "vite", "change-origin": true},
+		"admin": {"cmd": "python manage.py runserver $PORT", "rewrite-origin": true, "trust-forwarded": true}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, "roxy.json"), []byte(content), 0644); err != nil {
+		t.Fatalf("write roxy.json: %v", err)
+	}
+
+	cfg, err := LoadRoxyJSON(dir)
+	if err != nil {
+		t.Fatalf("LoadRoxyJSON returned error: %v", err)
+	}
+	if web := cfg.Services["web"]; !web.ChangeOrigin || web.RewriteOrigin {
+		t.Errorf("web = %+v, want change-origin only", web)
+	}
+	if admin := cfg.Services["admin"]; !admin.RewriteOrigin || !admin.TrustForwarded {
+		t.Errorf("admin = %+v, want rewrite-origin and trust-forwarded", admin)
+	}
+}
+
+func TestLoadRoxyJSON_Headers(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		wantErr string
+	}{
+		{"valid", `{"request": {"set": {"X-Client": "{client_ip}"}, "remove": ["X-Debug"]}, "response": {"add": {"Vary": "Origin"}}}`, ""},
+		{"json value", `{"request": {"set": {"X-Json": "{\"a\": 1}"}}}`, ""},
+		{"bad name", `{"request": {"set": {"Bad Header": "x"}}}`, "invalid header name"},
+		{"bad remove", `{"response": {"remove": [""]}}`, "invalid header name"},
+		{"unknown placeholder", `{"request": {"add": {"X-User": "{user}"}}}`, "unknown placeholder {user}"},
+		{"unknown section", `{"upstream": {}}`, "unknown field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := `{"services": {"api": {"cmd": "npm run dev", "headers": ` + tt.headers + `}}}`
+			if err := os.WriteFile(filepath.Join(dir, "roxy.json"), []byte(content), 0644); err != nil {
+				t.Fatalf("write roxy.json: %v", err)
+			}
+
+			cfg, err := LoadRoxyJSON(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadRoxyJSON returned error: %v", err)
+			}
+			if cfg.Services["api"].Headers == nil {
+				t.Fatal("expected header rules, got nil")
+			}
+		})
+	}
+}
+
+func TestRoxyConfigSelect(t *testing.T) {
+	cfg := &RoxyConfig{Services: map[string]ServiceConfig{
+		"web":      {Cmd: "npm run dev"},
+		"mock-api": {Cmd: "npm run mock", Profiles: []string{"frontend"}},
+		"api":      {Cmd: "go run ./api", Profiles: []string{"backend"}},
+		"worker":   {Cmd: "go run ./worker", Profiles: []string{"backend", "jobs"}},
+	}, files: []string{"/app/roxy.yaml"}}
+
+	tests := []struct {
+		name     string
+		names    []string
+		profiles []string
+		want     []string
+		wantErr  string
+	}{
+		{"default", nil, nil, []string{"web"}, ""},
+		{"one profile", nil, []string{"backend"}, []string{"api", "web", "worker"}, ""},
+		{"two profiles", nil, []string{"frontend", "jobs"}, []string{"mock-api", "web", "worker"}, ""},
+		{"named", []string{"api", "mock-api"}, nil, []string{"api", "mock-api"}, ""},
+		{"unknown service", []string{"db"}, nil, nil, `unknown service "db"`},
+		{"unknown profile", nil, []string{"ops"}, nil, `unknown profile "ops" in roxy.yaml (available: backend, frontend, jobs)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.Select(tt.names, tt.profiles)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Select returned error: %v", err)
+			}
+			if names := got.serviceNames(); strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("selected %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	allProfiled := &RoxyConfig{Services: map[string]ServiceConfig{
+		"api": {Cmd: "go run ./api", Profiles: []string{"backend"}},
+	}}
+	if _, err := allProfiled.Select(nil, nil); err == nil || !strings.Contains(err.Error(), "--profile") {
+		t.Errorf("error = %v, want a hint to use --profile", err)
+	}
+}
+
+func TestRoxyConfigDiff(t *testing.T) {
+	old := &RoxyConfig{Services: map[string]ServiceConfig{
+		"web":    {Cmd: "npm run dev", TLS: true},
+		"api":    {Cmd: "go run ./api", Headers: &HeaderRules{Request: &HeaderOps{Set: map[string]string{"X-A": "1"}}}},
+		"worker": {Cmd: "go run ./worker"},
+		"redis":  {Cmd: "redis-server", Profiles: []string{"data"}},
+	}}
+	next := &RoxyConfig{Services: map[string]ServiceConfig{
+		"web":   {Cmd: "npm run dev", TLS: true},
+		"api":   {Cmd: "go run ./api", Headers: &HeaderRules{Request: &HeaderOps{Set: map[string]string{"X-A": "2"}}}},
+		"redis": {Cmd: "redis-server", Profiles: []string{"data", "cache"}},
+		"db":    {Cmd: "postgres"},
+	}}
+
+	added, removed, changed := old.Diff(next)
+	if !reflect.DeepEqual(added, []string{"db"}) {
+		t.Errorf("added = %v, want [db]", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"worker"}) {
+		t.Errorf("removed = %v, want [worker]", removed)
+	}
+	if !reflect.DeepEqual(changed, []string{"api"}) {
+		t.Errorf("changed = %v, want [api]", changed)
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ShapingConfig simulates a slow or unreliable network on a route. It
+// applies to HTTP requests, WebSocket handshakes and TCP connections.
+// Explicit fields override the values of the named Profile.
+type ShapingConfig struct {
+	// Profile names a preset from ShapingProfiles, e.g. "3g".
+	Profile string `json:"profile,omitempty"`
+	// Latency is added before each request (or TCP connection) is forwarded.
+	Latency Duration `json:"latency,omitempty"`
+	// Jitter randomly varies Latency by up to ± this much.
+	Jitter Duration `json:"jitter,omitempty"`
+	// DownKbps caps the response (service → client) rate in kilobits/s.
+	DownKbps int `json:"down-kbps,omitempty"`
+	// UpKbps caps the request (client → service) rate in kilobits/s.
+	UpKbps int `json:"up-kbps,omitempty"`
+	// ResetRate is the probability (0-1) that a request or connection is
+	// reset instead of being forwarded.
+	ResetRate float64 `json:"reset-rate,omitempty"`
+}
+
+// ShapingProfiles are the presets accepted by ShapingConfig.Profile and
+// `roxy shape --profile`. They are loosely modelled on browser devtools.
+var ShapingProfiles = map[string]ShapingConfig{
+	"slow-3g": {Latency: Duration(2 * time.Second), Jitter: Duration(500 * time.Millisecond), DownKbps: 400, UpKbps: 400},
+	"3g":      {Latency: Duration(300 * time.Millisecond), Jitter: Duration(100 * time.Millisecond), DownKbps: 1600, UpKbps: 750},
+	"4g":      {Latency: Duration(50 * time.Millisecond), Jitter: Duration(20 * time.Millisecond), DownKbps: 9000, UpKbps: 9000},
+	"lossy":   {Latency: Duration(100 * time.Millisecond), Jitter: Duration(50 * time.Millisecond), ResetRate: 0.05},
+}
+
+// ShapingProfileNames returns the preset names in sorted order.
+func ShapingProfileNames() []string {
+	names := make([]string, 0, len(ShapingProfiles))
+	for name := range ShapingProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the effective settings: the profile's values with any
+// explicitly set fields layered on top. A nil config resolves to zero.
+func (c *ShapingConfig) Resolve() ShapingConfig {
+	if c == nil {
+		return ShapingConfig{}
+	}
+	out := ShapingProfiles[c.Profile]
+	out.Profile = c.Profile
+	if c.Latency != 0 {
+		out.Latency = c.Latency
+	}
+	if c.Jitter != 0 {
+		out.Jitter = c.Jitter
+	}
+	if c.DownKbps != 0 {
+		out.DownKbps = c.DownKbps
+	}
+	if c.UpKbps != 0 {
+		out.UpKbps = c.UpKbps
+	}
+	if c.ResetRate != 0 {
+		out.ResetRate = c.ResetRate
+	}
+	return out
+}
+
+// Enabled reports whether the resolved settings change anything.
+func (c ShapingConfig) Enabled() bool {
+	return c.Latency > 0 || c.DownKbps > 0 || c.UpKbps > 0 || c.ResetRate > 0
+}
+
+// String summarises the resolved settings, e.g. "3g: 300ms ±100ms, ↓1600kbps ↑750kbps".
+func (c ShapingConfig) String() string {
+	var parts []string
+	if c.Latency > 0 {
+		s := time.Duration(c.Latency).String()
+		if c.Jitter > 0 {
+			s += " ±" + time.Duration(c.Jitter).String()
+		}
+		parts = append(parts, s)
+	}
+	var rates []string
+	if c.DownKbps > 0 {
+		rates = append(rates, fmt.Sprintf("↓%dkbps", c.DownKbps))
+	}
+	if c.UpKbps > 0 {
+		rates = append(rates, fmt.Sprintf("↑%dkbps", c.UpKbps))
+	}
+	if len(rates) > 0 {
+		parts = append(parts, strings.Join(rates, " "))
+	}
+	if c.ResetRate > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% resets", c.ResetRate*100))
+	}
+	s := strings.Join(parts, ", ")
+	if s == "" {
+		s = "off"
+	}
+	if c.Profile != "" {
+		s = c.Profile + ": " + s
+	}
+	return s
+}
+
+// Validate checks the profile name and value ranges.
+func (c *ShapingConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Profile != "" {
+		if _, ok := ShapingProfiles[c.Profile]; !ok {
+			return fmt.Errorf("shaping.profile must be one of %s, got %q",
+				strings.Join(ShapingProfileNames(), ", "), c.Profile)
+		}
+	}
+	if c.Latency < 0 || c.Jitter < 0 {
+		return fmt.Errorf("shaping.latency and shaping.jitter must not be negative")
+	}
+	if c.DownKbps < 0 || c.UpKbps < 0 {
+		return fmt.Errorf("shaping.down-kbps and shaping.up-kbps must not be negative")
+	}
+	if c.ResetRate < 0 || c.ResetRate > 1 {
+		return fmt.Errorf("shaping.reset-rate must be between 0 and 1")
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShapingConfigResolve(t *testing.T) {
+	cfg := &ShapingConfig{Profile: "3g", Latency: Duration(time.Second)}
+	got := cfg.Resolve()
+
+	if time.Duration(got.Latency) != time.Second {
+		t.Errorf("Latency = %v, want the explicit 1s", time.Duration(got.Latency))
+	}
+	if got.DownKbps != ShapingProfiles["3g"].DownKbps {
+		t.Errorf("DownKbps = %d, want the profile's %d", got.DownKbps, ShapingProfiles["3g"].DownKbps)
+	}
+	if !got.Enabled() {
+		t.Error("expected resolved 3g profile to be enabled")
+	}
+
+	var none *ShapingConfig
+	if none.Resolve().Enabled() {
+		t.Error("nil config should resolve to no shaping")
+	}
+	if s := none.Resolve().String(); s != "off" {
+		t.Errorf("String() = %q, want %q", s, "off")
+	}
+	if s := (&ShapingConfig{Profile: "lossy"}).Resolve().String(); !strings.HasPrefix(s, "lossy: ") || !strings.Contains(s, "5% resets") {
+		t.Errorf("String() = %q, want the lossy summary", s)
+	}
+}
+
+func TestShapingConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ShapingConfig
+		wantErr string
+	}{
+		{"profile", ShapingConfig{Profile: "slow-3g"}, ""},
+		{"custom", ShapingConfig{Latency: Duration(time.Second), DownKbps: 100, ResetRate: 0.5}, ""},
+		{"unknown profile", ShapingConfig{Profile: "5g"}, "shaping.profile must be one of"},
+		{"negative latency", ShapingConfig{Latency: -1}, "must not be negative"},
+		{"negative rate", ShapingConfig{UpKbps: -1}, "must not be negative"},
+		{"reset rate above 1", ShapingConfig{ResetRate: 1.5}, "between 0 and 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}